@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/jbrcoleman/k8s-env-provisioner/api/middleware"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+)
+
+// requirePrincipal returns the authenticated caller, writing a 401 response
+// and returning false if the request carries none
+func requirePrincipal(w http.ResponseWriter, r *http.Request) (middleware.Principal, bool) {
+	principal, ok := middleware.PrincipalFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return principal, false
+	}
+	return principal, true
+}
+
+// principalTeams returns the IDs of the teams the principal belongs to,
+// sorted for stable store queries
+func principalTeams(principal middleware.Principal) []string {
+	teams := make([]string, 0, len(principal.Teams))
+	for teamID := range principal.Teams {
+		teams = append(teams, teamID)
+	}
+	sort.Strings(teams)
+	return teams
+}
+
+// isTeamMember reports whether the principal belongs to the team
+func isTeamMember(principal middleware.Principal, teamID string) bool {
+	_, ok := principal.Teams[teamID]
+	return teamID != "" && ok
+}
+
+// canManageTeam reports whether the principal may change the team and its
+// membership: platform admins and the team's maintainers may
+func canManageTeam(principal middleware.Principal, teamID string) bool {
+	return principal.Admin || principal.Teams[teamID] == models.TeamRoleMaintainer
+}
+
+// canAccessEnvironment reports whether the principal may see and act on the
+// environment: platform admins may act on everything, everyone else only on
+// environments they own or that belong to one of their teams
+func canAccessEnvironment(principal middleware.Principal, env models.Environment) bool {
+	if principal.Admin {
+		return true
+	}
+	if env.UserID != "" && env.UserID == principal.Subject {
+		return true
+	}
+	return isTeamMember(principal, env.TeamID)
+}
+
+// requireAdmin returns the authenticated caller if they are a platform admin,
+// writing a 401 or 403 response and returning false otherwise
+func requireAdmin(w http.ResponseWriter, r *http.Request) (middleware.Principal, bool) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return principal, false
+	}
+	if !principal.Admin {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return principal, false
+	}
+	return principal, true
+}
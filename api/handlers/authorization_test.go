@@ -0,0 +1,352 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/jobs"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/middleware"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/store"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/terraform"
+)
+
+// Callers of the authorization tests. The owner owns the environments under
+// test, which belong to team-a; the member belongs to team-a, the stranger
+// only to team-b.
+var (
+	owner    = middleware.Principal{Subject: "owner"}
+	member   = middleware.Principal{Subject: "member", Teams: map[string]string{"team-a": models.TeamRoleMember}}
+	stranger = middleware.Principal{Subject: "stranger", Teams: map[string]string{"team-b": models.TeamRoleMember}}
+	admin    = middleware.Principal{Subject: "admin", Admin: true}
+)
+
+// testTemplateID names the template the test environments are created from
+const testTemplateID = "small"
+
+// newTestHandler returns an environment handler backed by Bolt stores in a
+// temporary directory. Its queue is not started, so submitted jobs stay
+// pending.
+func newTestHandler(t *testing.T) *EnvironmentHandler {
+	t.Helper()
+
+	db, err := store.OpenBolt(filepath.Join(t.TempDir(), "provisioner.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	environmentStore, err := store.NewBoltEnvironmentStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	templateStore, err := store.NewBoltTemplateStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobStore, err := store.NewBoltJobStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventStore, err := store.NewBoltEventStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logStore, err := store.NewBoltLogStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockStore, err := store.NewBoltLockStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	planStore, err := store.NewBoltPlanStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = templateStore.Create(context.Background(), &models.ClusterTemplate{
+		ID:           testTemplateID,
+		Name:         "Small",
+		Region:       "us-west-2",
+		MinNodes:     1,
+		MaxNodes:     3,
+		DesiredNodes: 2,
+		CreatedAt:    time.Now().UTC(),
+		UpdatedAt:    time.Now().UTC(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locker := jobs.NewLocker(lockStore, "test", time.Minute)
+	queue := jobs.NewQueue(jobStore, locker, jobs.Config{WorkerID: "test", Workers: 1})
+	return NewEnvironmentHandler(environmentStore, templateStore, queue, locker, eventStore, logStore, planStore, terraform.Provisioners{}, validator.New())
+}
+
+// seedEnvironment stores an ACTIVE environment of userID and teamID
+func seedEnvironment(t *testing.T, h *EnvironmentHandler, name, userID, teamID string) models.Environment {
+	t.Helper()
+
+	now := time.Now().UTC()
+	environment := models.Environment{
+		ID:             name + "-id",
+		Name:           name,
+		TemplateID:     testTemplateID,
+		UserID:         userID,
+		TeamID:         teamID,
+		ResourceLimits: testResourceLimits(),
+		Status:         models.StateActive,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if err := h.store.Create(context.Background(), &environment); err != nil {
+		t.Fatal(err)
+	}
+	return environment
+}
+
+// testResourceLimits returns resource limits that pass validation
+func testResourceLimits() models.ResourceLimits {
+	return models.ResourceLimits{
+		CPU:              "2",
+		Memory:           "4Gi",
+		Storage:          "20Gi",
+		MaxNodeCount:     3,
+		MaxNamespaces:    5,
+		MaxLoadBalancers: 1,
+	}
+}
+
+// serve runs a handler on a request made by principal, or by an
+// unauthenticated caller if principal is nil, with the {id} path variable set
+// to envID
+func serve(handler http.HandlerFunc, method, target string, body interface{}, principal *middleware.Principal, envID string) *httptest.ResponseRecorder {
+	var payload bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&payload).Encode(body)
+	}
+
+	r := httptest.NewRequest(method, target, &payload)
+	if principal != nil {
+		r = r.WithContext(middleware.WithPrincipal(r.Context(), *principal))
+	}
+	if envID != "" {
+		r = mux.SetURLVars(r, map[string]string{"id": envID})
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestGetEnvironmentAuthorization(t *testing.T) {
+	h := newTestHandler(t)
+	environment := seedEnvironment(t, h, "team-env", owner.Subject, "team-a")
+
+	tests := []struct {
+		name      string
+		principal *middleware.Principal
+		want      int
+	}{
+		{"owner", &owner, http.StatusOK},
+		{"team member", &member, http.StatusOK},
+		{"unrelated user", &stranger, http.StatusForbidden},
+		{"admin", &admin, http.StatusOK},
+		{"unauthenticated", nil, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h.GetEnvironment, http.MethodGet, "/environments/"+environment.ID, nil, tt.principal, environment.ID)
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestUpdateEnvironmentAuthorization(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal middleware.Principal
+		patch     map[string]interface{}
+		want      int
+	}{
+		{"owner", owner, map[string]interface{}{"description": "changed"}, http.StatusOK},
+		{"team member", member, map[string]interface{}{"description": "changed"}, http.StatusOK},
+		{"unrelated user", stranger, map[string]interface{}{"description": "changed"}, http.StatusForbidden},
+		{"admin", admin, map[string]interface{}{"description": "changed"}, http.StatusOK},
+		{"member handing to another team", member, map[string]interface{}{"teamId": "team-b"}, http.StatusForbidden},
+		{"admin handing to another team", admin, map[string]interface{}{"teamId": "team-b"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t)
+			environment := seedEnvironment(t, h, "team-env", owner.Subject, "team-a")
+
+			w := serve(h.UpdateEnvironment, http.MethodPatch, "/environments/"+environment.ID, tt.patch, &tt.principal, environment.ID)
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+
+			// A rejected patch changes nothing
+			stored, err := h.store.Get(context.Background(), environment.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == http.StatusForbidden && stored.Version != environment.Version {
+				t.Fatalf("forbidden patch saved version %d", stored.Version)
+			}
+		})
+	}
+}
+
+func TestDeleteEnvironmentAuthorization(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal middleware.Principal
+		want      int
+	}{
+		{"owner", owner, http.StatusNoContent},
+		{"team member", member, http.StatusNoContent},
+		{"unrelated user", stranger, http.StatusForbidden},
+		{"admin", admin, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t)
+			environment := seedEnvironment(t, h, "team-env", owner.Subject, "team-a")
+
+			w := serve(h.DeleteEnvironment, http.MethodDelete, "/environments/"+environment.ID, nil, &tt.principal, environment.ID)
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+
+			// The environment stays visible until its destroy job is done
+			wantStatus := models.StateDeleting
+			if tt.want == http.StatusForbidden {
+				wantStatus = models.StateActive
+			}
+			w = serve(h.GetEnvironment, http.MethodGet, "/environments/"+environment.ID, nil, &owner, environment.ID)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d after delete, want %d", w.Code, http.StatusOK)
+			}
+			var stored models.Environment
+			json.NewDecoder(w.Body).Decode(&stored)
+			if stored.Status != wantStatus || stored.DeletedAt != nil {
+				t.Fatalf("environment is %s, deleted at %v; want %s and not deleted", stored.Status, stored.DeletedAt, wantStatus)
+			}
+		})
+	}
+}
+
+func TestListEnvironmentsAuthorization(t *testing.T) {
+	h := newTestHandler(t)
+	seedEnvironment(t, h, "owner-team", owner.Subject, "team-a")
+	seedEnvironment(t, h, "owner-solo", owner.Subject, "")
+	seedEnvironment(t, h, "other-team", "other", "team-a")
+	seedEnvironment(t, h, "stranger-solo", stranger.Subject, "")
+
+	tests := []struct {
+		name      string
+		principal middleware.Principal
+		query     string
+		want      int
+		names     []string
+	}{
+		{"owner", owner, "", http.StatusOK, []string{"owner-solo", "owner-team"}},
+		{"team member", member, "", http.StatusOK, []string{"other-team", "owner-team"}},
+		{"unrelated user", stranger, "", http.StatusOK, []string{"stranger-solo"}},
+		{"admin", admin, "", http.StatusOK, []string{"other-team", "owner-solo", "owner-team", "stranger-solo"}},
+		{"team member by team", member, "?teamId=team-a", http.StatusOK, []string{"other-team", "owner-team"}},
+		{"unrelated user by other user", stranger, "?userId=owner", http.StatusForbidden, nil},
+		{"unrelated user by other team", stranger, "?teamId=team-a", http.StatusForbidden, nil},
+		{"admin by user", admin, "?userId=owner", http.StatusOK, []string{"owner-solo", "owner-team"}},
+		{"admin by team", admin, "?teamId=team-a", http.StatusOK, []string{"other-team", "owner-team"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h.ListEnvironments, http.MethodGet, "/environments"+tt.query, nil, &tt.principal, "")
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+
+			var list models.EnvironmentList
+			if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			for _, environment := range list.Items {
+				names = append(names, environment.Name)
+			}
+			sort.Strings(names)
+			if len(names) != len(tt.names) {
+				t.Fatalf("got %v, want %v", names, tt.names)
+			}
+			for i := range names {
+				if names[i] != tt.names[i] {
+					t.Fatalf("got %v, want %v", names, tt.names)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateEnvironmentAuthorization(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal middleware.Principal
+		userID    string
+		teamID    string
+		want      int
+		wantOwner string
+	}{
+		{"owner for themselves", owner, "", "", http.StatusCreated, owner.Subject},
+		{"owner naming themselves", owner, owner.Subject, "", http.StatusCreated, owner.Subject},
+		{"user for another user", stranger, owner.Subject, "", http.StatusForbidden, ""},
+		{"team member for their team", member, "", "team-a", http.StatusCreated, member.Subject},
+		{"unrelated user for a team", stranger, "", "team-a", http.StatusForbidden, ""},
+		{"admin for another user", admin, owner.Subject, "", http.StatusCreated, owner.Subject},
+		{"admin for any team", admin, "", "team-a", http.StatusCreated, admin.Subject},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t)
+			request := models.EnvironmentRequest{
+				Name:           "new-env",
+				TemplateID:     testTemplateID,
+				UserID:         tt.userID,
+				TeamID:         tt.teamID,
+				ResourceLimits: testResourceLimits(),
+			}
+
+			w := serve(h.CreateEnvironment, http.MethodPost, "/environments", request, &tt.principal, "")
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want != http.StatusCreated {
+				return
+			}
+
+			var created models.Environment
+			if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+				t.Fatal(err)
+			}
+			if created.UserID != tt.wantOwner || created.TeamID != tt.teamID {
+				t.Fatalf("created for user %q and team %q, want %q and %q", created.UserID, created.TeamID, tt.wantOwner, tt.teamID)
+			}
+		})
+	}
+}
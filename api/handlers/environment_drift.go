@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/store"
+)
+
+// ReconcileEnvironment re-applies an environment's configuration, undoing
+// changes made to its infrastructure outside the provisioner
+func (h *EnvironmentHandler) ReconcileEnvironment(w http.ResponseWriter, r *http.Request) {
+	environment, ok := h.loadEnvironment(w, r)
+	if !ok {
+		return
+	}
+
+	// Reject the reconcile if the caller's copy is stale
+	if !ifMatch(r, environmentETag(environment)) {
+		http.Error(w, "Environment has been modified", http.StatusPreconditionFailed)
+		return
+	}
+
+	// Reject the reconcile if work on the environment is still in flight
+	if !startOperation(w, &environment, models.OperationReconcile, "Reconciling infrastructure with the environment's configuration") {
+		return
+	}
+	if !h.checkUnlocked(w, r, environment) {
+		return
+	}
+	environment.UpdatedAt = time.Now().UTC()
+
+	err := h.store.Update(r.Context(), &environment)
+	if errors.Is(err, store.ErrVersionConflict) {
+		http.Error(w, "Environment has been modified", http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save environment: %v", err)
+		http.Error(w, "Failed to save environment", http.StatusInternalServerError)
+		return
+	}
+	h.recordRequested(r, environment, models.OperationReconcile)
+
+	// Queue update in background
+	if !h.enqueueJob(w, r, environment, models.JobTypeUpdate) {
+		return
+	}
+
+	// Return updated environment
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", environmentETag(environment))
+	json.NewEncoder(w).Encode(environment)
+}
+
+// detectEnvironmentDrift checks an active environment's infrastructure for
+// changes made outside Terraform and records the result as its DRIFTED
+// condition. A failed check is recorded on the timeline but leaves the
+// environment's status alone.
+func (h *EnvironmentHandler) detectEnvironmentDrift(ctx context.Context, rec eventRecorder, env models.Environment) error {
+	// The environment may have moved on since the check was queued
+	if env.Status != models.StateActive || env.DeletedAt != nil {
+		log.Printf("Skipping drift check of environment %s in status %s", env.ID, env.Status)
+		return nil
+	}
+
+	run, err := h.terraformConfig(ctx, env)
+	if err != nil {
+		rec.failed(ctx, "Failed to check for drift", err)
+		return err
+	}
+
+	changes, err := run.provisioner.DetectDrift(ctx, env.ID, "aws", run.vars, run.timeouts, h.terraformHooks(ctx, rec))
+	if err != nil {
+		log.Printf("Failed to check environment %s for drift: %v", env.ID, err)
+		rec.failed(ctx, "Failed to check for drift", err)
+		return err
+	}
+
+	condition := models.EnvironmentCondition{
+		Type:          models.ConditionDrifted,
+		Status:        len(changes) > 0,
+		Message:       "Infrastructure matches the environment's configuration",
+		LastCheckedAt: time.Now().UTC(),
+	}
+	if condition.Status {
+		condition.Message = fmt.Sprintf("%d resource(s) changed outside the provisioner", len(changes))
+	}
+	for _, change := range changes {
+		condition.Resources = append(condition.Resources, models.ConditionResource{
+			Address: change.Address,
+			Type:    change.Type,
+			Action:  change.Action,
+		})
+	}
+
+	wasDrifted := false
+	err = h.mutateEnvironment(ctx, env.ID, func(environment *models.Environment) error {
+		previous, _ := environment.Condition(models.ConditionDrifted)
+		wasDrifted = previous.Status
+		environment.SetCondition(condition)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to update environment: %v", err)
+		rec.failed(ctx, "Failed to update environment", err)
+		return err
+	}
+
+	switch {
+	case condition.Status:
+		addresses := make([]string, len(changes))
+		for i, change := range changes {
+			addresses[i] = change.Address
+		}
+		rec.record(ctx, models.EventDriftDetected, condition.Message, map[string]string{
+			"resources": strings.Join(addresses, ", "),
+		})
+	case wasDrifted:
+		rec.record(ctx, models.EventDriftResolved, condition.Message, nil)
+	}
+	return nil
+}
+
+// resolveDrift marks a drifted environment as matching its configuration
+// again after an apply, reporting whether it was drifted
+func resolveDrift(env *models.Environment, now time.Time) bool {
+	condition, ok := env.Condition(models.ConditionDrifted)
+	if !ok || !condition.Status {
+		return false
+	}
+	env.SetCondition(models.EnvironmentCondition{
+		Type:          models.ConditionDrifted,
+		Status:        false,
+		Message:       "Infrastructure reconciled by an apply",
+		LastCheckedAt: now,
+	})
+	return true
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/store"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/terraform"
+)
+
+// terraformPhaseEvents maps the Terraform phases reported by the executor to
+// the events recorded when they start and finish
+var terraformPhaseEvents = map[string][2]string{
+	"init":    {models.EventTerraformInitStarted, models.EventTerraformInitFinished},
+	"plan":    {models.EventTerraformPlanStarted, models.EventTerraformPlanFinished},
+	"apply":   {models.EventTerraformApplyStarted, models.EventTerraformApplyFinished},
+	"destroy": {models.EventTerraformDestroyStarted, models.EventTerraformDestroyFinished},
+}
+
+// terraformSkippedEvents maps the Terraform phases the executor may skip to
+// the event recorded instead
+var terraformSkippedEvents = map[string]string{
+	"init": models.EventTerraformInitSkipped,
+}
+
+// eventRecorder appends events to the timeline of one environment on behalf
+// of one actor, and optionally one background job
+type eventRecorder struct {
+	store store.EventStore
+	envID string
+	jobID string
+	actor string
+}
+
+// newEventRecorder returns a recorder for the events of an environment
+func (h *EnvironmentHandler) newEventRecorder(envID, jobID, actor string) eventRecorder {
+	return eventRecorder{store: h.events, envID: envID, jobID: jobID, actor: actor}
+}
+
+// record appends an event. The timeline is informational, so a failure to
+// store the event is logged rather than failing the work it describes.
+func (rec eventRecorder) record(ctx context.Context, eventType, message string, details map[string]string) {
+	event := models.EnvironmentEvent{
+		ID:            uuid.New().String(),
+		EnvironmentID: rec.envID,
+		JobID:         rec.jobID,
+		Type:          eventType,
+		Actor:         rec.actor,
+		Message:       message,
+		Details:       details,
+		CreatedAt:     time.Now().UTC(),
+	}
+	if err := rec.store.Append(detach(ctx), &event); err != nil {
+		log.Printf("Failed to record %s event for environment %s: %v", eventType, rec.envID, err)
+	}
+}
+
+// detach returns ctx, or a fresh context once ctx is done, so that the record
+// of work that was cut short is still written
+func detach(ctx context.Context) context.Context {
+	if ctx.Err() != nil {
+		return context.Background()
+	}
+	return ctx
+}
+
+// failed records a FAILED event for a step that returned err, with the
+// category of a failed Terraform phase
+func (rec eventRecorder) failed(ctx context.Context, message string, err error) {
+	details := map[string]string{"error": err.Error()}
+	if category := terraform.FailureCategory(err); category != "" {
+		details["category"] = category
+	}
+	rec.record(ctx, models.EventFailed, message, details)
+}
+
+// statusChanged records a STATUS_CHANGED event for a status write
+func (rec eventRecorder) statusChanged(ctx context.Context, status models.EnvironmentState, message string) {
+	rec.record(ctx, models.EventStatusChanged, message, map[string]string{"status": string(status)})
+}
+
+// terraformHooks returns executor hooks that record the start and end of each
+// Terraform phase, with its duration and error, each phase that was skipped,
+// and each retry of a phase that failed transiently
+func (rec eventRecorder) terraformHooks(ctx context.Context) terraform.Hooks {
+	return terraform.Hooks{
+		PhaseStarted: func(phase string) {
+			if events, ok := terraformPhaseEvents[phase]; ok {
+				rec.record(ctx, events[0], "terraform "+phase+" started", nil)
+			}
+		},
+		PhaseFinished: func(phase string, duration time.Duration, err error) {
+			events, ok := terraformPhaseEvents[phase]
+			if !ok {
+				return
+			}
+			details := map[string]string{"duration": duration.Round(time.Millisecond).String()}
+			message := "terraform " + phase + " finished"
+			if err != nil {
+				details["error"] = err.Error()
+				message = "terraform " + phase + " failed"
+			}
+			rec.record(ctx, events[1], message, details)
+		},
+		PhaseSkipped: func(phase, reason string) {
+			if event, ok := terraformSkippedEvents[phase]; ok {
+				rec.record(ctx, event, "terraform "+phase+" skipped: "+reason, nil)
+			}
+		},
+		PhaseRetrying: func(phase string, attempt int, delay time.Duration, err *terraform.FailureError) {
+			rec.record(ctx, models.EventTerraformRetrying, fmt.Sprintf("terraform %s failed with a %s failure; retrying in %s", phase, err.Category, delay), map[string]string{
+				"phase":    phase,
+				"category": err.Category,
+				"attempt":  strconv.Itoa(attempt),
+				"delay":    delay.String(),
+				"error":    err.Err.Error(),
+			})
+		},
+	}
+}
+
+// ListEnvironmentEvents returns the timeline of an environment, oldest first.
+// It can be narrowed with the type (repeatable), jobId and actor query
+// parameters and an RFC 3339 since/until time range.
+func (h *EnvironmentHandler) ListEnvironmentEvents(w http.ResponseWriter, r *http.Request) {
+	environment, ok := h.loadEnvironment(w, r)
+	if !ok {
+		return
+	}
+
+	queryParams := r.URL.Query()
+	filter := store.EventFilter{
+		Types: queryParams["type"],
+		JobID: queryParams.Get("jobId"),
+		Actor: queryParams.Get("actor"),
+	}
+	bounds := []struct {
+		name  string
+		value *time.Time
+	}{
+		{"since", &filter.Since},
+		{"until", &filter.Until},
+	}
+	for _, bound := range bounds {
+		param := queryParams.Get(bound.name)
+		if param == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			http.Error(w, "Invalid "+bound.name+" parameter: expected an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		*bound.value = parsed
+	}
+
+	events, err := h.events.List(r.Context(), environment.ID, filter)
+	if err != nil {
+		log.Printf("Failed to list events: %v", err)
+		http.Error(w, "Failed to retrieve events", http.StatusInternalServerError)
+		return
+	}
+
+	// Return events
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
@@ -3,282 +3,293 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
-	"github.com/yourusername/k8s-env-provisioner/api/models"
-	"github.com/yourusername/k8s-env-provisioner/api/terraform"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/jobs"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/middleware"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/store"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/terraform"
 )
 
+// maxMutateAttempts bounds the read-modify-write retries in mutateEnvironment
+const maxMutateAttempts = 5
+
 // EnvironmentHandler handles environment-related requests
 type EnvironmentHandler struct {
-	dynamoClient      *dynamodb.Client
-	terraformExecutor *terraform.Executor
-	validate          *validator.Validate
-	tableName         string
+	store        store.EnvironmentStore
+	templates    store.TemplateStore
+	queue        *jobs.Queue
+	locker       *jobs.Locker
+	events       store.EventStore
+	logs         store.LogStore
+	plans        store.PlanStore
+	logBroker    *logBroker
+	provisioners terraform.Provisioners
+	validate     *validator.Validate
 }
 
 // NewEnvironmentHandler creates a new environment handler
-func NewEnvironmentHandler(dynamoClient *dynamodb.Client, terraformExecutor *terraform.Executor, validate *validator.Validate) *EnvironmentHandler {
+func NewEnvironmentHandler(environmentStore store.EnvironmentStore, templateStore store.TemplateStore, queue *jobs.Queue, locker *jobs.Locker, eventStore store.EventStore, logStore store.LogStore, planStore store.PlanStore, provisioners terraform.Provisioners, validate *validator.Validate) *EnvironmentHandler {
 	return &EnvironmentHandler{
-		dynamoClient:      dynamoClient,
-		terraformExecutor: terraformExecutor,
-		validate:          validate,
-		tableName:         "environments",
+		store:        environmentStore,
+		templates:    templateStore,
+		queue:        queue,
+		locker:       locker,
+		events:       eventStore,
+		logs:         logStore,
+		plans:        planStore,
+		logBroker:    newLogBroker(),
+		provisioners: provisioners,
+		validate:     validate,
 	}
 }
 
-// ListEnvironments returns all environments
+// ListEnvironments returns a page of the environments visible to the caller
 func (h *EnvironmentHandler) ListEnvironments(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
-	
+	filter, ok := listFilter(w, r)
+	if !ok {
+		return
+	}
+
 	// Extract query parameters
 	queryParams := r.URL.Query()
-	userID := queryParams.Get("userId")
-	status := queryParams.Get("status")
-	
-	// Build query
-	scanInput := &dynamodb.ScanInput{
-		TableName: aws.String(h.tableName),
-	}
-	
-	// Apply filters if provided
-	var filterExpressions []string
-	expressionAttributeValues := make(map[string]types.AttributeValue)
-	expressionAttributeNames := make(map[string]string)
-	
-	if userID != "" {
-		filterExpressions = append(filterExpressions, "#userId = :userId")
-		expressionAttributeNames["#userId"] = "UserID"
-		expressionAttributeValues[":userId"], _ = attributevalue.Marshal(userID)
-	}
-	
-	if status != "" {
-		filterExpressions = append(filterExpressions, "#status = :status")
-		expressionAttributeNames["#status"] = "Status"
-		expressionAttributeValues[":status"], _ = attributevalue.Marshal(status)
-	}
-	
-	// Only include non-deleted environments
-	filterExpressions = append(filterExpressions, "attribute_not_exists(DeletedAt)")
-	
-	// Combine filter expressions
-	if len(filterExpressions) > 0 {
-		filterExpression := filterExpressions[0]
-		for i := 1; i < len(filterExpressions); i++ {
-			filterExpression += " AND " + filterExpressions[i]
+	page := store.PageRequest{
+		Limit:  store.DefaultPageSize,
+		Cursor: queryParams.Get("cursor"),
+		SortBy: store.SortByCreatedAt,
+	}
+	if limit := queryParams.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > store.MaxPageSize {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", store.MaxPageSize), http.StatusBadRequest)
+			return
 		}
-		scanInput.FilterExpression = aws.String(filterExpression)
-		scanInput.ExpressionAttributeNames = expressionAttributeNames
-		scanInput.ExpressionAttributeValues = expressionAttributeValues
+		page.Limit = n
 	}
-	
-	// Execute query
-	result, err := h.dynamoClient.Scan(ctx, scanInput)
-	if err != nil {
-		log.Printf("Failed to scan environments: %v", err)
-		http.Error(w, "Failed to retrieve environments", http.StatusInternalServerError)
+	if sortBy := queryParams.Get("sort"); sortBy != "" {
+		if !store.ValidSortField(sortBy) {
+			http.Error(w, "sort must be one of createdAt, name, status", http.StatusBadRequest)
+			return
+		}
+		page.SortBy = sortBy
+	}
+	switch queryParams.Get("order") {
+	case "", "asc":
+	case "desc":
+		page.Descending = true
+	default:
+		http.Error(w, "order must be asc or desc", http.StatusBadRequest)
+		return
+	}
+
+	environments, err := h.store.List(r.Context(), filter, page)
+	if errors.Is(err, store.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
 		return
 	}
-	
-	// Unmarshal results
-	var environments []models.Environment
-	err = attributevalue.UnmarshalListOfMaps(result.Items, &environments)
 	if err != nil {
-		log.Printf("Failed to unmarshal environments: %v", err)
-		http.Error(w, "Failed to process environments", http.StatusInternalServerError)
+		log.Printf("Failed to list environments: %v", err)
+		http.Error(w, "Failed to retrieve environments", http.StatusInternalServerError)
 		return
 	}
-	
+
 	// Return environments
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(environments)
 }
 
-// CreateEnvironment creates a new environment
+// CreateEnvironment creates a new environment owned by the caller
 func (h *EnvironmentHandler) CreateEnvironment(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
-	
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
 	// Parse request
 	var envRequest models.EnvironmentRequest
 	if err := json.NewDecoder(r.Body).Decode(&envRequest); err != nil {
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
 		return
 	}
-	
+
 	// Validate request
 	if err := h.validate.Struct(envRequest); err != nil {
 		http.Error(w, "Validation error: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	
+
+	// The owner is the caller; only platform admins may create on behalf of
+	// another user or for a team they do not belong to
+	ownerID := principal.Subject
+	if envRequest.UserID != "" && envRequest.UserID != principal.Subject {
+		if !principal.Admin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		ownerID = envRequest.UserID
+	}
+	if envRequest.TeamID != "" && !principal.Admin && !isTeamMember(principal, envRequest.TeamID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Resolve the template revision, the latest unless the request pins one,
+	// and hold the request to its guardrails
+	template, err := h.templates.Get(r.Context(), envRequest.TemplateID)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && template.DeletedAt != nil) {
+		http.Error(w, "Validation error: unknown template "+envRequest.TemplateID, http.StatusBadRequest)
+		return
+	}
+	if err == nil && envRequest.TemplateRevision != 0 && envRequest.TemplateRevision != template.Revision {
+		template, err = h.templates.GetRevision(r.Context(), envRequest.TemplateID, envRequest.TemplateRevision)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, fmt.Sprintf("Validation error: template %s has no revision %d", envRequest.TemplateID, envRequest.TemplateRevision), http.StatusBadRequest)
+			return
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to get template: %v", err)
+		http.Error(w, "Failed to retrieve template", http.StatusInternalServerError)
+		return
+	}
+	if err := checkTemplateLimits(template, envRequest.ResourceLimits, envRequest.Addons); err != nil {
+		http.Error(w, "Validation error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Create environment record
 	envID := uuid.New().String()
 	clusterName := "env-" + envID[:8]
-	
+
 	environment := models.Environment{
-		ID:             envID,
-		Name:           envRequest.Name,
-		Description:    envRequest.Description,
-		TemplateID:     envRequest.TemplateID,
-		UserID:         envRequest.UserID,
-		ResourceLimits: envRequest.ResourceLimits,
-		NetworkPolicy:  envRequest.NetworkPolicy,
-		ServiceMesh:    envRequest.ServiceMesh,
-		Monitoring:     envRequest.Monitoring,
-		GitOps:         envRequest.GitOps,
-		Addons:         envRequest.Addons,
-		Tags:           envRequest.Tags,
-		Status:         "CREATING",
-		StatusMessage:  "Environment creation initiated",
-		ClusterName:    clusterName,
-		ConsoleURL:     "",  // Will be populated after provisioning
-		CreatedAt:      time.Now().UTC(),
-		UpdatedAt:      time.Now().UTC(),
-	}
-	
-	// Convert to DynamoDB item
-	item, err := attributevalue.MarshalMap(environment)
-	if err != nil {
-		log.Printf("Failed to marshal environment: %v", err)
-		http.Error(w, "Failed to create environment", http.StatusInternalServerError)
-		return
+		ID:               envID,
+		Name:             envRequest.Name,
+		Description:      envRequest.Description,
+		TemplateID:       envRequest.TemplateID,
+		TemplateRevision: template.Revision,
+		UserID:           ownerID,
+		TeamID:           envRequest.TeamID,
+		ResourceLimits:   envRequest.ResourceLimits,
+		NetworkPolicy:    envRequest.NetworkPolicy,
+		ServiceMesh:      envRequest.ServiceMesh,
+		Monitoring:       envRequest.Monitoring,
+		GitOps:           envRequest.GitOps,
+		Addons:           envRequest.Addons,
+		Tags:             envRequest.Tags,
+		Status:           models.StateCreating,
+		StatusMessage:    "Environment creation initiated",
+		ClusterName:      clusterName,
+		ConsoleURL:       "", // Will be populated after provisioning
+		CreatedAt:        time.Now().UTC(),
+		UpdatedAt:        time.Now().UTC(),
 	}
-	
-	// Save to DynamoDB
-	_, err = h.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(h.tableName),
-		Item:      item,
-	})
-	if err != nil {
+
+	if err := h.store.Create(r.Context(), &environment); err != nil {
 		log.Printf("Failed to save environment: %v", err)
 		http.Error(w, "Failed to save environment", http.StatusInternalServerError)
 		return
 	}
-	
-	// Trigger provisioning in background
-	go h.provisionEnvironment(environment)
-	
+	h.recordRequested(r, environment, models.OperationCreate)
+
+	// Queue provisioning in background
+	if !h.enqueueJob(w, r, environment, models.JobTypeProvision) {
+		return
+	}
+
 	// Return the created environment
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", environmentETag(environment))
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(environment)
 }
 
 // GetEnvironment returns a specific environment
 func (h *EnvironmentHandler) GetEnvironment(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
-	
-	// Get environment ID from path
-	vars := mux.Vars(r)
-	envID := vars["id"]
-	
-	// Query DynamoDB
-	result, err := h.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String(h.tableName),
-		Key: map[string]types.AttributeValue{
-			"ID": &types.AttributeValueMemberS{Value: envID},
-		},
-	})
-	if err != nil {
-		log.Printf("Failed to get environment: %v", err)
-		http.Error(w, "Failed to retrieve environment", http.StatusInternalServerError)
-		return
-	}
-	
-	// Check if environment exists
-	if result.Item == nil {
-		http.Error(w, "Environment not found", http.StatusNotFound)
-		return
-	}
-	
-	// Unmarshal environment
-	var environment models.Environment
-	err = attributevalue.UnmarshalMap(result.Item, &environment)
-	if err != nil {
-		log.Printf("Failed to unmarshal environment: %v", err)
-		http.Error(w, "Failed to process environment", http.StatusInternalServerError)
-		return
-	}
-	
-	// Check if environment is deleted
-	if environment.DeletedAt != nil {
-		http.Error(w, "Environment not found", http.StatusNotFound)
+	environment, ok := h.loadEnvironment(w, r)
+	if !ok {
 		return
 	}
-	
+
 	// Return environment
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", environmentETag(environment))
 	json.NewEncoder(w).Encode(environment)
 }
 
 // UpdateEnvironment updates an environment
 func (h *EnvironmentHandler) UpdateEnvironment(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
-	
-	// Get environment ID from path
-	vars := mux.Vars(r)
-	envID := vars["id"]
-	
 	// Parse request
 	var envPatch models.EnvironmentPatch
 	if err := json.NewDecoder(r.Body).Decode(&envPatch); err != nil {
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
 		return
 	}
-	
+
 	// Validate request
 	if err := h.validate.Struct(envPatch); err != nil {
 		http.Error(w, "Validation error: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	
-	// Get existing environment
-	result, err := h.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String(h.tableName),
-		Key: map[string]types.AttributeValue{
-			"ID": &types.AttributeValueMemberS{Value: envID},
-		},
-	})
-	if err != nil {
-		log.Printf("Failed to get environment: %v", err)
-		http.Error(w, "Failed to retrieve environment", http.StatusInternalServerError)
+
+	environment, ok := h.loadEnvironment(w, r)
+	if !ok {
 		return
 	}
-	
-	// Check if environment exists
-	if result.Item == nil {
-		http.Error(w, "Environment not found", http.StatusNotFound)
+
+	// An environment can only be handed to a team the caller belongs to
+	if envPatch.TeamID != nil && *envPatch.TeamID != "" {
+		principal, _ := requirePrincipal(w, r)
+		if !principal.Admin && !isTeamMember(principal, *envPatch.TeamID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	// Reject the patch if the caller's copy is stale
+	if !ifMatch(r, environmentETag(environment)) {
+		http.Error(w, "Environment has been modified", http.StatusPreconditionFailed)
 		return
 	}
-	
-	// Unmarshal environment
-	var environment models.Environment
-	err = attributevalue.UnmarshalMap(result.Item, &environment)
+
+	// Changed limits and addons must stay within the template's guardrails
+	template, err := environmentTemplate(r.Context(), h.templates, environment)
 	if err != nil {
-		log.Printf("Failed to unmarshal environment: %v", err)
-		http.Error(w, "Failed to process environment", http.StatusInternalServerError)
+		log.Printf("Failed to get template: %v", err)
+		http.Error(w, "Failed to retrieve template", http.StatusInternalServerError)
 		return
 	}
-	
-	// Check if environment is deleted
-	if environment.DeletedAt != nil {
-		http.Error(w, "Environment not found", http.StatusNotFound)
-		return
+	if envPatch.ResourceLimits != nil || envPatch.Addons != nil {
+		limits, addons := environment.ResourceLimits, environment.Addons
+		if envPatch.ResourceLimits != nil {
+			limits = *envPatch.ResourceLimits
+		}
+		if envPatch.Addons != nil {
+			addons = envPatch.Addons
+		}
+		if err := checkTemplateLimits(template, limits, addons); err != nil {
+			http.Error(w, "Validation error: "+err.Error(), http.StatusBadRequest)
+			return
+		}
 	}
-	
+
 	// Apply updates
+	before := environment
 	if envPatch.Description != nil {
 		environment.Description = *envPatch.Description
 	}
+	if envPatch.TeamID != nil {
+		environment.TeamID = *envPatch.TeamID
+	}
 	if envPatch.ResourceLimits != nil {
 		environment.ResourceLimits = *envPatch.ResourceLimits
 	}
@@ -300,305 +311,704 @@ func (h *EnvironmentHandler) UpdateEnvironment(w http.ResponseWriter, r *http.Re
 	if envPatch.Tags != nil {
 		environment.Tags = envPatch.Tags
 	}
-	
+
+	// Only the steps the changes need are run. An environment in ERROR may
+	// be partway through an earlier change, so it runs them all.
+	changes := diffEnvironment(before, environment, template)
+
+	// Nothing reconciles the objects inside the cluster yet, so changes to
+	// them are refused rather than stored as if they were applied
+	if fields := clusterChanges(changes); len(fields) > 0 {
+		http.Error(w, fmt.Sprintf("Cannot update %s: changes inside the cluster are not supported", strings.Join(fields, ", ")), http.StatusUnprocessableEntity)
+		return
+	}
+	steps := models.ChangeSteps(changes)
+	if len(steps) > 0 && before.Status == models.StateError {
+		steps = models.UpdateSteps
+	}
+
+	// Reject the patch if work on the environment is still in flight. A
+	// patch of metadata alone needs no work, so the status stays as it is.
+	if len(steps) > 0 {
+		if !startOperation(w, &environment, models.OperationUpdate, "Environment update initiated") {
+			return
+		}
+	} else if !environment.Status.Allows(models.OperationUpdate) {
+		http.Error(w, fmt.Sprintf("Cannot %s environment while it is %s", models.OperationUpdate, environment.Status), http.StatusConflict)
+		return
+	}
+	if !h.checkUnlocked(w, r, environment) {
+		return
+	}
+
 	// Update timestamp
 	environment.UpdatedAt = time.Now().UTC()
-	environment.Status = "UPDATING"
-	environment.StatusMessage = "Environment update initiated"
-	
+
 	// Save updated environment
-	updatedItem, err := attributevalue.MarshalMap(environment)
-	if err != nil {
-		log.Printf("Failed to marshal environment: %v", err)
-		http.Error(w, "Failed to update environment", http.StatusInternalServerError)
+	err = h.store.Update(r.Context(), &environment)
+	if errors.Is(err, store.ErrVersionConflict) {
+		http.Error(w, "Environment has been modified", http.StatusPreconditionFailed)
 		return
 	}
-	
-	_, err = h.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(h.tableName),
-		Item:      updatedItem,
-	})
 	if err != nil {
 		log.Printf("Failed to save environment: %v", err)
 		http.Error(w, "Failed to save environment", http.StatusInternalServerError)
 		return
 	}
-	
-	// Trigger update in background
-	go h.updateEnvironment(environment)
-	
+	h.recordRequested(r, environment, models.OperationUpdate)
+
+	if len(steps) > 0 {
+		// Queue update in background
+		if !h.submitJob(w, r, environment, models.Job{Type: models.JobTypeUpdate, Changes: changes, Steps: steps}) {
+			return
+		}
+	} else if len(changes) > 0 {
+		principal, _ := middleware.PrincipalFromContext(r.Context())
+		message, details := describeChanges(changes, nil)
+		h.newEventRecorder(environment.ID, "", principal.Subject).record(r.Context(), models.EventChangesApplied, message, details)
+	}
+
 	// Return updated environment
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", environmentETag(environment))
 	json.NewEncoder(w).Encode(environment)
 }
 
 // DeleteEnvironment deletes an environment
 func (h *EnvironmentHandler) DeleteEnvironment(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
-	
-	// Get environment ID from path
-	vars := mux.Vars(r)
-	envID := vars["id"]
-	
-	// Get existing environment
-	result, err := h.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String(h.tableName),
-		Key: map[string]types.AttributeValue{
-			"ID": &types.AttributeValueMemberS{Value: envID},
-		},
-	})
-	if err != nil {
-		log.Printf("Failed to get environment: %v", err)
-		http.Error(w, "Failed to retrieve environment", http.StatusInternalServerError)
+	environment, ok := h.loadEnvironment(w, r)
+	if !ok {
 		return
 	}
-	
-	// Check if environment exists
-	if result.Item == nil {
-		http.Error(w, "Environment not found", http.StatusNotFound)
+
+	// Reject the delete if the caller's copy is stale
+	if !ifMatch(r, environmentETag(environment)) {
+		http.Error(w, "Environment has been modified", http.StatusPreconditionFailed)
 		return
 	}
-	
-	// Unmarshal environment
-	var environment models.Environment
-	err = attributevalue.UnmarshalMap(result.Item, &environment)
-	if err != nil {
-		log.Printf("Failed to unmarshal environment: %v", err)
-		http.Error(w, "Failed to process environment", http.StatusInternalServerError)
+
+	// Mark as deleting, unless work on the environment is still in flight
+	if !startOperation(w, &environment, models.OperationDelete, "Environment deletion initiated") {
 		return
 	}
-	
-	// Check if environment is already deleted
-	if environment.DeletedAt != nil {
-		http.Error(w, "Environment not found", http.StatusNotFound)
+	if !h.checkUnlocked(w, r, environment) {
 		return
 	}
-	
-	// Mark as deleting
-	now := time.Now().UTC()
-	environment.Status = "DELETING"
-	environment.StatusMessage = "Environment deletion initiated"
-	environment.UpdatedAt = now
-	environment.DeletedAt = &now
-	
-	// Save updated environment
-	updatedItem, err := attributevalue.MarshalMap(environment)
-	if err != nil {
-		log.Printf("Failed to marshal environment: %v", err)
-		http.Error(w, "Failed to delete environment", http.StatusInternalServerError)
+
+	// The environment stays visible while it is DELETING, and is only
+	// soft-deleted once its resources have been destroyed
+	environment.UpdatedAt = time.Now().UTC()
+	err := h.store.Update(r.Context(), &environment)
+	if errors.Is(err, store.ErrVersionConflict) {
+		http.Error(w, "Environment has been modified", http.StatusPreconditionFailed)
 		return
 	}
-	
-	_, err = h.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(h.tableName),
-		Item:      updatedItem,
-	})
 	if err != nil {
 		log.Printf("Failed to save environment: %v", err)
 		http.Error(w, "Failed to delete environment", http.StatusInternalServerError)
 		return
 	}
-	
-	// Trigger deletion in background
-	go h.deleteEnvironment(environment)
-	
+	h.recordRequested(r, environment, models.OperationDelete)
+
+	// Queue deletion in background
+	if !h.enqueueJob(w, r, environment, models.JobTypeDelete) {
+		return
+	}
+
 	// Return success
 	w.WriteHeader(http.StatusNoContent)
 }
 
 // GetEnvironmentStatus gets the detailed status of an environment
 func (h *EnvironmentHandler) GetEnvironmentStatus(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
-	
-	// Get environment ID from path
-	vars := mux.Vars(r)
-	envID := vars["id"]
-	
-	// Get environment from DynamoDB
-	result, err := h.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String(h.tableName),
-		Key: map[string]types.AttributeValue{
-			"ID": &types.AttributeValueMemberS{Value: envID},
-		},
+	environment, ok := h.loadEnvironment(w, r)
+	if !ok {
+		return
+	}
+
+	// Get detailed status
+	status, err := h.getEnvironmentDetailedStatus(environment)
+	if err != nil {
+		log.Printf("Failed to get detailed status: %v", err)
+		http.Error(w, "Failed to retrieve environment status", http.StatusInternalServerError)
+		return
+	}
+
+	// Report where the environment's waiting work stands in the job queue
+	status.QueuePosition, err = h.queuePosition(r.Context(), environment.ID)
+	if err != nil {
+		log.Printf("Failed to get queue position: %v", err)
+		http.Error(w, "Failed to retrieve environment status", http.StatusInternalServerError)
+		return
+	}
+
+	// Return status
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// ListEnvironmentJobs returns the background jobs of an environment, oldest
+// first
+func (h *EnvironmentHandler) ListEnvironmentJobs(w http.ResponseWriter, r *http.Request) {
+	environment, ok := h.loadEnvironment(w, r)
+	if !ok {
+		return
+	}
+
+	jobList, err := h.queue.List(r.Context(), store.JobFilter{EnvironmentID: environment.ID})
+	if err != nil {
+		log.Printf("Failed to list jobs: %v", err)
+		http.Error(w, "Failed to retrieve jobs", http.StatusInternalServerError)
+		return
+	}
+
+	// Return jobs
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobList)
+}
+
+// CancelEnvironmentOperation cancels the background work waiting or running on
+// an environment. Queued work is dropped; running Terraform is interrupted and
+// given time to stop cleanly. Either way the environment ends up in ERROR.
+func (h *EnvironmentHandler) CancelEnvironmentOperation(w http.ResponseWriter, r *http.Request) {
+	environment, ok := h.loadEnvironment(w, r)
+	if !ok {
+		return
+	}
+
+	active, err := h.queue.List(r.Context(), store.JobFilter{
+		EnvironmentID: environment.ID,
+		Statuses:      []string{models.JobStatusPending, models.JobStatusRunning},
 	})
 	if err != nil {
-		log.Printf("Failed to get environment: %v", err)
-		http.Error(w, "Failed to retrieve environment", http.StatusInternalServerError)
+		log.Printf("Failed to list jobs: %v", err)
+		http.Error(w, "Failed to retrieve jobs", http.StatusInternalServerError)
 		return
 	}
-	
-	// Check if environment exists
-	if result.Item == nil {
-		http.Error(w, "Environment not found", http.StatusNotFound)
+
+	principal, _ := middleware.PrincipalFromContext(r.Context())
+	cancelled := []models.Job{}
+	for _, job := range active {
+		job, err := h.queue.Cancel(r.Context(), job.ID, principal.Subject)
+		if errors.Is(err, jobs.ErrJobFinished) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to cancel job %s: %v", job.ID, err)
+			http.Error(w, "Failed to cancel job", http.StatusInternalServerError)
+			return
+		}
+
+		rec := h.newEventRecorder(environment.ID, job.ID, principal.Subject)
+		rec.record(r.Context(), models.EventCancelRequested, fmt.Sprintf("Requested cancellation of %s job", job.Type), map[string]string{
+			"jobType": job.Type,
+		})
+
+		// Queued work never reaches a worker, so its environment is
+		// failed here
+		if job.Status == models.JobStatusCancelled {
+			h.cancelEnvironment(rec, job)
+		}
+		cancelled = append(cancelled, job)
+	}
+
+	if len(cancelled) == 0 {
+		http.Error(w, "No operation in progress", http.StatusConflict)
+		return
+	}
+
+	// Return the cancelled jobs
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(cancelled)
+}
+
+// GetLifecycle returns the environment lifecycle: its states, the transition
+// table, and the states each operation may be started in
+func (h *EnvironmentHandler) GetLifecycle(w http.ResponseWriter, r *http.Request) {
+	// Return lifecycle
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.EnvironmentLifecycle())
+}
+
+// ListOutdatedEnvironments returns the environments visible to the caller that
+// were built from an older revision of their template than the latest one
+func (h *EnvironmentHandler) ListOutdatedEnvironments(w http.ResponseWriter, r *http.Request) {
+	filter, ok := listFilter(w, r)
+	if !ok {
+		return
+	}
+	templateID := r.URL.Query().Get("templateId")
+
+	outdated := []models.OutdatedEnvironment{}
+	latest := make(map[string]int64)
+	page := store.PageRequest{Limit: store.MaxPageSize}
+	for {
+		environments, err := h.store.List(r.Context(), filter, page)
+		if err != nil {
+			log.Printf("Failed to list environments: %v", err)
+			http.Error(w, "Failed to retrieve environments", http.StatusInternalServerError)
+			return
+		}
+
+		for _, environment := range environments.Items {
+			if templateID != "" && environment.TemplateID != templateID {
+				continue
+			}
+
+			revision, seen := latest[environment.TemplateID]
+			if !seen {
+				template, err := h.templates.Get(r.Context(), environment.TemplateID)
+				if err != nil && !errors.Is(err, store.ErrNotFound) {
+					log.Printf("Failed to get template: %v", err)
+					http.Error(w, "Failed to retrieve template", http.StatusInternalServerError)
+					return
+				}
+				revision = template.Revision
+				latest[environment.TemplateID] = revision
+			}
+
+			if environment.TemplateRevision < revision {
+				outdated = append(outdated, models.OutdatedEnvironment{
+					EnvironmentID:    environment.ID,
+					Name:             environment.Name,
+					TemplateID:       environment.TemplateID,
+					TemplateRevision: environment.TemplateRevision,
+					LatestRevision:   revision,
+				})
+			}
+		}
+
+		if environments.NextCursor == "" {
+			break
+		}
+		page.Cursor = environments.NextCursor
+	}
+
+	// Return outdated environments
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(outdated)
+}
+
+// UpgradeEnvironmentTemplate moves an environment to the latest revision of
+// its template and re-applies it
+func (h *EnvironmentHandler) UpgradeEnvironmentTemplate(w http.ResponseWriter, r *http.Request) {
+	environment, ok := h.loadEnvironment(w, r)
+	if !ok {
+		return
+	}
+
+	// Reject the upgrade if the caller's copy is stale
+	if !ifMatch(r, environmentETag(environment)) {
+		http.Error(w, "Environment has been modified", http.StatusPreconditionFailed)
+		return
+	}
+
+	// Reject the upgrade if work on the environment is still in flight
+	if !startOperation(w, &environment, models.OperationUpgrade, "Template upgrade initiated") {
+		return
+	}
+	if !h.checkUnlocked(w, r, environment) {
+		return
+	}
+
+	template, err := h.templates.Get(r.Context(), environment.TemplateID)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && template.DeletedAt != nil) {
+		http.Error(w, "Template has been deleted", http.StatusConflict)
 		return
 	}
-	
-	// Unmarshal environment
-	var environment models.Environment
-	err = attributevalue.UnmarshalMap(result.Item, &environment)
 	if err != nil {
-		log.Printf("Failed to unmarshal environment: %v", err)
-		http.Error(w, "Failed to process environment", http.StatusInternalServerError)
+		log.Printf("Failed to get template: %v", err)
+		http.Error(w, "Failed to retrieve template", http.StatusInternalServerError)
 		return
 	}
-	
-	// Check if environment is deleted
-	if environment.DeletedAt != nil {
-		http.Error(w, "Environment not found", http.StatusNotFound)
+	if environment.TemplateRevision >= template.Revision {
+		http.Error(w, "Environment is already on the latest template revision", http.StatusConflict)
+		return
+	}
+
+	// The environment's current settings must fit the new revision
+	if err := checkTemplateLimits(template, environment.ResourceLimits, environment.Addons); err != nil {
+		http.Error(w, "Cannot upgrade: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	environment.TemplateRevision = template.Revision
+	environment.StatusMessage = fmt.Sprintf("Upgrading to template revision %d", template.Revision)
+	environment.UpdatedAt = time.Now().UTC()
+
+	err = h.store.Update(r.Context(), &environment)
+	if errors.Is(err, store.ErrVersionConflict) {
+		http.Error(w, "Environment has been modified", http.StatusPreconditionFailed)
 		return
 	}
-	
-	// Get detailed status
-	status, err := h.getEnvironmentDetailedStatus(environment)
 	if err != nil {
-		log.Printf("Failed to get detailed status: %v", err)
-		http.Error(w, "Failed to retrieve environment status", http.StatusInternalServerError)
+		log.Printf("Failed to save environment: %v", err)
+		http.Error(w, "Failed to save environment", http.StatusInternalServerError)
 		return
 	}
-	
-	// Return status
+	h.recordRequested(r, environment, models.OperationUpgrade)
+
+	// Queue update in background
+	if !h.enqueueJob(w, r, environment, models.JobTypeUpdate) {
+		return
+	}
+
+	// Return updated environment
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(status)
+	w.Header().Set("ETag", environmentETag(environment))
+	json.NewEncoder(w).Encode(environment)
+}
+
+// startOperation moves the local copy of an environment into the state an
+// operation starts in. It writes a 409 response and returns false if the
+// environment's current state does not allow the operation.
+func startOperation(w http.ResponseWriter, env *models.Environment, operation, message string) bool {
+	if env.Status.Allows(operation) && env.Transition(models.OperationState(operation), message) == nil {
+		return true
+	}
+	http.Error(w, fmt.Sprintf("Cannot %s environment while it is %s", operation, env.Status), http.StatusConflict)
+	return false
+}
+
+// listFilter builds the store filter for a listing from the userId, teamId
+// and status query parameters, restricted to the environments the caller may
+// see. It writes a 401 or 403 response and returns false if the caller asks
+// for someone else's environments.
+func listFilter(w http.ResponseWriter, r *http.Request) (store.EnvironmentFilter, bool) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return store.EnvironmentFilter{}, false
+	}
+
+	queryParams := r.URL.Query()
+	userID := queryParams.Get("userId")
+	teamID := queryParams.Get("teamId")
+	filter := store.EnvironmentFilter{
+		UserID: userID,
+		Status: models.EnvironmentState(queryParams.Get("status")),
+	}
+	if filter.Status != "" && !filter.Status.Valid() {
+		http.Error(w, "status must be a lifecycle state", http.StatusBadRequest)
+		return filter, false
+	}
+	if teamID != "" {
+		filter.TeamIDs = []string{teamID}
+	}
+
+	// Non-admins only see their own and their teams' environments
+	if !principal.Admin {
+		if userID != "" && userID != principal.Subject {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return filter, false
+		}
+		if teamID != "" && !isTeamMember(principal, teamID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return filter, false
+		}
+		if userID == "" && teamID == "" {
+			filter.UserID = principal.Subject
+			filter.TeamIDs = principalTeams(principal)
+		}
+	}
+
+	return filter, true
+}
+
+// loadEnvironment fetches the environment named by the {id} path variable,
+// writing a 401, 403, 404 or 500 response and returning false if the caller
+// cannot have it
+func (h *EnvironmentHandler) loadEnvironment(w http.ResponseWriter, r *http.Request) (models.Environment, bool) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return models.Environment{}, false
+	}
+
+	envID := mux.Vars(r)["id"]
+
+	environment, err := h.store.Get(r.Context(), envID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Environment not found", http.StatusNotFound)
+		return environment, false
+	}
+	if err != nil {
+		log.Printf("Failed to get environment: %v", err)
+		http.Error(w, "Failed to retrieve environment", http.StatusInternalServerError)
+		return environment, false
+	}
+
+	// Deleted environments are not visible through the API
+	if environment.DeletedAt != nil {
+		http.Error(w, "Environment not found", http.StatusNotFound)
+		return environment, false
+	}
+
+	if !canAccessEnvironment(principal, environment) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return environment, false
+	}
+
+	return environment, true
+}
+
+// RunJob does the background work of a queued job against the latest stored
+// copy of its environment
+func (h *EnvironmentHandler) RunJob(ctx context.Context, job models.Job) error {
+	rec := h.newEventRecorder(job.EnvironmentID, job.ID, models.ActorSystem)
+
+	// A job cancelled before its worker got to it does no work
+	if errors.Is(context.Cause(ctx), jobs.ErrCancelled) {
+		h.cancelEnvironment(rec, job)
+		return jobs.ErrCancelled
+	}
+
+	environment, err := h.store.Get(ctx, job.EnvironmentID)
+	if err != nil {
+		return fmt.Errorf("failed to get environment %s: %w", job.EnvironmentID, err)
+	}
+
+	rec.record(ctx, models.EventJobStarted, fmt.Sprintf("Started %s job", job.Type), map[string]string{
+		"jobType": job.Type,
+		"attempt": strconv.Itoa(job.Attempts),
+	})
+
+	switch job.Type {
+	case models.JobTypeProvision:
+		err = h.provisionEnvironment(ctx, rec, environment)
+	case models.JobTypeUpdate:
+		err = h.updateEnvironment(ctx, rec, environment, job)
+	case models.JobTypeDelete:
+		err = h.deleteEnvironment(ctx, rec, environment)
+	case models.JobTypeApplyPlan:
+		err = h.applyEnvironmentPlan(ctx, rec, environment, job.PlanID)
+	case models.JobTypeDetectDrift:
+		err = h.detectEnvironmentDrift(ctx, rec, environment)
+	default:
+		err = fmt.Errorf("unknown job type %q", job.Type)
+	}
+
+	if err != nil && errors.Is(context.Cause(ctx), jobs.ErrCancelled) {
+		h.cancelEnvironment(rec, job)
+	}
+	return err
+}
+
+// AbandonJob marks the environment of a job that could not be finished as
+// failed, so that it does not stay in a transitional status forever
+func (h *EnvironmentHandler) AbandonJob(ctx context.Context, job models.Job) {
+	rec := h.newEventRecorder(job.EnvironmentID, job.ID, models.ActorSystem)
+	rec.record(ctx, models.EventFailed, "Background job was abandoned", map[string]string{
+		"attempts": strconv.Itoa(job.Attempts),
+		"error":    job.Error,
+	})
+	if job.Type == models.JobTypeDetectDrift {
+		return
+	}
+	h.updateEnvironmentStatus(rec, models.StateError, "Background job was interrupted too many times: "+job.Error)
 }
 
 // provisionEnvironment handles the provisioning of a new environment
-func (h *EnvironmentHandler) provisionEnvironment(env models.Environment) {
+func (h *EnvironmentHandler) provisionEnvironment(ctx context.Context, rec eventRecorder, env models.Environment) error {
 	log.Printf("Provisioning environment: %s (%s)", env.Name, env.ID)
-	
+
 	// Update status
-	h.updateEnvironmentStatus(env.ID, "PROVISIONING", "Provisioning resources")
-	
-	// Generate Terraform variables
-	vars := map[string]interface{}{
-		"cluster_name":     env.ClusterName,
-		"region":           "us-west-2", // Get from template
-		"environment":      "dev",
-		"instance_types":   []string{"m5.large"},
-		"min_nodes":        2,
-		"max_nodes":        5,
-		"desired_nodes":    2,
-		"kubernetes_version": "1.26",
-		"vpc_cidr":         "10.0.0.0/16",
-		"resource_limits":  env.ResourceLimits,
-		"network_policy":   env.NetworkPolicy,
-		"service_mesh":     env.ServiceMesh,
-		"monitoring":       env.Monitoring,
-		"gitops":           env.GitOps,
-		"addons":           env.Addons,
-		"tags":             env.Tags,
-	}
-	
-	// Execute Terraform
-	err := h.terraformExecutor.Apply("aws", vars)
+	h.updateEnvironmentStatus(rec, models.StateProvisioning, "Provisioning resources")
+
+	if err := h.applyEnvironment(ctx, rec, env, "Environment provisioned successfully"); err != nil {
+		return err
+	}
+
+	log.Printf("Environment provisioned successfully: %s (%s)", env.Name, env.ID)
+	return nil
+}
+
+// applyEnvironment applies an environment's configuration with Terraform,
+// configures the resulting cluster and marks the environment ACTIVE with the
+// given message
+func (h *EnvironmentHandler) applyEnvironment(ctx context.Context, rec eventRecorder, env models.Environment, message string) error {
+	run, err := h.terraformInputs(ctx, rec, env)
 	if err != nil {
-		log.Printf("Failed to provision environment: %v", err)
-		h.updateEnvironmentStatus(env.ID, "ERROR", "Failed to provision resources: "+err.Error())
-		return
+		return err
+	}
+
+	// Execute Terraform against the environment's own state
+	err = run.provisioner.Apply(ctx, env.ID, "aws", run.vars, run.timeouts, h.terraformHooks(ctx, rec))
+	if err != nil {
+		log.Printf("Failed to apply environment: %v", err)
+		h.failEnvironment(ctx, rec, "Failed to provision resources", err)
+		return err
 	}
-	
+
+	return h.completeApply(ctx, rec, env, run.provisioner, message)
+}
+
+// completeApply reads the outputs of an environment's freshly applied state
+// and marks the environment ACTIVE with the given message
+func (h *EnvironmentHandler) completeApply(ctx context.Context, rec eventRecorder, env models.Environment, provisioner terraform.Provisioner, message string) error {
+	kubeconfig, consoleURL, err := h.readOutputs(ctx, rec, env, provisioner)
+	if err != nil {
+		return err
+	}
+
+	return h.markActive(ctx, rec, env, kubeconfig, consoleURL, true, message)
+}
+
+// readOutputs returns the kubeconfig and console URL from an environment's
+// freshly applied state, failing the environment if there is no kubeconfig
+func (h *EnvironmentHandler) readOutputs(ctx context.Context, rec eventRecorder, env models.Environment, provisioner terraform.Provisioner) (string, string, error) {
 	// Get outputs
-	outputs, err := h.terraformExecutor.GetOutputs("aws")
+	outputs, err := provisioner.Outputs(ctx, env.ID)
 	if err != nil {
 		log.Printf("Failed to get Terraform outputs: %v", err)
-		h.updateEnvironmentStatus(env.ID, "ERROR", "Failed to get provisioning outputs: "+err.Error())
-		return
+		h.failEnvironment(ctx, rec, "Failed to get provisioning outputs", err)
+		return "", "", err
 	}
-	
+	rec.record(ctx, models.EventOutputsRead, "Read Terraform outputs", nil)
+
 	// Extract kubeconfig
 	kubeconfig, ok := outputs["kubeconfig"].(string)
 	if !ok {
 		log.Printf("Failed to get kubeconfig from outputs")
-		h.updateEnvironmentStatus(env.ID, "ERROR", "Failed to get kubeconfig")
-		return
+		err := errors.New("terraform outputs have no kubeconfig")
+		h.failEnvironment(ctx, rec, "Failed to get kubeconfig", err)
+		return "", "", err
 	}
-	
+
 	// Extract console URL
 	consoleURL, ok := outputs["console_url"].(string)
 	if !ok {
 		consoleURL = "" // Not critical, can be empty
 	}
-	
-	// Configure Kubernetes resources
-	err = h.configureKubernetesResources(env, kubeconfig)
-	if err != nil {
-		log.Printf("Failed to configure Kubernetes resources: %v", err)
-		h.updateEnvironmentStatus(env.ID, "ERROR", "Failed to configure Kubernetes resources: "+err.Error())
-		return
-	}
-	
-	// Update environment with kubeconfig and console URL
-	ctx := context.Background()
-	_, err = h.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
-		TableName: aws.String(h.tableName),
-		Key: map[string]types.AttributeValue{
-			"ID": &types.AttributeValueMemberS{Value: env.ID},
-		},
-		UpdateExpression: aws.String("SET KubeConfig = :kubeconfig, ConsoleURL = :consoleurl, Status = :status, StatusMessage = :message, UpdatedAt = :updated"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":kubeconfig": &types.AttributeValueMemberS{Value: kubeconfig},
-			":consoleurl": &types.AttributeValueMemberS{Value: consoleURL},
-			":status":     &types.AttributeValueMemberS{Value: "ACTIVE"},
-			":message":    &types.AttributeValueMemberS{Value: "Environment provisioned successfully"},
-			":updated":    &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
-		},
+
+	return kubeconfig, consoleURL, nil
+}
+
+// markActive stores the kubeconfig and console URL of an environment and
+// marks it ACTIVE with the given message. applied says whether Terraform
+// applied the environment, which undoes any drift.
+func (h *EnvironmentHandler) markActive(ctx context.Context, rec eventRecorder, env models.Environment, kubeconfig, consoleURL string, applied bool, message string) error {
+	driftResolved := false
+	err := h.mutateEnvironment(ctx, env.ID, func(environment *models.Environment) error {
+		environment.KubeConfig = kubeconfig
+		environment.ConsoleURL = consoleURL
+		environment.UpdatedAt = time.Now().UTC()
+		if applied {
+			driftResolved = resolveDrift(environment, environment.UpdatedAt)
+		}
+		return environment.Transition(models.StateActive, message)
 	})
 	if err != nil {
 		log.Printf("Failed to update environment: %v", err)
-		return
+		rec.failed(ctx, "Failed to update environment", err)
+		return err
 	}
-	
-	log.Printf("Environment provisioned successfully: %s (%s)", env.Name, env.ID)
-}
-
-// updateEnvironment handles the update of an existing environment
-func (h *EnvironmentHandler) updateEnvironment(env models.Environment) {
-	log.Printf("Updating environment: %s (%s)", env.Name, env.ID)
-	
-	// Implementation omitted for brevity
-	// Would use Terraform to update the environment
-	
-	// Update status after successful update
-	h.updateEnvironmentStatus(env.ID, "ACTIVE", "Environment updated successfully")
+	if driftResolved {
+		rec.record(ctx, models.EventDriftResolved, "Infrastructure reconciled by an apply", nil)
+	}
+	rec.statusChanged(ctx, models.StateActive, message)
+	return nil
 }
 
 // deleteEnvironment handles the deletion of an environment
-func (h *EnvironmentHandler) deleteEnvironment(env models.Environment) {
+func (h *EnvironmentHandler) deleteEnvironment(ctx context.Context, rec eventRecorder, env models.Environment) error {
 	log.Printf("Deleting environment: %s (%s)", env.Name, env.ID)
-	
-	// Implementation omitted for brevity
-	// Would use Terraform to destroy the environment
-	
-	// Update status after successful deletion
-	h.updateEnvironmentStatus(env.ID, "DELETED", "Environment deleted successfully")
-}
 
-// configureKubernetesResources configures resources in the Kubernetes cluster
-func (h *EnvironmentHandler) configureKubernetesResources(env models.Environment, kubeconfig string) error {
-	// Implementation omitted for brevity
-	// Would configure namespaces, RBAC, resource quotas, network policies, etc.
+	run, err := h.terraformInputs(ctx, rec, env)
+	if err != nil {
+		return err
+	}
+
+	// Destroy what the environment's own state records
+	err = run.provisioner.Destroy(ctx, env.ID, "aws", run.vars, run.timeouts, h.terraformHooks(ctx, rec))
+	if err != nil {
+		log.Printf("Failed to destroy environment: %v", err)
+		h.failEnvironment(ctx, rec, "Failed to destroy resources", err)
+		return err
+	}
+
+	// Soft-delete the environment now that nothing of it is left
+	if err := h.markDeleted(rec, "Environment deleted successfully"); err != nil {
+		log.Printf("Failed to mark environment deleted: %v", err)
+		return err
+	}
+	log.Printf("Environment deleted successfully: %s (%s)", env.Name, env.ID)
 	return nil
 }
 
+// terraformRun is what a run against an environment needs, resolved from the
+// template revision the environment is pinned to
+type terraformRun struct {
+	provisioner terraform.Provisioner
+	vars        map[string]interface{}
+	timeouts    terraform.Timeouts
+}
+
+// terraformInputs resolves a run of a job's environment, failing the
+// environment if it cannot be resolved
+func (h *EnvironmentHandler) terraformInputs(ctx context.Context, rec eventRecorder, env models.Environment) (terraformRun, error) {
+	run, err := h.terraformConfig(ctx, env)
+	if err != nil {
+		log.Printf("Failed to resolve Terraform inputs of environment %s: %v", env.ID, err)
+		h.failEnvironment(ctx, rec, "Failed to resolve template", err)
+		return run, err
+	}
+	return run, nil
+}
+
+// terraformConfig resolves the provisioner, Terraform variables and phase
+// timeouts of an environment from the template revision it is pinned to
+func (h *EnvironmentHandler) terraformConfig(ctx context.Context, env models.Environment) (terraformRun, error) {
+	template, err := environmentTemplate(ctx, h.templates, env)
+	if err != nil {
+		return terraformRun{}, fmt.Errorf("failed to get template %s revision %d: %w", env.TemplateID, env.TemplateRevision, err)
+	}
+
+	provisioner, err := h.provisioners.Get(template.Provisioner)
+	if err != nil {
+		return terraformRun{}, err
+	}
+
+	timeouts, err := template.Timeouts.Durations()
+	if err != nil {
+		return terraformRun{}, fmt.Errorf("invalid template timeouts: %w", err)
+	}
+
+	return terraformRun{
+		provisioner: provisioner,
+		vars:        terraformVars(env, template),
+		timeouts:    timeouts,
+	}, nil
+}
+
+// terraformHooks returns the executor hooks of a job: its Terraform phases go
+// to the environment's timeline and its output to the environment's logs
+func (h *EnvironmentHandler) terraformHooks(ctx context.Context, rec eventRecorder) terraform.Hooks {
+	hooks := rec.terraformHooks(ctx)
+	hooks.Output = h.terraformOutput(ctx, rec)
+	return hooks
+}
+
 // getEnvironmentDetailedStatus gets detailed status information about an environment
 func (h *EnvironmentHandler) getEnvironmentDetailedStatus(env models.Environment) (models.EnvironmentStatus, error) {
 	// Implementation omitted for brevity
 	// Would get detailed status from Kubernetes API
-	
+
 	// Mock data for example
 	status := models.EnvironmentStatus{
-		Status:        env.Status,
-		StatusMessage: env.StatusMessage,
+		Status:          env.Status,
+		StatusMessage:   env.StatusMessage,
+		FailureCategory: env.FailureCategory,
 		ResourceUtilization: models.ResourceUsage{
-			CPUUsage:         "1.5",
-			CPUPercentage:    30.0,
-			MemoryUsage:      "4Gi",
-			MemoryPercentage: 40.0,
-			StorageUsage:     "10Gi",
+			CPUUsage:          "1.5",
+			CPUPercentage:     30.0,
+			MemoryUsage:       "4Gi",
+			MemoryPercentage:  40.0,
+			StorageUsage:      "10Gi",
 			StoragePercentage: 20.0,
-			NodeCount:        2,
-			NamespaceCount:   3,
-			PodCount:         10,
-			ServiceCount:     5,
+			NodeCount:         2,
+			NamespaceCount:    3,
+			PodCount:          10,
+			ServiceCount:      5,
 		},
 		NodeStatus: []models.NodeStatus{
 			{
@@ -665,27 +1075,176 @@ func (h *EnvironmentHandler) getEnvironmentDetailedStatus(env models.Environment
 		UptimePercentage:        100.0,
 		ResourceAllocationRatio: 0.4,
 	}
-	
+
 	return status, nil
 }
 
-// updateEnvironmentStatus updates the status of an environment
-func (h *EnvironmentHandler) updateEnvironmentStatus(envID, status, message string) {
-	ctx := context.Background()
-	
-	_, err := h.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
-		TableName: aws.String(h.tableName),
-		Key: map[string]types.AttributeValue{
-			"ID": &types.AttributeValueMemberS{Value: envID},
-		},
-		UpdateExpression: aws.String("SET Status = :status, StatusMessage = :message, UpdatedAt = :updated"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":status":  &types.AttributeValueMemberS{Value: status},
-			":message": &types.AttributeValueMemberS{Value: message},
-			":updated": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
-		},
+// mutateEnvironment applies fn to the latest stored copy of an environment and
+// saves it, re-reading and retrying if a concurrent writer bumps the version.
+// An error from fn aborts the mutation.
+func (h *EnvironmentHandler) mutateEnvironment(ctx context.Context, envID string, fn func(*models.Environment) error) error {
+	for attempt := 0; attempt < maxMutateAttempts; attempt++ {
+		environment, err := h.store.Get(ctx, envID)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(&environment); err != nil {
+			return err
+		}
+
+		err = h.store.Update(ctx, &environment)
+		if !errors.Is(err, store.ErrVersionConflict) {
+			return err
+		}
+	}
+	return store.ErrVersionConflict
+}
+
+// environmentTemplate returns the template revision an environment is pinned
+// to. Environments created before templates were versioned follow the latest
+// revision.
+func environmentTemplate(ctx context.Context, templates store.TemplateStore, env models.Environment) (models.ClusterTemplate, error) {
+	if env.TemplateRevision == 0 {
+		return templates.Get(ctx, env.TemplateID)
+	}
+	return templates.GetRevision(ctx, env.TemplateID, env.TemplateRevision)
+}
+
+// enqueueJob queues background work on an environment. If the job cannot be
+// stored it marks the environment as failed, writes a 500 response and
+// returns false.
+func (h *EnvironmentHandler) enqueueJob(w http.ResponseWriter, r *http.Request, env models.Environment, jobType string) bool {
+	return h.submitJob(w, r, env, models.Job{Type: jobType})
+}
+
+// submitJob queues a job of the caller on an environment, like enqueueJob,
+// for jobs that carry more than their type
+func (h *EnvironmentHandler) submitJob(w http.ResponseWriter, r *http.Request, env models.Environment, job models.Job) bool {
+	principal, _ := middleware.PrincipalFromContext(r.Context())
+	rec := h.newEventRecorder(env.ID, "", principal.Subject)
+	job.EnvironmentID = env.ID
+	job.UserID = principal.Subject
+	job, err := h.queue.Submit(r.Context(), job)
+	if err != nil {
+		log.Printf("Failed to queue %s job for environment %s: %v", job.Type, env.ID, err)
+		rec.failed(r.Context(), "Failed to queue background job", err)
+		h.updateEnvironmentStatus(rec, models.StateError, "Failed to queue background job")
+		http.Error(w, "Failed to queue environment job", http.StatusInternalServerError)
+		return false
+	}
+
+	rec.jobID = job.ID
+	rec.record(r.Context(), models.EventQueued, fmt.Sprintf("Queued %s job", job.Type), map[string]string{"jobType": job.Type})
+	return true
+}
+
+// recordRequested records that the caller asked for an operation on an
+// environment
+func (h *EnvironmentHandler) recordRequested(r *http.Request, env models.Environment, operation string) {
+	principal, _ := middleware.PrincipalFromContext(r.Context())
+	rec := h.newEventRecorder(env.ID, "", principal.Subject)
+	rec.record(r.Context(), models.EventRequested, "Requested "+operation, map[string]string{
+		"operation":        operation,
+		"templateId":       env.TemplateID,
+		"templateRevision": strconv.FormatInt(env.TemplateRevision, 10),
+	})
+}
+
+// queuePosition returns the queue position of the oldest job waiting on an
+// environment, or 0 if none is waiting
+func (h *EnvironmentHandler) queuePosition(ctx context.Context, envID string) (int, error) {
+	waiting, err := h.queue.List(ctx, store.JobFilter{
+		EnvironmentID: envID,
+		Statuses:      []string{models.JobStatusPending},
 	})
+	if err != nil || len(waiting) == 0 {
+		return 0, err
+	}
+	return h.queue.Position(ctx, waiting[0].ID)
+}
+
+// environmentETag returns the strong entity tag for an environment's version
+func environmentETag(env models.Environment) string {
+	return versionETag(env.Version)
+}
+
+// failEnvironment records a failed step and moves the recorder's environment
+// to ERROR, with the category of a failed Terraform phase. A job that was
+// interrupted leaves the status alone: a cancelled job's status is set by
+// RunJob, and a job stopped by shutdown or a lost lease will be run again.
+func (h *EnvironmentHandler) failEnvironment(ctx context.Context, rec eventRecorder, message string, err error) {
+	rec.failed(ctx, message, err)
+	if ctx.Err() != nil {
+		return
+	}
+
+	// The failure's category is written with the status, so clients never
+	// see the ERROR status without it
+	statusMessage := message + ": " + err.Error()
+	category := terraform.FailureCategory(err)
+	err = h.mutateEnvironment(context.Background(), rec.envID, func(environment *models.Environment) error {
+		if err := environment.Transition(models.StateError, statusMessage); err != nil {
+			return err
+		}
+		environment.FailureCategory = category
+		environment.UpdatedAt = time.Now().UTC()
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to update environment status: %v", err)
+		return
+	}
+	rec.statusChanged(context.Background(), models.StateError, statusMessage)
+}
+
+// cancelEnvironment records that a job was cancelled and moves its
+// environment to ERROR, from which it can be updated or deleted again. A
+// cancelled drift check leaves the environment as it was.
+func (h *EnvironmentHandler) cancelEnvironment(rec eventRecorder, job models.Job) {
+	rec.record(context.Background(), models.EventCancelled, fmt.Sprintf("Cancelled %s job", job.Type), map[string]string{
+		"jobType": job.Type,
+	})
+	if job.Type == models.JobTypeDetectDrift {
+		return
+	}
+	h.updateEnvironmentStatus(rec, models.StateError, "Operation cancelled")
+}
+
+// markDeleted moves the recorder's environment to DELETED and soft-deletes it
+// in the same write, so that it disappears from the API only once it has
+// been destroyed
+func (h *EnvironmentHandler) markDeleted(rec eventRecorder, message string) error {
+	ctx := context.Background()
+	for attempt := 0; attempt < maxMutateAttempts; attempt++ {
+		environment, err := h.store.Get(ctx, rec.envID)
+		if err != nil {
+			return err
+		}
+		if err := environment.Transition(models.StateDeleted, message); err != nil {
+			return err
+		}
+
+		err = h.store.SoftDelete(ctx, &environment)
+		if errors.Is(err, store.ErrVersionConflict) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		rec.statusChanged(ctx, models.StateDeleted, message)
+		return nil
+	}
+	return store.ErrVersionConflict
+}
+
+// updateEnvironmentStatus moves the recorder's environment to a new status and
+// records the change, logging transitions the lifecycle does not allow
+func (h *EnvironmentHandler) updateEnvironmentStatus(rec eventRecorder, status models.EnvironmentState, message string) {
+	err := h.store.UpdateStatus(context.Background(), rec.envID, status, message)
 	if err != nil {
 		log.Printf("Failed to update environment status: %v", err)
+		return
 	}
-}
\ No newline at end of file
+	rec.statusChanged(context.Background(), status, message)
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/store"
+)
+
+// GetEnvironmentLock returns the lock held on an environment by the operation
+// working on it
+func (h *EnvironmentHandler) GetEnvironmentLock(w http.ResponseWriter, r *http.Request) {
+	environment, ok := h.loadEnvironment(w, r)
+	if !ok {
+		return
+	}
+
+	lock, err := h.locker.Get(r.Context(), environment.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Environment is not locked", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get environment lock: %v", err)
+		http.Error(w, "Failed to retrieve environment lock", http.StatusInternalServerError)
+		return
+	}
+
+	// Return lock
+	lock.Token = ""
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lock)
+}
+
+// BreakEnvironmentLock removes the lock on an environment for platform admins,
+// for a lock left by an operation that is stuck. An operation still holding
+// the lock is stopped at its next heartbeat, as if it had been cancelled.
+func (h *EnvironmentHandler) BreakEnvironmentLock(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	// Deleted environments are included, since a stuck deletion holds the
+	// lock of an environment that is already soft-deleted
+	envID := mux.Vars(r)["id"]
+	if _, err := h.store.Get(r.Context(), envID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Environment not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to get environment: %v", err)
+		http.Error(w, "Failed to retrieve environment", http.StatusInternalServerError)
+		return
+	}
+
+	lock, err := h.locker.Break(r.Context(), envID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Environment is not locked", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to break environment lock: %v", err)
+		http.Error(w, "Failed to break environment lock", http.StatusInternalServerError)
+		return
+	}
+
+	rec := h.newEventRecorder(envID, lock.JobID, principal.Subject)
+	rec.record(r.Context(), models.EventLockBroken, fmt.Sprintf("Broke the lock held by %s for %s", lock.Owner, lock.Operation), map[string]string{
+		"owner":       lock.Owner,
+		"operation":   lock.Operation,
+		"heartbeatAt": lock.HeartbeatAt.Format(time.RFC3339),
+	})
+
+	// Return the broken lock
+	lock.Token = ""
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lock)
+}
+
+// checkUnlocked writes a 409 response and returns false if an operation holds
+// the lock of an environment. Work queued by the request would otherwise
+// wait behind it.
+func (h *EnvironmentHandler) checkUnlocked(w http.ResponseWriter, r *http.Request, env models.Environment) bool {
+	lock, err := h.locker.Get(r.Context(), env.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		return true
+	}
+	if err != nil {
+		log.Printf("Failed to get environment lock: %v", err)
+		http.Error(w, "Failed to retrieve environment lock", http.StatusInternalServerError)
+		return false
+	}
+	if lock.Expired(time.Now().UTC()) {
+		return true
+	}
+
+	lockConflict(w, lock)
+	return false
+}
+
+// lockConflict writes the 409 response for a request refused because of a
+// held lock
+func lockConflict(w http.ResponseWriter, lock models.EnvironmentLock) {
+	http.Error(w, fmt.Sprintf("Environment is locked by a %s operation on %s until %s",
+		lock.Operation, lock.Owner, lock.ExpiresAt.Format(time.RFC3339)), http.StatusConflict)
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/store"
+)
+
+const (
+	// logPollInterval is how often a follower re-reads the log store. Lines
+	// written by this process wake followers sooner; the poll picks up lines
+	// written by workers in other processes.
+	logPollInterval = 2 * time.Second
+
+	// logKeepAliveInterval is how often an idle stream sends a comment, so
+	// that proxies do not close it
+	logKeepAliveInterval = 15 * time.Second
+)
+
+// logBroker wakes the followers of an environment's logs when this process
+// appends to them
+type logBroker struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan struct{}]struct{}
+}
+
+// newLogBroker creates a broker with no subscribers
+func newLogBroker() *logBroker {
+	return &logBroker{subscribers: make(map[string]map[chan struct{}]struct{})}
+}
+
+// subscribe returns a channel that receives a value after new lines are
+// appended to an environment's logs, and a function that unsubscribes it
+func (b *logBroker) subscribe(envID string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers[envID] == nil {
+		b.subscribers[envID] = make(map[chan struct{}]struct{})
+	}
+	b.subscribers[envID][ch] = struct{}{}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers[envID], ch)
+		if len(b.subscribers[envID]) == 0 {
+			delete(b.subscribers, envID)
+		}
+	}
+}
+
+// notify wakes every follower of an environment's logs without blocking
+func (b *logBroker) notify(envID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers[envID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// terraformOutput returns a hook that persists each line of Terraform output
+// to the recorder's environment and job and wakes its followers
+func (h *EnvironmentHandler) terraformOutput(ctx context.Context, rec eventRecorder) func(phase, stream, line string) {
+	return func(phase, stream, text string) {
+		line := models.LogLine{
+			EnvironmentID: rec.envID,
+			JobID:         rec.jobID,
+			Phase:         phase,
+			Stream:        stream,
+			Text:          text,
+			CreatedAt:     time.Now().UTC(),
+		}
+		if err := h.logs.Append(detach(ctx), &line); err != nil {
+			log.Printf("Failed to store log line for environment %s: %v", rec.envID, err)
+			return
+		}
+		h.logBroker.notify(rec.envID)
+	}
+}
+
+// ListEnvironmentLogs returns the Terraform output of an environment, oldest
+// first, narrowed by the jobId and after (a sequence) query parameters. With
+// follow=true it streams the output as Server-Sent Events instead, until the
+// environment has no more jobs waiting or running.
+func (h *EnvironmentHandler) ListEnvironmentLogs(w http.ResponseWriter, r *http.Request) {
+	environment, ok := h.loadEnvironment(w, r)
+	if !ok {
+		return
+	}
+
+	queryParams := r.URL.Query()
+	filter := store.LogFilter{JobID: queryParams.Get("jobId")}
+	if after := queryParams.Get("after"); after != "" {
+		sequence, err := strconv.ParseInt(after, 10, 64)
+		if err != nil || sequence < 0 {
+			http.Error(w, "Invalid after parameter", http.StatusBadRequest)
+			return
+		}
+		filter.AfterSequence = sequence
+	}
+
+	if queryParams.Get("follow") == "true" {
+		// A reconnecting EventSource resumes after the last line it saw
+		if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+			if sequence, err := strconv.ParseInt(lastEventID, 10, 64); err == nil {
+				filter.AfterSequence = sequence
+			}
+		}
+		h.followEnvironmentLogs(w, r, environment.ID, filter)
+		return
+	}
+
+	lines, err := h.logs.List(r.Context(), environment.ID, filter)
+	if err != nil {
+		log.Printf("Failed to list log lines: %v", err)
+		http.Error(w, "Failed to retrieve logs", http.StatusInternalServerError)
+		return
+	}
+
+	// Return log lines
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lines)
+}
+
+// followEnvironmentLogs streams an environment's log lines as Server-Sent
+// Events. Each line is a "log" event whose id is its sequence; an "end" event
+// closes the stream once no job is left to write more.
+func (h *EnvironmentHandler) followEnvironmentLogs(w http.ResponseWriter, r *http.Request, envID string, filter store.LogFilter) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// The stream outlives the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Failed to clear write deadline for log stream: %v", err)
+	}
+
+	wake, unsubscribe := h.logBroker.subscribe(envID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	poll := time.NewTicker(logPollInterval)
+	defer poll.Stop()
+	keepAlive := time.NewTicker(logKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	ctx := r.Context()
+	for {
+		// Check for pending work before reading, so that lines written by
+		// a job that finishes in between are still sent
+		busy, err := h.environmentBusy(ctx, envID)
+		if err != nil {
+			log.Printf("Failed to check jobs for environment %s: %v", envID, err)
+			return
+		}
+
+		lines, err := h.logs.List(ctx, envID, filter)
+		if err != nil {
+			log.Printf("Failed to list log lines: %v", err)
+			return
+		}
+		for _, line := range lines {
+			data, err := json.Marshal(line)
+			if err != nil {
+				log.Printf("Failed to marshal log line: %v", err)
+				return
+			}
+			fmt.Fprintf(w, "id: %d\nevent: log\ndata: %s\n\n", line.Sequence, data)
+			filter.AfterSequence = line.Sequence
+		}
+		if !busy {
+			fmt.Fprint(w, "event: end\ndata: {}\n\n")
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-poll.C:
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// environmentBusy reports whether an environment has jobs waiting or running
+func (h *EnvironmentHandler) environmentBusy(ctx context.Context, envID string) (bool, error) {
+	active, err := h.queue.List(ctx, store.JobFilter{
+		EnvironmentID: envID,
+		Statuses:      []string{models.JobStatusPending, models.JobStatusRunning},
+	})
+	return len(active) > 0, err
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/jobs"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/middleware"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/store"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/terraform"
+)
+
+// PlanEnvironment previews the changes that applying an environment's current
+// configuration would make. The plan is saved in the plan store so that
+// exactly those changes can later be applied with ApplyEnvironmentPlan, by
+// any replica. Terraform runs while the request waits, holding the
+// environment's lock.
+func (h *EnvironmentHandler) PlanEnvironment(w http.ResponseWriter, r *http.Request) {
+	environment, ok := h.loadEnvironment(w, r)
+	if !ok {
+		return
+	}
+
+	// Planning uses the environment's workspace, so it waits until no work
+	// on the environment is in flight
+	if !environment.Status.Allows(models.OperationApply) {
+		http.Error(w, fmt.Sprintf("Cannot plan environment while it is %s", environment.Status), http.StatusConflict)
+		return
+	}
+	busy, err := h.environmentBusy(r.Context(), environment.ID)
+	if err != nil {
+		log.Printf("Failed to list jobs: %v", err)
+		http.Error(w, "Failed to retrieve jobs", http.StatusInternalServerError)
+		return
+	}
+	if busy {
+		http.Error(w, "Cannot plan environment while an operation is in progress", http.StatusConflict)
+		return
+	}
+
+	run, ok := h.loadTerraformRun(w, r, environment)
+	if !ok {
+		return
+	}
+
+	principal, _ := middleware.PrincipalFromContext(r.Context())
+	lock, err := h.locker.Acquire(r.Context(), environment.ID, models.LockOperationPlan, "", principal.Subject)
+	if errors.Is(err, store.ErrLocked) {
+		if held, err := h.locker.Get(r.Context(), environment.ID); err == nil {
+			lockConflict(w, held)
+			return
+		}
+		http.Error(w, "Cannot plan environment while an operation is in progress", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to lock environment %s: %v", environment.ID, err)
+		http.Error(w, "Failed to lock environment", http.StatusInternalServerError)
+		return
+	}
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+	defer h.locker.Hold(lock, cancel)()
+
+	// Terraform may run longer than the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Failed to clear write deadline for plan: %v", err)
+	}
+
+	rec := h.newEventRecorder(environment.ID, "", principal.Subject)
+	plan, err := run.provisioner.Plan(ctx, environment.ID, "aws", run.vars, run.timeouts, h.terraformHooks(ctx, rec))
+	if err != nil {
+		log.Printf("Failed to plan environment: %v", err)
+		rec.failed(r.Context(), "Failed to plan changes", err)
+		if errors.Is(context.Cause(ctx), jobs.ErrLockBroken) {
+			http.Error(w, "Planning was stopped because the environment's lock was broken", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to plan changes; the environment's logs have Terraform's output", http.StatusInternalServerError)
+		return
+	}
+	if err := h.plans.Create(r.Context(), &plan); err != nil {
+		log.Printf("Failed to save plan: %v", err)
+		rec.failed(r.Context(), "Failed to save plan", err)
+		http.Error(w, "Failed to save plan", http.StatusInternalServerError)
+		return
+	}
+	rec.record(r.Context(), models.EventPlanCreated, fmt.Sprintf("Planned %d to add, %d to change, %d to destroy",
+		plan.Summary.Add, plan.Summary.Change, plan.Summary.Destroy), map[string]string{"planId": plan.ID})
+
+	// Return the plan
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(plan)
+}
+
+// GetEnvironmentPlan returns a saved plan of an environment
+func (h *EnvironmentHandler) GetEnvironmentPlan(w http.ResponseWriter, r *http.Request) {
+	environment, ok := h.loadEnvironment(w, r)
+	if !ok {
+		return
+	}
+
+	plan, ok := h.loadPlan(w, r, environment)
+	if !ok {
+		return
+	}
+
+	// Return the plan
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plan)
+}
+
+// ApplyEnvironmentPlan queues the application of a saved plan. The plan is
+// refused if the environment's configuration has changed since it was made.
+func (h *EnvironmentHandler) ApplyEnvironmentPlan(w http.ResponseWriter, r *http.Request) {
+	environment, ok := h.loadEnvironment(w, r)
+	if !ok {
+		return
+	}
+
+	// Reject the apply if the caller's copy is stale
+	if !ifMatch(r, environmentETag(environment)) {
+		http.Error(w, "Environment has been modified", http.StatusPreconditionFailed)
+		return
+	}
+
+	run, ok := h.loadTerraformRun(w, r, environment)
+	if !ok {
+		return
+	}
+
+	plan, ok := h.loadPlan(w, r, environment)
+	if !ok {
+		return
+	}
+
+	// Reject the apply if work on the environment is still in flight
+	if !startOperation(w, &environment, models.OperationApply, "Applying plan "+plan.ID) {
+		return
+	}
+	if !h.checkUnlocked(w, r, environment) {
+		return
+	}
+
+	if err := run.provisioner.CheckPlan(plan, run.vars); err != nil {
+		if errors.Is(err, terraform.ErrPlanStale) {
+			http.Error(w, "Plan no longer matches the environment's configuration; plan it again", http.StatusConflict)
+			return
+		}
+		log.Printf("Failed to check plan: %v", err)
+		http.Error(w, "Failed to check plan", http.StatusInternalServerError)
+		return
+	}
+
+	environment.UpdatedAt = time.Now().UTC()
+
+	err := h.store.Update(r.Context(), &environment)
+	if errors.Is(err, store.ErrVersionConflict) {
+		http.Error(w, "Environment has been modified", http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save environment: %v", err)
+		http.Error(w, "Failed to save environment", http.StatusInternalServerError)
+		return
+	}
+	h.recordRequested(r, environment, models.OperationApply)
+
+	// Queue the apply in background
+	if !h.submitJob(w, r, environment, models.Job{Type: models.JobTypeApplyPlan, PlanID: plan.ID}) {
+		return
+	}
+
+	// Return updated environment
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", environmentETag(environment))
+	json.NewEncoder(w).Encode(environment)
+}
+
+// loadTerraformRun resolves a run against an environment for a request,
+// writing a 500 response and returning false if its template cannot be
+// resolved
+func (h *EnvironmentHandler) loadTerraformRun(w http.ResponseWriter, r *http.Request, env models.Environment) (terraformRun, bool) {
+	run, err := h.terraformConfig(r.Context(), env)
+	if err != nil {
+		log.Printf("Failed to resolve Terraform inputs of environment %s: %v", env.ID, err)
+		http.Error(w, "Failed to resolve template", http.StatusInternalServerError)
+		return run, false
+	}
+	return run, true
+}
+
+// loadPlan fetches the saved plan named by the {planId} path variable,
+// writing a 404 or 500 response and returning false if there is none
+func (h *EnvironmentHandler) loadPlan(w http.ResponseWriter, r *http.Request, env models.Environment) (models.Plan, bool) {
+	plan, err := h.plans.Get(r.Context(), env.ID, mux.Vars(r)["planId"])
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Plan not found", http.StatusNotFound)
+		return plan, false
+	}
+	if err != nil {
+		log.Printf("Failed to get plan: %v", err)
+		http.Error(w, "Failed to retrieve plan", http.StatusInternalServerError)
+		return plan, false
+	}
+	return plan, true
+}
+
+// applyEnvironmentPlan applies a saved plan to an environment, then finishes
+// like any other apply. The plan is removed once Terraform has run it,
+// whether or not it succeeded.
+func (h *EnvironmentHandler) applyEnvironmentPlan(ctx context.Context, rec eventRecorder, env models.Environment, planID string) error {
+	log.Printf("Applying plan %s to environment: %s (%s)", planID, env.Name, env.ID)
+
+	run, err := h.terraformInputs(ctx, rec, env)
+	if err != nil {
+		return err
+	}
+
+	plan, err := h.plans.Get(ctx, env.ID, planID)
+	if errors.Is(err, store.ErrNotFound) {
+		err = fmt.Errorf("plan %s has expired or was already applied", planID)
+	}
+	if err != nil {
+		log.Printf("Failed to get plan: %v", err)
+		h.failEnvironment(ctx, rec, "Failed to load plan", err)
+		return err
+	}
+
+	err = run.provisioner.ApplyPlan(ctx, env.ID, "aws", plan, run.vars, run.timeouts, h.terraformHooks(ctx, rec))
+	if deleteErr := h.plans.Delete(context.Background(), env.ID, planID); deleteErr != nil {
+		log.Printf("Failed to delete plan %s: %v", planID, deleteErr)
+	}
+	if err != nil {
+		log.Printf("Failed to apply plan: %v", err)
+		h.failEnvironment(ctx, rec, "Failed to apply plan", err)
+		return err
+	}
+
+	if err := h.completeApply(ctx, rec, env, run.provisioner, "Plan applied successfully"); err != nil {
+		return err
+	}
+
+	log.Printf("Plan %s applied successfully: %s (%s)", planID, env.Name, env.ID)
+	return nil
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+)
+
+// diffEnvironment lists the spec fields that differ between an environment
+// before and after a patch, with the update step that applies each. Node
+// counts, addons and tags are Terraform's; the other resource limits are
+// quotas inside the cluster. A maxNodeCount the template caps anyway changes
+// no node count, so it is only stored.
+func diffEnvironment(before, after models.Environment, template models.ClusterTemplate) []models.SpecChange {
+	var changes []models.SpecChange
+	add := func(field, step string, changed bool) {
+		if changed {
+			changes = append(changes, models.SpecChange{Field: field, Step: step})
+		}
+	}
+
+	add("description", models.UpdateStepNone, before.Description != after.Description)
+	add("teamId", models.UpdateStepNone, before.TeamID != after.TeamID)
+
+	limitsBefore, limitsAfter := before.ResourceLimits, after.ResourceLimits
+	if limitsBefore.MaxNodeCount != limitsAfter.MaxNodeCount {
+		step := models.UpdateStepNone
+		minBefore, maxBefore, desiredBefore := nodeCounts(before, template)
+		minAfter, maxAfter, desiredAfter := nodeCounts(after, template)
+		if minBefore != minAfter || maxBefore != maxAfter || desiredBefore != desiredAfter {
+			step = models.UpdateStepTerraform
+		}
+		add("resourceLimits.maxNodeCount", step, true)
+	}
+	add("resourceLimits.cpu", models.UpdateStepKubernetes, limitsBefore.CPU != limitsAfter.CPU)
+	add("resourceLimits.memory", models.UpdateStepKubernetes, limitsBefore.Memory != limitsAfter.Memory)
+	add("resourceLimits.storage", models.UpdateStepKubernetes, limitsBefore.Storage != limitsAfter.Storage)
+	add("resourceLimits.maxNamespaces", models.UpdateStepKubernetes, limitsBefore.MaxNamespaces != limitsAfter.MaxNamespaces)
+	add("resourceLimits.maxLoadBalancers", models.UpdateStepKubernetes, limitsBefore.MaxLoadBalancers != limitsAfter.MaxLoadBalancers)
+
+	add("networkPolicy", models.UpdateStepKubernetes, !reflect.DeepEqual(before.NetworkPolicy, after.NetworkPolicy))
+	add("serviceMesh", models.UpdateStepKubernetes, !reflect.DeepEqual(before.ServiceMesh, after.ServiceMesh))
+	add("monitoring", models.UpdateStepKubernetes, !reflect.DeepEqual(before.Monitoring, after.Monitoring))
+	add("gitOps", models.UpdateStepKubernetes, !reflect.DeepEqual(before.GitOps, after.GitOps))
+
+	add("addons", models.UpdateStepTerraform, !sameAddons(before.Addons, after.Addons))
+	add("tags", models.UpdateStepTerraform, len(before.Tags)+len(after.Tags) > 0 && !reflect.DeepEqual(before.Tags, after.Tags))
+
+	return changes
+}
+
+// clusterChanges returns the fields of changes that are applied inside the
+// cluster, which updates cannot change
+func clusterChanges(changes []models.SpecChange) []string {
+	var fields []string
+	for _, change := range changes {
+		if change.Step == models.UpdateStepKubernetes {
+			fields = append(fields, change.Field)
+		}
+	}
+	return fields
+}
+
+// sameAddons reports whether two addon lists enable the same addons, in any
+// order
+func sameAddons(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return reflect.DeepEqual(a, b)
+}
+
+// updateEnvironment applies the changes of an UPDATE job by running only the
+// steps they need. A job without steps, such as a reconcile, runs all of
+// them.
+func (h *EnvironmentHandler) updateEnvironment(ctx context.Context, rec eventRecorder, env models.Environment, job models.Job) error {
+	log.Printf("Updating environment: %s (%s)", env.Name, env.ID)
+
+	steps := job.Steps
+	if len(steps) == 0 {
+		steps = models.UpdateSteps
+	}
+	runs := make(map[string]bool, len(steps))
+	for _, step := range steps {
+		runs[step] = true
+	}
+
+	// A job queued before changes inside the cluster were refused may still
+	// ask for them
+	if runs[models.UpdateStepKubernetes] {
+		err := errors.New("changes inside the cluster are not supported")
+		h.failEnvironment(ctx, rec, "Failed to configure Kubernetes resources", err)
+		return err
+	}
+
+	// Without a Terraform run, the outputs of the last one are kept
+	kubeconfig, consoleURL := env.KubeConfig, env.ConsoleURL
+	if runs[models.UpdateStepTerraform] {
+		run, err := h.terraformInputs(ctx, rec, env)
+		if err != nil {
+			return err
+		}
+
+		// Execute Terraform against the environment's own state
+		err = run.provisioner.Apply(ctx, env.ID, "aws", run.vars, run.timeouts, h.terraformHooks(ctx, rec))
+		if err != nil {
+			log.Printf("Failed to apply environment: %v", err)
+			h.failEnvironment(ctx, rec, "Failed to provision resources", err)
+			return err
+		}
+
+		kubeconfig, consoleURL, err = h.readOutputs(ctx, rec, env, run.provisioner)
+		if err != nil {
+			return err
+		}
+	}
+
+	message, details := describeChanges(job.Changes, steps)
+	rec.record(ctx, models.EventChangesApplied, message, details)
+
+	if err := h.markActive(ctx, rec, env, kubeconfig, consoleURL, runs[models.UpdateStepTerraform], "Environment updated successfully"); err != nil {
+		return err
+	}
+
+	log.Printf("Environment updated successfully: %s (%s)", env.Name, env.ID)
+	return nil
+}
+
+// describeChanges summarizes the changes an update applied and the steps it
+// ran, if any, with the changed fields by step in the details
+func describeChanges(changes []models.SpecChange, steps []string) (string, map[string]string) {
+	details := map[string]string{}
+	if len(steps) > 0 {
+		details["steps"] = strings.Join(steps, ",")
+	}
+	if len(changes) == 0 {
+		return "Re-applied the environment's configuration", details
+	}
+
+	byStep := make(map[string][]string)
+	for _, change := range changes {
+		byStep[change.Step] = append(byStep[change.Step], change.Field)
+	}
+
+	var parts []string
+	for _, step := range []string{models.UpdateStepTerraform, models.UpdateStepNone} {
+		fields := byStep[step]
+		if len(fields) == 0 {
+			continue
+		}
+		details[strings.ToLower(step)] = strings.Join(fields, ",")
+
+		switch step {
+		case models.UpdateStepTerraform:
+			parts = append(parts, strings.Join(fields, ", ")+" with Terraform")
+		default:
+			parts = append(parts, strings.Join(fields, ", ")+" stored only")
+		}
+	}
+	noun := "changes"
+	if len(changes) == 1 {
+		noun = "change"
+	}
+	return fmt.Sprintf("Applied %d %s: %s", len(changes), noun, strings.Join(parts, "; ")), details
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+)
+
+func TestUpdateEnvironmentRejectsClusterChanges(t *testing.T) {
+	limits := testResourceLimits()
+	limits.CPU = "4"
+	moreNodes := testResourceLimits()
+	moreNodes.MaxNodeCount = 2
+	both := moreNodes
+	both.Memory = "8Gi"
+
+	tests := []struct {
+		name  string
+		patch map[string]interface{}
+		want  int
+	}{
+		{"description", map[string]interface{}{"description": "changed"}, http.StatusOK},
+		{"node count", map[string]interface{}{"resourceLimits": moreNodes}, http.StatusOK},
+		{"quota", map[string]interface{}{"resourceLimits": limits}, http.StatusUnprocessableEntity},
+		{"network policy", map[string]interface{}{"networkPolicy": models.NetworkPolicy{DefaultDenyIngress: true}}, http.StatusUnprocessableEntity},
+		{"service mesh", map[string]interface{}{"serviceMesh": models.ServiceMeshConfig{Enabled: true}}, http.StatusUnprocessableEntity},
+		{"monitoring", map[string]interface{}{"monitoring": models.MonitoringConfig{EnablePrometheus: true}}, http.StatusUnprocessableEntity},
+		{"gitops", map[string]interface{}{"gitOps": models.GitOpsConfig{Enabled: true}}, http.StatusUnprocessableEntity},
+		{"node count and quota", map[string]interface{}{"resourceLimits": both}, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t)
+			environment := seedEnvironment(t, h, "env", owner.Subject, "")
+
+			w := serve(h.UpdateEnvironment, http.MethodPatch, "/environments/"+environment.ID, tt.patch, &owner, environment.ID)
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+
+			// A rejected patch changes nothing
+			stored, err := h.store.Get(context.Background(), environment.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == http.StatusUnprocessableEntity && stored.Version != environment.Version {
+				t.Fatalf("rejected patch saved version %d", stored.Version)
+			}
+		})
+	}
+}
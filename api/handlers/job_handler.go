@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/jobs"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/store"
+)
+
+// JobHandler gives platform admins a view of the background job queue
+type JobHandler struct {
+	queue *jobs.Queue
+}
+
+// NewJobHandler creates a new job handler
+func NewJobHandler(queue *jobs.Queue) *JobHandler {
+	return &JobHandler{queue: queue}
+}
+
+// ListJobs returns the jobs matching the status and environmentId query
+// parameters, oldest first. status may be repeated.
+func (h *JobHandler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+
+	queryParams := r.URL.Query()
+	filter := store.JobFilter{
+		EnvironmentID: queryParams.Get("environmentId"),
+		Statuses:      queryParams["status"],
+	}
+
+	jobList, err := h.queue.List(r.Context(), filter)
+	if err != nil {
+		log.Printf("Failed to list jobs: %v", err)
+		http.Error(w, "Failed to retrieve jobs", http.StatusInternalServerError)
+		return
+	}
+
+	// Return jobs
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobList)
+}
+
+// GetJob returns a specific job
+func (h *JobHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+
+	job, err := h.queue.Get(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get job: %v", err)
+		http.Error(w, "Failed to retrieve job", http.StatusInternalServerError)
+		return
+	}
+
+	// Return job
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
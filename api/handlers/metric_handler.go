@@ -0,0 +1,422 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jbrcoleman/k8s-env-provisioner/api/jobs"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/pricing"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/store"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/terraform"
+)
+
+// Group-by dimensions accepted by the metrics endpoints
+const (
+	GroupByUser     = "user"
+	GroupByTeam     = "team"
+	GroupByTemplate = "template"
+)
+
+// defaultMetricsWindow is the date range reported when none is given
+const defaultMetricsWindow = 30 * 24 * time.Hour
+
+// unassignedGroup is the group key of environments without a team
+const unassignedGroup = "unassigned"
+
+// MetricHandler reports usage and estimated cost of environments, and the
+// disk used by their workspaces and the time taken by provisioning phases
+type MetricHandler struct {
+	environments store.EnvironmentStore
+	templates    store.TemplateStore
+	pricing      *pricing.Table
+	janitor      *jobs.Janitor
+	provisioners terraform.Provisioners
+}
+
+// NewMetricHandler creates a new metric handler
+func NewMetricHandler(environmentStore store.EnvironmentStore, templateStore store.TemplateStore, pricingTable *pricing.Table, janitor *jobs.Janitor, provisioners terraform.Provisioners) *MetricHandler {
+	return &MetricHandler{
+		environments: environmentStore,
+		templates:    templateStore,
+		pricing:      pricingTable,
+		janitor:      janitor,
+		provisioners: provisioners,
+	}
+}
+
+// metricsQuery is the parsed date range and grouping of a metrics request
+type metricsQuery struct {
+	from    time.Time
+	to      time.Time
+	groupBy string
+}
+
+// environmentUsage is one environment's footprint within the date range
+type environmentUsage struct {
+	environment  models.Environment
+	hours        float64
+	nodes        int
+	instanceType string
+}
+
+// GetUsageMetrics reports environment counts, environment and node hours,
+// and allocated resource limits over a date range
+func (h *MetricHandler) GetUsageMetrics(w http.ResponseWriter, r *http.Request) {
+	query, ok := parseMetricsQuery(w, r)
+	if !ok {
+		return
+	}
+
+	usages, ok := h.collectUsage(w, r, query)
+	if !ok {
+		return
+	}
+
+	groups := make(map[string]*models.UsageGroup)
+	total := models.UsageGroup{Key: "total"}
+	for _, usage := range usages {
+		key := groupKey(usage.environment, query.groupBy)
+		group, ok := groups[key]
+		if !ok {
+			group = &models.UsageGroup{Key: key}
+			groups[key] = group
+		}
+
+		for _, g := range []*models.UsageGroup{group, &total} {
+			g.EnvironmentCount++
+			g.EnvironmentHours += usage.hours
+			g.NodeHours += usage.hours * float64(usage.nodes)
+			addAllocation(g, usage.environment.ResourceLimits)
+		}
+	}
+
+	report := models.UsageReport{
+		From:    query.from,
+		To:      query.to,
+		GroupBy: query.groupBy,
+		Groups:  []models.UsageGroup{},
+		Total:   roundUsage(total),
+	}
+	for _, group := range groups {
+		report.Groups = append(report.Groups, roundUsage(*group))
+	}
+	sort.Slice(report.Groups, func(i, j int) bool {
+		return report.Groups[i].Key < report.Groups[j].Key
+	})
+
+	// Return report
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// GetCostMetrics estimates the cost of environments over a date range from
+// their node hours and the instance-type pricing table
+func (h *MetricHandler) GetCostMetrics(w http.ResponseWriter, r *http.Request) {
+	query, ok := parseMetricsQuery(w, r)
+	if !ok {
+		return
+	}
+
+	usages, ok := h.collectUsage(w, r, query)
+	if !ok {
+		return
+	}
+
+	groups := make(map[string]*models.CostGroup)
+	total := models.CostGroup{Key: "total", ByInstanceType: map[string]float64{}}
+	unpriced := make(map[string]bool)
+	for _, usage := range usages {
+		key := groupKey(usage.environment, query.groupBy)
+		group, ok := groups[key]
+		if !ok {
+			group = &models.CostGroup{Key: key, ByInstanceType: map[string]float64{}}
+			groups[key] = group
+		}
+
+		nodeHours := usage.hours * float64(usage.nodes)
+		hourly, known := h.pricing.InstanceHourly(usage.instanceType)
+		if !known && nodeHours > 0 {
+			unpriced[usage.instanceType] = true
+		}
+		clusterCost := usage.hours * h.pricing.ClusterHourly
+		nodeCost := nodeHours * hourly
+
+		for _, g := range []*models.CostGroup{group, &total} {
+			g.ClusterHours += usage.hours
+			g.NodeHours += nodeHours
+			g.ClusterCost += clusterCost
+			g.NodeCost += nodeCost
+			g.Cost += clusterCost + nodeCost
+			if usage.instanceType != "" {
+				g.ByInstanceType[usage.instanceType] += nodeCost
+			}
+		}
+	}
+
+	report := models.CostReport{
+		From:     query.from,
+		To:       query.to,
+		GroupBy:  query.groupBy,
+		Currency: h.pricing.Currency,
+		Groups:   []models.CostGroup{},
+		Total:    roundCost(total),
+	}
+	for _, group := range groups {
+		report.Groups = append(report.Groups, roundCost(*group))
+	}
+	sort.Slice(report.Groups, func(i, j int) bool {
+		return report.Groups[i].Key < report.Groups[j].Key
+	})
+	for instanceType := range unpriced {
+		report.UnpricedInstanceTypes = append(report.UnpricedInstanceTypes, instanceType)
+	}
+	sort.Strings(report.UnpricedInstanceTypes)
+
+	// Return report
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// GetWorkspaceMetrics reports the disk used by Terraform workspaces and local
+// state on the replica serving the request, for platform admins
+func (h *MetricHandler) GetWorkspaceMetrics(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+
+	report, err := h.janitor.Report(r.Context())
+	if err != nil {
+		log.Printf("Failed to measure workspaces: %v", err)
+		http.Error(w, "Failed to measure workspaces", http.StatusInternalServerError)
+		return
+	}
+
+	// Return report
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// GetPhaseMetrics reports how long each phase of each provisioning tool has
+// taken on the replica serving the request, and how often init was skipped,
+// for platform admins
+func (h *MetricHandler) GetPhaseMetrics(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+
+	timings := []terraform.PhaseTiming{}
+	for _, provisioner := range h.provisioners {
+		timings = append(timings, provisioner.PhaseTimings()...)
+	}
+	sort.Slice(timings, func(i, j int) bool {
+		if timings[i].Tool != timings[j].Tool {
+			return timings[i].Tool < timings[j].Tool
+		}
+		return timings[i].Phase < timings[j].Phase
+	})
+
+	// Return timings
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(timings)
+}
+
+// collectUsage returns the footprint within the query's date range of every
+// environment the caller may see, including deleted ones. It writes an error
+// response and returns false on failure.
+func (h *MetricHandler) collectUsage(w http.ResponseWriter, r *http.Request, query metricsQuery) ([]environmentUsage, bool) {
+	filter, ok := listFilter(w, r)
+	if !ok {
+		return nil, false
+	}
+	filter.IncludeDeleted = true
+
+	templates := make(map[string]*models.ClusterTemplate)
+	var usages []environmentUsage
+	page := store.PageRequest{Limit: store.MaxPageSize}
+	for {
+		environments, err := h.environments.List(r.Context(), filter, page)
+		if err != nil {
+			log.Printf("Failed to list environments: %v", err)
+			http.Error(w, "Failed to retrieve environments", http.StatusInternalServerError)
+			return nil, false
+		}
+
+		for _, environment := range environments.Items {
+			hours := activeHours(environment, query.from, query.to)
+			if hours <= 0 {
+				continue
+			}
+
+			// Templates are immutable per revision, so one lookup per
+			// revision is enough
+			key := environment.TemplateID + "@" + strconv.FormatInt(environment.TemplateRevision, 10)
+			template, seen := templates[key]
+			if !seen {
+				resolved, err := environmentTemplate(r.Context(), h.templates, environment)
+				if err != nil && !errors.Is(err, store.ErrNotFound) {
+					log.Printf("Failed to get template: %v", err)
+					http.Error(w, "Failed to retrieve template", http.StatusInternalServerError)
+					return nil, false
+				}
+				if err == nil {
+					template = &resolved
+				}
+				templates[key] = template
+			}
+
+			usage := environmentUsage{environment: environment, hours: hours}
+			if template != nil {
+				_, _, usage.nodes = nodeCounts(environment, *template)
+				if len(template.InstanceTypes) > 0 {
+					usage.instanceType = template.InstanceTypes[0]
+				}
+			}
+			usages = append(usages, usage)
+		}
+
+		if environments.NextCursor == "" {
+			break
+		}
+		page.Cursor = environments.NextCursor
+	}
+
+	return usages, true
+}
+
+// parseMetricsQuery reads the from, to and groupBy query parameters, writing
+// a 400 response and returning false if they are invalid
+func parseMetricsQuery(w http.ResponseWriter, r *http.Request) (metricsQuery, bool) {
+	queryParams := r.URL.Query()
+	query := metricsQuery{
+		to:      time.Now().UTC(),
+		groupBy: GroupByUser,
+	}
+
+	if to := queryParams.Get("to"); to != "" {
+		parsed, err := parseMetricsTime(to)
+		if err != nil {
+			http.Error(w, "to must be an RFC 3339 timestamp or a YYYY-MM-DD date", http.StatusBadRequest)
+			return query, false
+		}
+		query.to = parsed
+	}
+	query.from = query.to.Add(-defaultMetricsWindow)
+	if from := queryParams.Get("from"); from != "" {
+		parsed, err := parseMetricsTime(from)
+		if err != nil {
+			http.Error(w, "from must be an RFC 3339 timestamp or a YYYY-MM-DD date", http.StatusBadRequest)
+			return query, false
+		}
+		query.from = parsed
+	}
+	if !query.from.Before(query.to) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return query, false
+	}
+
+	switch groupBy := queryParams.Get("groupBy"); groupBy {
+	case "":
+	case GroupByUser, GroupByTeam, GroupByTemplate:
+		query.groupBy = groupBy
+	default:
+		http.Error(w, "groupBy must be one of user, team, template", http.StatusBadRequest)
+		return query, false
+	}
+
+	return query, true
+}
+
+// parseMetricsTime parses an RFC 3339 timestamp or a date, which stands for
+// midnight UTC at the start of that day
+func parseMetricsTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// activeHours returns how many hours of [from, to) the environment existed
+func activeHours(env models.Environment, from, to time.Time) float64 {
+	start := env.CreatedAt
+	if start.Before(from) {
+		start = from
+	}
+	end := to
+	if env.DeletedAt != nil && env.DeletedAt.Before(end) {
+		end = *env.DeletedAt
+	}
+	if now := time.Now().UTC(); now.Before(end) {
+		end = now
+	}
+	if !end.After(start) {
+		return 0
+	}
+	return end.Sub(start).Hours()
+}
+
+// groupKey returns the key an environment is reported under
+func groupKey(env models.Environment, groupBy string) string {
+	switch groupBy {
+	case GroupByTeam:
+		if env.TeamID == "" {
+			return unassignedGroup
+		}
+		return env.TeamID
+	case GroupByTemplate:
+		return env.TemplateID
+	default:
+		return env.UserID
+	}
+}
+
+// addAllocation adds an environment's resource limits to a usage group.
+// Limits that cannot be parsed are left out.
+func addAllocation(group *models.UsageGroup, limits models.ResourceLimits) {
+	const gib = 1 << 30
+	if cpu, err := parseQuantity(limits.CPU); err == nil {
+		group.CPUCores += cpu
+	}
+	if memory, err := parseQuantity(limits.Memory); err == nil {
+		group.MemoryGiB += memory / gib
+	}
+	if storage, err := parseQuantity(limits.Storage); err == nil {
+		group.StorageGiB += storage / gib
+	}
+}
+
+// roundUsage rounds the figures of a usage group to two decimals
+func roundUsage(group models.UsageGroup) models.UsageGroup {
+	group.EnvironmentHours = round2(group.EnvironmentHours)
+	group.NodeHours = round2(group.NodeHours)
+	group.CPUCores = round2(group.CPUCores)
+	group.MemoryGiB = round2(group.MemoryGiB)
+	group.StorageGiB = round2(group.StorageGiB)
+	return group
+}
+
+// roundCost rounds the figures of a cost group to two decimals
+func roundCost(group models.CostGroup) models.CostGroup {
+	group.ClusterHours = round2(group.ClusterHours)
+	group.NodeHours = round2(group.NodeHours)
+	group.ClusterCost = round2(group.ClusterCost)
+	group.NodeCost = round2(group.NodeCost)
+	group.Cost = round2(group.Cost)
+	byInstanceType := make(map[string]float64, len(group.ByInstanceType))
+	for instanceType, cost := range group.ByInstanceType {
+		byInstanceType[instanceType] = round2(cost)
+	}
+	group.ByInstanceType = byInstanceType
+	return group
+}
+
+// round2 rounds to two decimal places
+func round2(value float64) float64 {
+	return math.Round(value*100) / 100
+}
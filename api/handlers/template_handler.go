@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/store"
+)
+
+// TemplateHandler handles cluster template requests. Any authenticated user
+// can read templates; only platform admins can create revisions or delete them.
+type TemplateHandler struct {
+	store    store.TemplateStore
+	validate *validator.Validate
+}
+
+// NewTemplateHandler creates a new template handler
+func NewTemplateHandler(templateStore store.TemplateStore, validate *validator.Validate) *TemplateHandler {
+	return &TemplateHandler{
+		store:    templateStore,
+		validate: validate,
+	}
+}
+
+// ListTemplates returns all cluster templates
+func (h *TemplateHandler) ListTemplates(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requirePrincipal(w, r); !ok {
+		return
+	}
+
+	templates, err := h.store.List(r.Context())
+	if err != nil {
+		log.Printf("Failed to list templates: %v", err)
+		http.Error(w, "Failed to retrieve templates", http.StatusInternalServerError)
+		return
+	}
+
+	// Return templates
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(templates)
+}
+
+// CreateTemplate creates a new cluster template
+func (h *TemplateHandler) CreateTemplate(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+
+	// Parse request
+	var templateRequest models.TemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&templateRequest); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	if err := h.validate.Struct(templateRequest); err != nil {
+		http.Error(w, "Validation error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := templateRequest.Timeouts.Durations(); err != nil {
+		http.Error(w, "Validation error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	template := models.ClusterTemplate{
+		ID:                uuid.New().String(),
+		Name:              templateRequest.Name,
+		Description:       templateRequest.Description,
+		Region:            templateRequest.Region,
+		InstanceTypes:     templateRequest.InstanceTypes,
+		MinNodes:          templateRequest.MinNodes,
+		MaxNodes:          templateRequest.MaxNodes,
+		DesiredNodes:      templateRequest.DesiredNodes,
+		KubernetesVersion: templateRequest.KubernetesVersion,
+		VPCCIDR:           templateRequest.VPCCIDR,
+		AllowedAddons:     templateRequest.AllowedAddons,
+		ResourceLimits:    templateRequest.ResourceLimits,
+		Timeouts:          templateRequest.Timeouts,
+		Provisioner:       templateRequest.Provisioner,
+		CreatedAt:         time.Now().UTC(),
+		UpdatedAt:         time.Now().UTC(),
+	}
+
+	if err := h.store.Create(r.Context(), &template); err != nil {
+		log.Printf("Failed to save template: %v", err)
+		http.Error(w, "Failed to save template", http.StatusInternalServerError)
+		return
+	}
+
+	// Return the created template
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(template.Revision))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(template)
+}
+
+// GetTemplate returns a specific cluster template
+func (h *TemplateHandler) GetTemplate(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requirePrincipal(w, r); !ok {
+		return
+	}
+
+	template, ok := h.loadTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	// Return template
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(template.Revision))
+	json.NewEncoder(w).Encode(template)
+}
+
+// UpdateTemplate creates a new revision of a cluster template. Existing
+// environments stay on their revision until they are upgraded.
+func (h *TemplateHandler) UpdateTemplate(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+
+	// Parse request
+	var templatePatch models.TemplatePatch
+	if err := json.NewDecoder(r.Body).Decode(&templatePatch); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	if err := h.validate.Struct(templatePatch); err != nil {
+		http.Error(w, "Validation error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if templatePatch.Timeouts != nil {
+		if _, err := templatePatch.Timeouts.Durations(); err != nil {
+			http.Error(w, "Validation error: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	template, ok := h.loadTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	// Reject the patch if the caller's copy is stale
+	if !ifMatch(r, versionETag(template.Revision)) {
+		http.Error(w, "Template has been modified", http.StatusPreconditionFailed)
+		return
+	}
+
+	// Apply updates
+	if templatePatch.Description != nil {
+		template.Description = *templatePatch.Description
+	}
+	if templatePatch.InstanceTypes != nil {
+		template.InstanceTypes = templatePatch.InstanceTypes
+	}
+	if templatePatch.MinNodes != nil {
+		template.MinNodes = *templatePatch.MinNodes
+	}
+	if templatePatch.MaxNodes != nil {
+		template.MaxNodes = *templatePatch.MaxNodes
+	}
+	if templatePatch.DesiredNodes != nil {
+		template.DesiredNodes = *templatePatch.DesiredNodes
+	}
+	if templatePatch.KubernetesVersion != nil {
+		template.KubernetesVersion = *templatePatch.KubernetesVersion
+	}
+	if templatePatch.AllowedAddons != nil {
+		template.AllowedAddons = templatePatch.AllowedAddons
+	}
+	if templatePatch.ResourceLimits != nil {
+		template.ResourceLimits = *templatePatch.ResourceLimits
+	}
+	if templatePatch.Timeouts != nil {
+		template.Timeouts = *templatePatch.Timeouts
+	}
+	if templatePatch.Provisioner != nil {
+		template.Provisioner = *templatePatch.Provisioner
+	}
+
+	// The patched node bounds must still be consistent
+	if template.MinNodes > template.MaxNodes || template.DesiredNodes < template.MinNodes || template.DesiredNodes > template.MaxNodes {
+		http.Error(w, "Validation error: node counts must satisfy minNodes <= desiredNodes <= maxNodes", http.StatusBadRequest)
+		return
+	}
+
+	template.UpdatedAt = time.Now().UTC()
+
+	// Save as a new revision
+	err := h.store.CreateRevision(r.Context(), &template)
+	if errors.Is(err, store.ErrVersionConflict) {
+		http.Error(w, "Template has been modified", http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save template: %v", err)
+		http.Error(w, "Failed to save template", http.StatusInternalServerError)
+		return
+	}
+
+	// Return updated template
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(template.Revision))
+	json.NewEncoder(w).Encode(template)
+}
+
+// DeleteTemplate deletes a cluster template. Existing environments keep
+// resolving it; new environments can no longer be created from it.
+func (h *TemplateHandler) DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+
+	template, ok := h.loadTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	// Reject the delete if the caller's copy is stale
+	if !ifMatch(r, versionETag(template.Revision)) {
+		http.Error(w, "Template has been modified", http.StatusPreconditionFailed)
+		return
+	}
+
+	err := h.store.SoftDelete(r.Context(), &template)
+	if errors.Is(err, store.ErrVersionConflict) {
+		http.Error(w, "Template has been modified", http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete template: %v", err)
+		http.Error(w, "Failed to delete template", http.StatusInternalServerError)
+		return
+	}
+
+	// Return success
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListTemplateRevisions returns every revision of a cluster template
+func (h *TemplateHandler) ListTemplateRevisions(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requirePrincipal(w, r); !ok {
+		return
+	}
+
+	revisions, err := h.store.ListRevisions(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to list template revisions: %v", err)
+		http.Error(w, "Failed to retrieve template revisions", http.StatusInternalServerError)
+		return
+	}
+
+	// Return revisions
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revisions)
+}
+
+// GetTemplateRevision returns one revision of a cluster template
+func (h *TemplateHandler) GetTemplateRevision(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requirePrincipal(w, r); !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	revision, err := strconv.ParseInt(vars["revision"], 10, 64)
+	if err != nil || revision < 1 {
+		http.Error(w, "Invalid revision", http.StatusBadRequest)
+		return
+	}
+
+	template, err := h.store.GetRevision(r.Context(), vars["id"], revision)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Template revision not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get template revision: %v", err)
+		http.Error(w, "Failed to retrieve template revision", http.StatusInternalServerError)
+		return
+	}
+
+	// Return revision
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(template.Revision))
+	json.NewEncoder(w).Encode(template)
+}
+
+// loadTemplate fetches the template named by the {id} path variable, writing
+// a 404 or 500 response and returning false if it is unavailable
+func (h *TemplateHandler) loadTemplate(w http.ResponseWriter, r *http.Request) (models.ClusterTemplate, bool) {
+	templateID := mux.Vars(r)["id"]
+
+	template, err := h.store.Get(r.Context(), templateID)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && template.DeletedAt != nil) {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return template, false
+	}
+	if err != nil {
+		log.Printf("Failed to get template: %v", err)
+		http.Error(w, "Failed to retrieve template", http.StatusInternalServerError)
+		return template, false
+	}
+
+	return template, true
+}
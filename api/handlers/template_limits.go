@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+)
+
+// quantitySuffixes maps Kubernetes quantity suffixes to their multipliers,
+// binary suffixes first so that "Mi" is not read as "M"
+var quantitySuffixes = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40}, {"Pi", 1 << 50},
+	{"m", 1e-3}, {"k", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12}, {"P", 1e15},
+}
+
+// parseQuantity parses a Kubernetes resource quantity such as "500m", "2" or
+// "4Gi" into a plain number
+func parseQuantity(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	multiplier := 1.0
+	for _, s := range quantitySuffixes {
+		if strings.HasSuffix(value, s.suffix) {
+			value = strings.TrimSuffix(value, s.suffix)
+			multiplier = s.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid quantity %q", value)
+	}
+	return n * multiplier, nil
+}
+
+// checkTemplateLimits verifies that requested resource limits stay within the
+// template's ceilings and that every addon is allowed by the template
+func checkTemplateLimits(template models.ClusterTemplate, limits models.ResourceLimits, addons []string) error {
+	quantities := []struct {
+		name, requested, ceiling string
+	}{
+		{"cpu", limits.CPU, template.ResourceLimits.CPU},
+		{"memory", limits.Memory, template.ResourceLimits.Memory},
+		{"storage", limits.Storage, template.ResourceLimits.Storage},
+	}
+	for _, q := range quantities {
+		if q.ceiling == "" {
+			continue
+		}
+		requested, err := parseQuantity(q.requested)
+		if err != nil {
+			return fmt.Errorf("resourceLimits.%s: %w", q.name, err)
+		}
+		ceiling, err := parseQuantity(q.ceiling)
+		if err != nil {
+			return fmt.Errorf("template %s has an invalid %s limit: %w", template.ID, q.name, err)
+		}
+		if requested > ceiling {
+			return fmt.Errorf("resourceLimits.%s %s exceeds the template limit of %s", q.name, q.requested, q.ceiling)
+		}
+	}
+
+	counts := []struct {
+		name               string
+		requested, ceiling int
+	}{
+		{"maxNodeCount", limits.MaxNodeCount, template.MaxNodes},
+		{"maxNamespaces", limits.MaxNamespaces, template.ResourceLimits.MaxNamespaces},
+		{"maxLoadBalancers", limits.MaxLoadBalancers, template.ResourceLimits.MaxLoadBalancers},
+	}
+	for _, c := range counts {
+		if c.ceiling > 0 && c.requested > c.ceiling {
+			return fmt.Errorf("resourceLimits.%s %d exceeds the template limit of %d", c.name, c.requested, c.ceiling)
+		}
+	}
+
+	allowed := make(map[string]bool, len(template.AllowedAddons))
+	for _, addon := range template.AllowedAddons {
+		allowed[addon] = true
+	}
+	for _, addon := range addons {
+		if !allowed[addon] {
+			return fmt.Errorf("addon %q is not allowed by template %s", addon, template.Name)
+		}
+	}
+
+	return nil
+}
+
+// nodeCounts returns the minimum, maximum and desired node counts of an
+// environment's cluster. The environment may ask for fewer nodes than its
+// template allows.
+func nodeCounts(env models.Environment, template models.ClusterTemplate) (minNodes, maxNodes, desiredNodes int) {
+	maxNodes = template.MaxNodes
+	if env.ResourceLimits.MaxNodeCount > 0 && env.ResourceLimits.MaxNodeCount < maxNodes {
+		maxNodes = env.ResourceLimits.MaxNodeCount
+	}
+	minNodes = template.MinNodes
+	if minNodes > maxNodes {
+		minNodes = maxNodes
+	}
+	desiredNodes = template.DesiredNodes
+	if desiredNodes > maxNodes {
+		desiredNodes = maxNodes
+	}
+	if desiredNodes < minNodes {
+		desiredNodes = minNodes
+	}
+	return minNodes, maxNodes, desiredNodes
+}
+
+// terraformVars builds the Terraform variables for an environment from the
+// template it was created from
+func terraformVars(env models.Environment, template models.ClusterTemplate) map[string]interface{} {
+	minNodes, maxNodes, desiredNodes := nodeCounts(env, template)
+
+	return map[string]interface{}{
+		"cluster_name":       env.ClusterName,
+		"aws_region":         template.Region,
+		"environment":        "dev",
+		"instance_types":     template.InstanceTypes,
+		"min_nodes":          minNodes,
+		"max_nodes":          maxNodes,
+		"desired_nodes":      desiredNodes,
+		"kubernetes_version": template.KubernetesVersion,
+		"vpc_cidr":           template.VPCCIDR,
+		"resource_limits":    env.ResourceLimits,
+		"network_policy":     env.NetworkPolicy,
+		"service_mesh":       env.ServiceMesh,
+		"monitoring":         env.Monitoring,
+		"gitops":             env.GitOps,
+		"addons":             env.Addons,
+		"tags":               env.Tags,
+	}
+}
@@ -0,0 +1,659 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/store"
+)
+
+// errLastMaintainer is returned when a change would leave a team without a
+// maintainer
+var errLastMaintainer = errors.New("a team must keep at least one maintainer")
+
+// UserHandler handles user, team and team membership requests
+type UserHandler struct {
+	users    store.UserStore
+	teams    store.TeamStore
+	validate *validator.Validate
+}
+
+// NewUserHandler creates a new user handler
+func NewUserHandler(userStore store.UserStore, teamStore store.TeamStore, validate *validator.Validate) *UserHandler {
+	return &UserHandler{
+		users:    userStore,
+		teams:    teamStore,
+		validate: validate,
+	}
+}
+
+// ListUsers returns all users
+func (h *UserHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requirePrincipal(w, r); !ok {
+		return
+	}
+
+	users, err := h.users.List(r.Context())
+	if err != nil {
+		log.Printf("Failed to list users: %v", err)
+		http.Error(w, "Failed to retrieve users", http.StatusInternalServerError)
+		return
+	}
+
+	// Return users
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// CreateUser registers a user. Users may register themselves; platform admins
+// may register anyone.
+func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	// Parse request
+	var userRequest models.UserRequest
+	if err := json.NewDecoder(r.Body).Decode(&userRequest); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	if err := h.validate.Struct(userRequest); err != nil {
+		http.Error(w, "Validation error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if userRequest.ID != principal.Subject && !principal.Admin {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	user := models.User{
+		ID:        userRequest.ID,
+		Email:     userRequest.Email,
+		Name:      userRequest.Name,
+		CreatedAt: time.Now().UTC(),
+		UpdatedAt: time.Now().UTC(),
+	}
+
+	err := h.users.Create(r.Context(), &user)
+	if errors.Is(err, store.ErrVersionConflict) {
+		http.Error(w, "User already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save user: %v", err)
+		http.Error(w, "Failed to save user", http.StatusInternalServerError)
+		return
+	}
+
+	// Return the created user
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(user.Version))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
+
+// GetUser returns a specific user
+func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requirePrincipal(w, r); !ok {
+		return
+	}
+
+	user, ok := h.loadUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Return user
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(user.Version))
+	json.NewEncoder(w).Encode(user)
+}
+
+// UpdateUser updates a user's profile. Users may update themselves; platform
+// admins may update anyone.
+func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	// Parse request
+	var userPatch models.UserPatch
+	if err := json.NewDecoder(r.Body).Decode(&userPatch); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	if err := h.validate.Struct(userPatch); err != nil {
+		http.Error(w, "Validation error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, ok := h.loadUser(w, r)
+	if !ok {
+		return
+	}
+
+	if user.ID != principal.Subject && !principal.Admin {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Reject the patch if the caller's copy is stale
+	if !ifMatch(r, versionETag(user.Version)) {
+		http.Error(w, "User has been modified", http.StatusPreconditionFailed)
+		return
+	}
+
+	// Apply updates
+	if userPatch.Email != nil {
+		user.Email = *userPatch.Email
+	}
+	if userPatch.Name != nil {
+		user.Name = *userPatch.Name
+	}
+	user.UpdatedAt = time.Now().UTC()
+
+	// Save updated user
+	err := h.users.Update(r.Context(), &user)
+	if errors.Is(err, store.ErrVersionConflict) {
+		http.Error(w, "User has been modified", http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save user: %v", err)
+		http.Error(w, "Failed to save user", http.StatusInternalServerError)
+		return
+	}
+
+	// Return updated user
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(user.Version))
+	json.NewEncoder(w).Encode(user)
+}
+
+// DeleteUser deletes a user and removes them from their teams. Environments
+// owned by those teams stay with the teams.
+func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+
+	user, ok := h.loadUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Reject the delete if the caller's copy is stale
+	if !ifMatch(r, versionETag(user.Version)) {
+		http.Error(w, "User has been modified", http.StatusPreconditionFailed)
+		return
+	}
+
+	teams, err := h.teams.ListForUser(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("Failed to list teams: %v", err)
+		http.Error(w, "Failed to retrieve teams", http.StatusInternalServerError)
+		return
+	}
+	for _, team := range teams {
+		err := h.mutateTeam(r.Context(), team.ID, func(team *models.Team) error {
+			removeMember(team, user.ID)
+			return nil
+		})
+		if err != nil {
+			log.Printf("Failed to remove user %s from team %s: %v", user.ID, team.ID, err)
+			http.Error(w, "Failed to remove user from teams", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = h.users.SoftDelete(r.Context(), &user)
+	if errors.Is(err, store.ErrVersionConflict) {
+		http.Error(w, "User has been modified", http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete user: %v", err)
+		http.Error(w, "Failed to delete user", http.StatusInternalServerError)
+		return
+	}
+
+	// Return success
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListTeams returns every team to platform admins and the caller's own teams
+// to everyone else
+func (h *UserHandler) ListTeams(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	var teams []models.Team
+	var err error
+	if principal.Admin {
+		teams, err = h.teams.List(r.Context())
+	} else {
+		teams, err = h.teams.ListForUser(r.Context(), principal.Subject)
+	}
+	if err != nil {
+		log.Printf("Failed to list teams: %v", err)
+		http.Error(w, "Failed to retrieve teams", http.StatusInternalServerError)
+		return
+	}
+
+	// Return teams
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(teams)
+}
+
+// CreateTeam creates a team with the caller as its first maintainer
+func (h *UserHandler) CreateTeam(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	// Parse request
+	var teamRequest models.TeamRequest
+	if err := json.NewDecoder(r.Body).Decode(&teamRequest); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	if err := h.validate.Struct(teamRequest); err != nil {
+		http.Error(w, "Validation error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	team := models.Team{
+		ID:          uuid.New().String(),
+		Name:        teamRequest.Name,
+		Description: teamRequest.Description,
+		Members:     []models.TeamMember{{UserID: principal.Subject, Role: models.TeamRoleMaintainer}},
+		CreatedAt:   time.Now().UTC(),
+		UpdatedAt:   time.Now().UTC(),
+	}
+	for _, member := range teamRequest.Members {
+		// The creator stays a maintainer whatever the request says
+		if member.UserID == principal.Subject {
+			continue
+		}
+		if !h.userExists(w, r, member.UserID) {
+			return
+		}
+		setMember(&team, member.UserID, member.Role)
+	}
+
+	if err := h.teams.Create(r.Context(), &team); err != nil {
+		log.Printf("Failed to save team: %v", err)
+		http.Error(w, "Failed to save team", http.StatusInternalServerError)
+		return
+	}
+
+	// Return the created team
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(team.Version))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(team)
+}
+
+// GetTeam returns a specific team to its members and platform admins
+func (h *UserHandler) GetTeam(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	team, ok := h.loadTeam(w, r)
+	if !ok {
+		return
+	}
+
+	if !principal.Admin && team.Role(principal.Subject) == "" {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Return team
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(team.Version))
+	json.NewEncoder(w).Encode(team)
+}
+
+// UpdateTeam updates a team's name and description
+func (h *UserHandler) UpdateTeam(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	// Parse request
+	var teamPatch models.TeamPatch
+	if err := json.NewDecoder(r.Body).Decode(&teamPatch); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	if err := h.validate.Struct(teamPatch); err != nil {
+		http.Error(w, "Validation error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	team, ok := h.loadTeam(w, r)
+	if !ok {
+		return
+	}
+
+	if !canManageTeam(principal, team.ID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Reject the patch if the caller's copy is stale
+	if !ifMatch(r, versionETag(team.Version)) {
+		http.Error(w, "Team has been modified", http.StatusPreconditionFailed)
+		return
+	}
+
+	// Apply updates
+	if teamPatch.Name != nil {
+		team.Name = *teamPatch.Name
+	}
+	if teamPatch.Description != nil {
+		team.Description = *teamPatch.Description
+	}
+	team.UpdatedAt = time.Now().UTC()
+
+	// Save updated team
+	err := h.teams.Update(r.Context(), &team)
+	if errors.Is(err, store.ErrVersionConflict) {
+		http.Error(w, "Team has been modified", http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save team: %v", err)
+		http.Error(w, "Failed to save team", http.StatusInternalServerError)
+		return
+	}
+
+	// Return updated team
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(team.Version))
+	json.NewEncoder(w).Encode(team)
+}
+
+// DeleteTeam deletes a team. Its environments remain accessible to their
+// creators and platform admins.
+func (h *UserHandler) DeleteTeam(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	team, ok := h.loadTeam(w, r)
+	if !ok {
+		return
+	}
+
+	if !canManageTeam(principal, team.ID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Reject the delete if the caller's copy is stale
+	if !ifMatch(r, versionETag(team.Version)) {
+		http.Error(w, "Team has been modified", http.StatusPreconditionFailed)
+		return
+	}
+
+	err := h.teams.SoftDelete(r.Context(), &team)
+	if errors.Is(err, store.ErrVersionConflict) {
+		http.Error(w, "Team has been modified", http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete team: %v", err)
+		http.Error(w, "Failed to delete team", http.StatusInternalServerError)
+		return
+	}
+
+	// Return success
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetTeamMember adds a user to a team or changes their role
+func (h *UserHandler) SetTeamMember(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	// Parse request
+	var membershipRequest models.MembershipRequest
+	if err := json.NewDecoder(r.Body).Decode(&membershipRequest); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	if err := h.validate.Struct(membershipRequest); err != nil {
+		http.Error(w, "Validation error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	team, ok := h.loadTeam(w, r)
+	if !ok {
+		return
+	}
+
+	if !canManageTeam(principal, team.ID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	userID := mux.Vars(r)["userId"]
+	if !h.userExists(w, r, userID) {
+		return
+	}
+
+	err := h.mutateTeam(r.Context(), team.ID, func(team *models.Team) error {
+		// Demoting the last maintainer would leave the team unmanaged
+		if membershipRequest.Role != models.TeamRoleMaintainer && team.Role(userID) == models.TeamRoleMaintainer && maintainerCount(*team) == 1 {
+			return errLastMaintainer
+		}
+		setMember(team, userID, membershipRequest.Role)
+		return nil
+	})
+	h.writeTeamMutation(w, r, team.ID, err)
+}
+
+// RemoveTeamMember removes a user from a team. Maintainers and platform
+// admins may remove anyone; members may remove themselves.
+func (h *UserHandler) RemoveTeamMember(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	team, ok := h.loadTeam(w, r)
+	if !ok {
+		return
+	}
+
+	userID := mux.Vars(r)["userId"]
+	if userID != principal.Subject && !canManageTeam(principal, team.ID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if team.Role(userID) == "" {
+		http.Error(w, "User is not a member of the team", http.StatusNotFound)
+		return
+	}
+
+	err := h.mutateTeam(r.Context(), team.ID, func(team *models.Team) error {
+		if team.Role(userID) == models.TeamRoleMaintainer && maintainerCount(*team) == 1 {
+			return errLastMaintainer
+		}
+		removeMember(team, userID)
+		return nil
+	})
+	h.writeTeamMutation(w, r, team.ID, err)
+}
+
+// loadUser fetches the user named by the {id} path variable, writing a 404 or
+// 500 response and returning false if it is unavailable
+func (h *UserHandler) loadUser(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	userID := mux.Vars(r)["id"]
+
+	user, err := h.users.Get(r.Context(), userID)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && user.DeletedAt != nil) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return user, false
+	}
+	if err != nil {
+		log.Printf("Failed to get user: %v", err)
+		http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
+		return user, false
+	}
+
+	return user, true
+}
+
+// loadTeam fetches the team named by the {id} path variable, writing a 404 or
+// 500 response and returning false if it is unavailable
+func (h *UserHandler) loadTeam(w http.ResponseWriter, r *http.Request) (models.Team, bool) {
+	teamID := mux.Vars(r)["id"]
+
+	team, err := h.teams.Get(r.Context(), teamID)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && team.DeletedAt != nil) {
+		http.Error(w, "Team not found", http.StatusNotFound)
+		return team, false
+	}
+	if err != nil {
+		log.Printf("Failed to get team: %v", err)
+		http.Error(w, "Failed to retrieve team", http.StatusInternalServerError)
+		return team, false
+	}
+
+	return team, true
+}
+
+// userExists checks that a user is registered, writing a 400 or 500 response
+// and returning false if not
+func (h *UserHandler) userExists(w http.ResponseWriter, r *http.Request, userID string) bool {
+	user, err := h.users.Get(r.Context(), userID)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && user.DeletedAt != nil) {
+		http.Error(w, fmt.Sprintf("Validation error: unknown user %s", userID), http.StatusBadRequest)
+		return false
+	}
+	if err != nil {
+		log.Printf("Failed to get user: %v", err)
+		http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// mutateTeam applies fn to the latest stored copy of a team and saves it,
+// re-reading and retrying if a concurrent writer bumps the version
+func (h *UserHandler) mutateTeam(ctx context.Context, teamID string, fn func(*models.Team) error) error {
+	for attempt := 0; attempt < maxMutateAttempts; attempt++ {
+		team, err := h.teams.Get(ctx, teamID)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(&team); err != nil {
+			return err
+		}
+		team.UpdatedAt = time.Now().UTC()
+
+		err = h.teams.Update(ctx, &team)
+		if !errors.Is(err, store.ErrVersionConflict) {
+			return err
+		}
+	}
+	return store.ErrVersionConflict
+}
+
+// writeTeamMutation responds to a membership change with the updated team or
+// the error that prevented it
+func (h *UserHandler) writeTeamMutation(w http.ResponseWriter, r *http.Request, teamID string, err error) {
+	switch {
+	case errors.Is(err, errLastMaintainer):
+		http.Error(w, errLastMaintainer.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, store.ErrVersionConflict):
+		http.Error(w, "Team is being modified concurrently", http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Failed to save team: %v", err)
+		http.Error(w, "Failed to save team", http.StatusInternalServerError)
+		return
+	}
+
+	team, err := h.teams.Get(r.Context(), teamID)
+	if err != nil {
+		log.Printf("Failed to get team: %v", err)
+		http.Error(w, "Failed to retrieve team", http.StatusInternalServerError)
+		return
+	}
+
+	// Return updated team
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(team.Version))
+	json.NewEncoder(w).Encode(team)
+}
+
+// setMember adds the user to the team or changes their role
+func setMember(team *models.Team, userID, role string) {
+	for i := range team.Members {
+		if team.Members[i].UserID == userID {
+			team.Members[i].Role = role
+			return
+		}
+	}
+	team.Members = append(team.Members, models.TeamMember{UserID: userID, Role: role})
+}
+
+// removeMember removes the user from the team
+func removeMember(team *models.Team, userID string) {
+	members := team.Members[:0]
+	for _, member := range team.Members {
+		if member.UserID != userID {
+			members = append(members, member)
+		}
+	}
+	team.Members = members
+}
+
+// maintainerCount returns the number of maintainers in the team
+func maintainerCount(team models.Team) int {
+	count := 0
+	for _, member := range team.Members {
+		if member.Role == models.TeamRoleMaintainer {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,105 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/store"
+)
+
+// maxDriftScanInterval bounds how long an environment that is due for a drift
+// check waits to be noticed
+const maxDriftScanInterval = 5 * time.Minute
+
+// DriftScheduler queues a DETECT_DRIFT job for each ACTIVE environment that
+// has not been applied or checked for drift within its interval. The jobs are
+// queued on behalf of the system user, so fair scheduling keeps them from
+// holding up users' work.
+type DriftScheduler struct {
+	environments store.EnvironmentStore
+	queue        *Queue
+	interval     time.Duration
+}
+
+// NewDriftScheduler creates a scheduler that checks every active environment
+// once per interval
+func NewDriftScheduler(environmentStore store.EnvironmentStore, queue *Queue, interval time.Duration) *DriftScheduler {
+	return &DriftScheduler{
+		environments: environmentStore,
+		queue:        queue,
+		interval:     interval,
+	}
+}
+
+// Start scans for environments due for a check in the background until ctx
+// is cancelled
+func (s *DriftScheduler) Start(ctx context.Context) {
+	scanInterval := s.interval
+	if scanInterval > maxDriftScanInterval {
+		scanInterval = maxDriftScanInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(scanInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.scan(ctx); err != nil && ctx.Err() == nil {
+					log.Printf("Failed to schedule drift checks: %v", err)
+				}
+			}
+		}
+	}()
+	log.Printf("Checking active environments for drift every %s", s.interval)
+}
+
+// scan queues a check for every active environment that is due and has no
+// work waiting or running. Schedulers in several processes may race to queue
+// the same check; the duplicate is harmless.
+func (s *DriftScheduler) scan(ctx context.Context) error {
+	now := time.Now().UTC()
+	page := store.PageRequest{Limit: store.MaxPageSize}
+	for {
+		environments, err := s.environments.List(ctx, store.EnvironmentFilter{Status: models.StateActive}, page)
+		if err != nil {
+			return err
+		}
+
+		for _, environment := range environments.Items {
+			// An environment was last known to match its configuration
+			// when it was last applied or checked
+			lastChecked := environment.UpdatedAt
+			if condition, ok := environment.Condition(models.ConditionDrifted); ok && condition.LastCheckedAt.After(lastChecked) {
+				lastChecked = condition.LastCheckedAt
+			}
+			if now.Sub(lastChecked) < s.interval {
+				continue
+			}
+
+			active, err := s.queue.List(ctx, store.JobFilter{
+				EnvironmentID: environment.ID,
+				Statuses:      []string{models.JobStatusPending, models.JobStatusRunning},
+			})
+			if err != nil {
+				return err
+			}
+			if len(active) > 0 {
+				continue
+			}
+
+			if _, err := s.queue.Enqueue(ctx, environment.ID, models.ActorSystem, models.JobTypeDetectDrift); err != nil {
+				return err
+			}
+		}
+
+		if environments.NextCursor == "" {
+			return nil
+		}
+		page.Cursor = environments.NextCursor
+	}
+}
@@ -0,0 +1,203 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/store"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/terraform"
+)
+
+// RetentionPolicy says how long the janitor keeps files no environment needs
+// any more
+type RetentionPolicy struct {
+	// Deleted is how long the workspace and local state of a DELETED
+	// environment are kept after its deletion finished, to look into a
+	// destroy that went wrong
+	Deleted time.Duration
+
+	// Orphaned is how long a workspace whose environment is not in the
+	// store is kept after it last changed. Its local state is kept.
+	Orphaned time.Duration
+}
+
+// DefaultRetentionPolicy keeps the files of deleted environments for a day and
+// orphaned workspaces for a week
+var DefaultRetentionPolicy = RetentionPolicy{
+	Deleted:  24 * time.Hour,
+	Orphaned: 7 * 24 * time.Hour,
+}
+
+// Janitor reclaims the disk taken by Terraform workspaces on this replica and
+// reports what is left. Environments that are not DELETED keep their
+// workspace, providers, lock file and state; only their expired plans are
+// removed. Files are only touched under the environment's lock.
+type Janitor struct {
+	environments store.EnvironmentStore
+	locker       *Locker
+	workspaces   *terraform.Executor
+	retention    RetentionPolicy
+	interval     time.Duration
+
+	mu             sync.Mutex
+	report         models.WorkspaceReport
+	totalReclaimed int64
+}
+
+// NewJanitor creates a janitor that sweeps the workspaces of executor once
+// per interval. All executors share their workspaces, so any of them will do.
+func NewJanitor(environmentStore store.EnvironmentStore, locker *Locker, executor *terraform.Executor, retention RetentionPolicy, interval time.Duration) *Janitor {
+	return &Janitor{
+		environments: environmentStore,
+		locker:       locker,
+		workspaces:   executor,
+		retention:    retention,
+		interval:     interval,
+	}
+}
+
+// Start sweeps in the background until ctx is cancelled
+func (j *Janitor) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := j.Sweep(ctx); err != nil && ctx.Err() == nil {
+					log.Printf("Failed to sweep workspaces: %v", err)
+				}
+			}
+		}
+	}()
+	log.Printf("Sweeping workspaces every %s", j.interval)
+}
+
+// Sweep removes what the retention policy allows and measures what is left
+func (j *Janitor) Sweep(ctx context.Context) (models.WorkspaceReport, error) {
+	report, err := j.sweep(ctx, true)
+	if err != nil {
+		return report, err
+	}
+
+	j.mu.Lock()
+	j.totalReclaimed += report.ReclaimedBytes
+	report.TotalReclaimedBytes = j.totalReclaimed
+	j.report = report
+	j.mu.Unlock()
+
+	if report.ReclaimedBytes > 0 {
+		log.Printf("Reclaimed %d bytes from workspaces, removing %d; %d bytes in use",
+			report.ReclaimedBytes, report.RemovedWorkspaces, report.TotalBytes)
+	}
+	return report, nil
+}
+
+// Report returns the report of the last sweep, or measures the workspaces
+// without removing anything if there has been none
+func (j *Janitor) Report(ctx context.Context) (models.WorkspaceReport, error) {
+	j.mu.Lock()
+	report := j.report
+	j.mu.Unlock()
+	if !report.MeasuredAt.IsZero() {
+		return report, nil
+	}
+	return j.sweep(ctx, false)
+}
+
+// sweep measures every workspace and, if clean is set, reclaims what it can.
+// A workspace whose environment is locked is left for the next sweep.
+func (j *Janitor) sweep(ctx context.Context, clean bool) (models.WorkspaceReport, error) {
+	report := models.WorkspaceReport{Workspaces: []models.WorkspaceDiskUsage{}}
+	usage, err := j.workspaces.Usage()
+	if err != nil {
+		return report, err
+	}
+
+	now := time.Now().UTC()
+	for _, u := range usage {
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+
+		entry := models.WorkspaceDiskUsage{
+			EnvironmentID:  u.EnvironmentID,
+			WorkspaceBytes: u.WorkspaceBytes,
+			ProviderBytes:  u.ProviderBytes,
+			PlanBytes:      u.PlanBytes,
+			StateBytes:     u.StateBytes,
+			ModifiedAt:     u.ModifiedAt,
+		}
+
+		// Only a finished deletion lets the state go. An environment that
+		// failed or is still being deleted may have resources left.
+		remove, withState := false, false
+		environment, err := j.environments.Get(ctx, u.EnvironmentID)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			remove = u.WorkspaceBytes > 0 && now.Sub(u.ModifiedAt) >= j.retention.Orphaned
+		case err != nil:
+			return report, err
+		default:
+			entry.Status = environment.Status
+			if environment.Status == models.StateDeleted {
+				remove = now.Sub(environment.UpdatedAt) >= j.retention.Deleted
+				withState = true
+			}
+		}
+
+		if clean && (remove || u.PlanBytes > 0) {
+			freed, removed, err := j.clean(ctx, u.EnvironmentID, remove, withState)
+			if err != nil {
+				log.Printf("Failed to clean workspace of environment %s: %v", u.EnvironmentID, err)
+			}
+			report.ReclaimedBytes += freed
+			if removed {
+				// The local state of an orphaned workspace stays behind
+				report.RemovedWorkspaces++
+				if withState || entry.StateBytes == 0 {
+					continue
+				}
+				entry.WorkspaceBytes, entry.ProviderBytes, entry.PlanBytes = 0, 0, 0
+			} else {
+				entry.WorkspaceBytes -= freed
+				entry.PlanBytes -= freed
+			}
+		}
+
+		report.WorkspaceBytes += entry.WorkspaceBytes
+		report.StateBytes += entry.StateBytes
+		report.Workspaces = append(report.Workspaces, entry)
+	}
+
+	report.TotalBytes = report.WorkspaceBytes + report.StateBytes
+	report.MeasuredAt = now
+	return report, nil
+}
+
+// clean removes an environment's workspace, or only its expired plans, under
+// the environment's lock. It returns the bytes freed and whether the
+// workspace was removed.
+func (j *Janitor) clean(ctx context.Context, envID string, remove, withState bool) (int64, bool, error) {
+	lock, err := j.locker.Acquire(ctx, envID, models.LockOperationCleanup, "", models.ActorSystem)
+	if errors.Is(err, store.ErrLocked) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	defer j.locker.Release(lock)
+
+	if !remove {
+		freed, err := j.workspaces.PruneWorkspace(envID)
+		return freed, false, err
+	}
+	freed, err := j.workspaces.RemoveWorkspace(envID, withState)
+	return freed, err == nil, err
+}
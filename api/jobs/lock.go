@@ -0,0 +1,132 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/store"
+)
+
+// ErrLockBroken is the cause of an operation's context when the lock it holds
+// on its environment is broken by an admin or lost to another operation. It
+// wraps ErrCancelled, so the operation ends as if it had been cancelled.
+var ErrLockBroken = fmt.Errorf("%w: environment lock was broken", ErrCancelled)
+
+// Locker takes the lock of an environment on behalf of this process, so that
+// only one operation at a time, in any process, works on an environment
+type Locker struct {
+	store    store.LockStore
+	owner    string
+	duration time.Duration
+}
+
+// NewLocker creates a locker that takes locks as owner. A held lock expires
+// duration after its last heartbeat; heartbeats are sent at a third of it.
+func NewLocker(lockStore store.LockStore, owner string, duration time.Duration) *Locker {
+	if owner == "" {
+		owner = uuid.New().String()
+	}
+	if duration <= 0 {
+		duration = 2 * time.Minute
+	}
+
+	return &Locker{
+		store:    lockStore,
+		owner:    owner,
+		duration: duration,
+	}
+}
+
+// Acquire takes the lock of an environment for an operation, returning
+// store.ErrLocked if another operation holds it. The lock must be kept alive
+// with Hold.
+func (l *Locker) Acquire(ctx context.Context, envID, operation, jobID, userID string) (models.EnvironmentLock, error) {
+	now := time.Now().UTC()
+	lock := models.EnvironmentLock{
+		EnvironmentID: envID,
+		Token:         uuid.New().String(),
+		Owner:         l.owner,
+		Operation:     operation,
+		JobID:         jobID,
+		UserID:        userID,
+		AcquiredAt:    now,
+		HeartbeatAt:   now,
+		ExpiresAt:     now.Add(l.duration),
+	}
+
+	if err := l.store.Acquire(ctx, lock); err != nil {
+		return lock, err
+	}
+	return lock, nil
+}
+
+// Hold renews a lock until the returned function is called, which releases
+// it. If the lock is lost meanwhile, cancel is called with ErrLockBroken so
+// that the operation holding it stops.
+func (l *Locker) Hold(lock models.EnvironmentLock, cancel context.CancelCauseFunc) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		heartbeat := time.NewTicker(l.duration / 3)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-heartbeat.C:
+			}
+
+			now := time.Now().UTC()
+			renewed := lock
+			renewed.HeartbeatAt = now
+			renewed.ExpiresAt = now.Add(l.duration)
+			err := l.store.Renew(context.Background(), renewed)
+			if errors.Is(err, store.ErrLockLost) {
+				log.Printf("Lost the lock on environment %s; stopping %s", lock.EnvironmentID, lock.Operation)
+				cancel(ErrLockBroken)
+				return
+			}
+			if err != nil {
+				log.Printf("Failed to renew lock on environment %s: %v", lock.EnvironmentID, err)
+				continue
+			}
+			lock = renewed
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+		l.Release(lock)
+	}
+}
+
+// Release gives up a lock that is not being held, such as one acquired for a
+// job that could not be claimed after all
+func (l *Locker) Release(lock models.EnvironmentLock) {
+	if err := l.store.Release(context.Background(), lock.EnvironmentID, lock.Token); err != nil {
+		log.Printf("Failed to release lock on environment %s: %v", lock.EnvironmentID, err)
+	}
+}
+
+// Get returns the lock of an environment, expired or not
+func (l *Locker) Get(ctx context.Context, envID string) (models.EnvironmentLock, error) {
+	return l.store.Get(ctx, envID)
+}
+
+// Break removes the lock of an environment whoever holds it, returning the
+// lock that was broken. A holder that is still alive notices at its next
+// heartbeat and stops.
+func (l *Locker) Break(ctx context.Context, envID string) (models.EnvironmentLock, error) {
+	lock, err := l.store.Get(ctx, envID)
+	if err != nil {
+		return lock, err
+	}
+	return lock, l.store.Break(ctx, envID)
+}
@@ -0,0 +1,587 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/store"
+)
+
+// ErrCancelled is the cause of a running job's context when a user cancels
+// the job
+var ErrCancelled = errors.New("job cancelled")
+
+// ErrJobFinished is returned when cancelling a job that has already finished
+var ErrJobFinished = errors.New("job already finished")
+
+// maxCancelAttempts bounds the read-modify-write retries in Cancel
+const maxCancelAttempts = 5
+
+// Runner does the work of queued jobs
+type Runner interface {
+	// RunJob does the work of a job. A job whose worker died is run again
+	// from the start, so RunJob must be safe to repeat. When the job is
+	// cancelled, ctx is cancelled with the cause ErrCancelled, possibly
+	// before RunJob is called.
+	RunJob(ctx context.Context, job models.Job) error
+
+	// AbandonJob is called once for a job that is given up because its
+	// workers kept dying before it finished
+	AbandonJob(ctx context.Context, job models.Job)
+}
+
+// Config tunes a Queue
+type Config struct {
+	// WorkerID names this process in job leases. A stable ID such as the
+	// host name lets a restarted process reclaim its own jobs immediately
+	// instead of waiting for their leases to lapse.
+	WorkerID string
+
+	// Workers is the number of jobs run concurrently by this process
+	Workers int
+
+	// LeaseDuration is how long a claimed job stays reserved for its worker
+	// without a renewal. Workers renew at a third of it.
+	LeaseDuration time.Duration
+
+	// PollInterval is how often idle workers look for jobs enqueued by other
+	// processes
+	PollInterval time.Duration
+
+	// MaxRunning caps the jobs running at once across all processes sharing
+	// the job store. Zero leaves only the per-process Workers limit.
+	MaxRunning int
+
+	// MaxRunningPerUser caps the jobs of one user running at once across all
+	// processes. Zero means no per-user limit.
+	MaxRunningPerUser int
+
+	// MaxAttempts is how many times a job is claimed before it is abandoned
+	MaxAttempts int
+}
+
+// Queue is a persistent job queue. Jobs survive restarts in the job store,
+// and workers in any number of processes claim them through leases. A job
+// also holds the lock of its environment while it runs.
+type Queue struct {
+	store   store.JobStore
+	locker  *Locker
+	config  Config
+	wake    chan struct{}
+	claimMu sync.Mutex
+	workers sync.WaitGroup
+
+	// cancels interrupts the jobs running in this process, by job ID
+	cancelsMu sync.Mutex
+	cancels   map[string]context.CancelCauseFunc
+}
+
+// NewQueue creates a queue over the given job store whose jobs take their
+// environment's lock from locker, filling in defaults for unset configuration
+func NewQueue(jobStore store.JobStore, locker *Locker, config Config) *Queue {
+	if config.WorkerID == "" {
+		config.WorkerID = uuid.New().String()
+	}
+	if config.Workers <= 0 {
+		config.Workers = 4
+	}
+	if config.LeaseDuration <= 0 {
+		config.LeaseDuration = 2 * time.Minute
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = 5 * time.Second
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 3
+	}
+
+	return &Queue{
+		store:   jobStore,
+		locker:  locker,
+		config:  config,
+		wake:    make(chan struct{}, 1),
+		cancels: make(map[string]context.CancelCauseFunc),
+	}
+}
+
+// Enqueue stores a new pending job for an environment on behalf of a user and
+// wakes a worker
+func (q *Queue) Enqueue(ctx context.Context, envID, userID, jobType string) (models.Job, error) {
+	return q.Submit(ctx, models.Job{
+		EnvironmentID: envID,
+		UserID:        userID,
+		Type:          jobType,
+	})
+}
+
+// Submit stores job as a new pending job and wakes a worker. The caller sets
+// the job's environment, user, type and any type-specific fields; the queue
+// sets the rest.
+func (q *Queue) Submit(ctx context.Context, job models.Job) (models.Job, error) {
+	now := time.Now().UTC()
+	job.ID = uuid.New().String()
+	job.Status = models.JobStatusPending
+	job.MaxAttempts = q.config.MaxAttempts
+	job.CreatedAt = now
+	job.UpdatedAt = now
+
+	if err := q.store.Create(ctx, &job); err != nil {
+		return job, fmt.Errorf("failed to enqueue job: %w", err)
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// Get returns the job with the given ID
+func (q *Queue) Get(ctx context.Context, jobID string) (models.Job, error) {
+	return q.store.Get(ctx, jobID)
+}
+
+// List returns the jobs that match the filter, oldest first
+func (q *Queue) List(ctx context.Context, filter store.JobFilter) ([]models.Job, error) {
+	return q.store.List(ctx, filter)
+}
+
+// Cancel stops a job on behalf of a user. A pending job is cancelled right
+// away; a running job is marked for cancellation and interrupted by its
+// worker, in this process or, within a poll interval, in another one. It
+// returns ErrJobFinished if the job has already finished.
+func (q *Queue) Cancel(ctx context.Context, jobID, userID string) (models.Job, error) {
+	for attempt := 0; attempt < maxCancelAttempts; attempt++ {
+		job, err := q.store.Get(ctx, jobID)
+		if err != nil {
+			return job, err
+		}
+		if job.Finished() {
+			return job, ErrJobFinished
+		}
+
+		now := time.Now().UTC()
+		job.CancelledBy = userID
+		job.UpdatedAt = now
+		if job.Status == models.JobStatusPending {
+			job.Status = models.JobStatusCancelled
+			job.Error = "cancelled before it started"
+			job.FinishedAt = &now
+		} else if job.CancelRequestedAt == nil {
+			job.CancelRequestedAt = &now
+		}
+
+		// A conflict means a worker claimed or renewed the job meanwhile
+		err = q.store.Update(ctx, &job)
+		if errors.Is(err, store.ErrVersionConflict) {
+			continue
+		}
+		if err != nil {
+			return job, err
+		}
+
+		if job.Status == models.JobStatusRunning {
+			q.interrupt(job.ID)
+		}
+		return job, nil
+	}
+	return models.Job{}, store.ErrVersionConflict
+}
+
+// interrupt cancels a job running in this process, if it is
+func (q *Queue) interrupt(jobID string) {
+	q.cancelsMu.Lock()
+	defer q.cancelsMu.Unlock()
+	if cancel, ok := q.cancels[jobID]; ok {
+		cancel(ErrCancelled)
+	}
+}
+
+// Wait blocks until the workers stopped by cancelling Start's context have
+// returned, which includes interrupting the jobs they were running
+func (q *Queue) Wait() {
+	q.workers.Wait()
+}
+
+// Start recovers the jobs this worker held before a restart and starts the
+// workers, which run until ctx is cancelled
+func (q *Queue) Start(ctx context.Context, runner Runner) error {
+	running, err := q.store.List(ctx, store.JobFilter{Statuses: []string{models.JobStatusRunning}})
+	if err != nil {
+		return fmt.Errorf("failed to list running jobs: %w", err)
+	}
+
+	// Jobs still leased to this worker were interrupted by the restart
+	recovered := 0
+	for _, job := range running {
+		if job.LeaseOwner != q.config.WorkerID {
+			continue
+		}
+		if err := q.release(ctx, runner, job); err != nil {
+			log.Printf("Failed to recover job %s: %v", job.ID, err)
+			continue
+		}
+		recovered++
+	}
+	if recovered > 0 {
+		log.Printf("Recovered %d interrupted job(s)", recovered)
+	}
+
+	q.workers.Add(q.config.Workers)
+	for i := 0; i < q.config.Workers; i++ {
+		go func() {
+			defer q.workers.Done()
+			q.work(ctx, runner)
+		}()
+	}
+	log.Printf("Started %d job worker(s) as %s", q.config.Workers, q.config.WorkerID)
+	return nil
+}
+
+// work claims and runs jobs one at a time until ctx is cancelled
+func (q *Queue) work(ctx context.Context, runner Runner) {
+	for {
+		job, lock, ok, err := q.claim(ctx, runner)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to claim job: %v", err)
+		}
+		if ok {
+			q.run(ctx, runner, job, lock)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-time.After(q.config.PollInterval):
+		}
+	}
+}
+
+// claim leases the next job in fair order, skipping users at their limit and
+// environments locked by other operations, and claiming nothing while the
+// global limit is reached. The claimed job comes with its environment's lock.
+// Jobs that have used up their attempts are abandoned on the way.
+func (q *Queue) claim(ctx context.Context, runner Runner) (models.Job, models.EnvironmentLock, bool, error) {
+	// Claims within this process are serialized so that its workers see each
+	// other's claims when checking limits. Workers in other processes can
+	// still race past a limit by a job or two.
+	q.claimMu.Lock()
+	defer q.claimMu.Unlock()
+
+	snapshot, err := q.snapshot(ctx)
+	if err != nil {
+		return models.Job{}, models.EnvironmentLock{}, false, err
+	}
+
+	for _, job := range snapshot.exhausted {
+		if err := q.release(ctx, runner, job); err != nil && !errors.Is(err, store.ErrVersionConflict) {
+			log.Printf("Failed to abandon job %s: %v", job.ID, err)
+		}
+	}
+
+	if q.config.MaxRunning > 0 && snapshot.running >= q.config.MaxRunning {
+		return models.Job{}, models.EnvironmentLock{}, false, nil
+	}
+
+	now := time.Now().UTC()
+	for _, job := range schedule(snapshot.runnable, snapshot.runningByUser) {
+		if q.config.MaxRunningPerUser > 0 && snapshot.runningByUser[job.UserID] >= q.config.MaxRunningPerUser {
+			continue
+		}
+
+		// The jobs of one environment share its workspace, so they run one
+		// at a time in the order they were queued
+		if snapshot.busyEnvironments[job.EnvironmentID] || snapshot.nextByEnvironment[job.EnvironmentID] != job.ID {
+			continue
+		}
+
+		// The lock keeps out operations that do not go through the queue,
+		// such as plans, and work queued by other processes
+		lock, err := q.locker.Acquire(ctx, job.EnvironmentID, job.Type, job.ID, job.UserID)
+		if errors.Is(err, store.ErrLocked) {
+			continue
+		}
+		if err != nil {
+			return models.Job{}, models.EnvironmentLock{}, false, err
+		}
+		if job.Status == models.JobStatusRunning {
+			log.Printf("Taking over job %s from %s after its lease lapsed", job.ID, job.LeaseOwner)
+		}
+
+		leaseExpiresAt := now.Add(q.config.LeaseDuration)
+		job.Status = models.JobStatusRunning
+		job.LeaseOwner = q.config.WorkerID
+		job.LeaseExpiresAt = &leaseExpiresAt
+		job.Attempts++
+		job.UpdatedAt = now
+		if job.StartedAt == nil {
+			job.StartedAt = &now
+		}
+
+		// Losing the race means another worker claimed the job first
+		err = q.store.Update(ctx, &job)
+		if err != nil {
+			q.locker.Release(lock)
+		}
+		if errors.Is(err, store.ErrVersionConflict) {
+			continue
+		}
+		if err != nil {
+			return models.Job{}, models.EnvironmentLock{}, false, err
+		}
+		return job, lock, true, nil
+	}
+
+	return models.Job{}, models.EnvironmentLock{}, false, nil
+}
+
+// Position returns the 1-based place of a job in the order workers will claim
+// waiting jobs, or 0 if the job is not waiting
+func (q *Queue) Position(ctx context.Context, jobID string) (int, error) {
+	snapshot, err := q.snapshot(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, job := range schedule(snapshot.runnable, snapshot.runningByUser) {
+		if job.ID == jobID {
+			return i + 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// queueSnapshot is the state of the unfinished jobs at one point in time
+type queueSnapshot struct {
+	// runnable holds the pending jobs and the running jobs whose lease
+	// lapsed, oldest first
+	runnable []models.Job
+
+	// exhausted holds the running jobs whose lease lapsed on their last
+	// attempt
+	exhausted []models.Job
+
+	// running and runningByUser count the jobs held by a live worker
+	running       int
+	runningByUser map[string]int
+
+	// busyEnvironments holds the environments with a job held by a live
+	// worker, and nextByEnvironment the oldest runnable job of each
+	// environment
+	busyEnvironments  map[string]bool
+	nextByEnvironment map[string]string
+}
+
+// snapshot reads the unfinished jobs and sorts them by what can happen next
+func (q *Queue) snapshot(ctx context.Context) (queueSnapshot, error) {
+	unfinished, err := q.store.List(ctx, store.JobFilter{
+		Statuses: []string{models.JobStatusPending, models.JobStatusRunning},
+	})
+	if err != nil {
+		return queueSnapshot{}, err
+	}
+
+	snapshot := queueSnapshot{
+		runningByUser:     make(map[string]int),
+		busyEnvironments:  make(map[string]bool),
+		nextByEnvironment: make(map[string]string),
+	}
+	now := time.Now().UTC()
+	for _, job := range unfinished {
+		switch {
+		case job.Status == models.JobStatusPending:
+			snapshot.runnable = append(snapshot.runnable, job)
+		case job.LeaseExpiresAt != nil && job.LeaseExpiresAt.After(now):
+			snapshot.running++
+			snapshot.runningByUser[job.UserID]++
+			snapshot.busyEnvironments[job.EnvironmentID] = true
+		case job.Attempts >= job.MaxAttempts:
+			snapshot.exhausted = append(snapshot.exhausted, job)
+		default:
+			snapshot.runnable = append(snapshot.runnable, job)
+		}
+	}
+
+	for _, job := range snapshot.runnable {
+		if _, ok := snapshot.nextByEnvironment[job.EnvironmentID]; !ok {
+			snapshot.nextByEnvironment[job.EnvironmentID] = job.ID
+		}
+	}
+
+	return snapshot, nil
+}
+
+// run runs a claimed job, renewing its lease and its environment's lock and
+// watching for a cancel request until the runner returns, and records the
+// outcome
+func (q *Queue) run(ctx context.Context, runner Runner, job models.Job, lock models.EnvironmentLock) {
+	jobCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	q.cancelsMu.Lock()
+	q.cancels[job.ID] = cancel
+	q.cancelsMu.Unlock()
+	defer func() {
+		q.cancelsMu.Lock()
+		delete(q.cancels, job.ID)
+		q.cancelsMu.Unlock()
+	}()
+
+	// A job cancelled while its lease had lapsed is not started again
+	if job.CancelRequestedAt != nil {
+		cancel(ErrCancelled)
+	}
+
+	// The renewal goroutine and the final write both update the job, so they
+	// share the latest copy under a lock
+	var mu sync.Mutex
+	current := job
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		renew := time.NewTicker(q.config.LeaseDuration / 3)
+		defer renew.Stop()
+		check := time.NewTicker(q.config.PollInterval)
+		defer check.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-check.C:
+				// Cancel requests made through another process
+				latest, err := q.store.Get(ctx, job.ID)
+				if err == nil && latest.CancelRequestedAt != nil {
+					cancel(ErrCancelled)
+				}
+				continue
+			case <-renew.C:
+			}
+
+			mu.Lock()
+			leaseExpiresAt := time.Now().UTC().Add(q.config.LeaseDuration)
+			err := q.updateHeld(ctx, &current, func(renewed *models.Job) {
+				renewed.LeaseExpiresAt = &leaseExpiresAt
+			})
+			cancelRequested := current.CancelRequestedAt != nil
+			mu.Unlock()
+
+			if errors.Is(err, store.ErrVersionConflict) {
+				log.Printf("Lost the lease on job %s; stopping it", job.ID)
+				cancel(context.Canceled)
+				return
+			}
+			if err != nil {
+				log.Printf("Failed to renew lease on job %s: %v", job.ID, err)
+			}
+			if cancelRequested {
+				cancel(ErrCancelled)
+			}
+		}
+	}()
+
+	log.Printf("Running %s job %s for environment %s (attempt %d of %d)", job.Type, job.ID, job.EnvironmentID, job.Attempts, job.MaxAttempts)
+	releaseLock := q.locker.Hold(lock, cancel)
+	runErr := runner.RunJob(jobCtx, job)
+	close(done)
+	wg.Wait()
+	releaseLock()
+
+	// A job cut short by shutdown is left to be recovered on restart
+	if runErr != nil && ctx.Err() != nil {
+		log.Printf("Job %s interrupted by shutdown", job.ID)
+		return
+	}
+
+	now := time.Now().UTC()
+	err := q.updateHeld(context.Background(), &current, func(finished *models.Job) {
+		finished.Status = models.JobStatusSucceeded
+		finished.Error = ""
+		if runErr != nil {
+			finished.Status = models.JobStatusFailed
+			finished.Error = runErr.Error()
+		}
+		if runErr != nil && errors.Is(context.Cause(jobCtx), ErrCancelled) {
+			finished.Status = models.JobStatusCancelled
+		}
+		finished.LeaseOwner = ""
+		finished.LeaseExpiresAt = nil
+		finished.FinishedAt = &now
+	})
+	if errors.Is(err, store.ErrVersionConflict) {
+		log.Printf("Lost the lease on job %s before recording its outcome", job.ID)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to record outcome of job %s: %v", job.ID, err)
+		return
+	}
+	log.Printf("Job %s %s", job.ID, current.Status)
+}
+
+// updateHeld applies fn to a job this worker holds and saves it, updating
+// *job on success. If another writer, such as a cancel request, changed the
+// job meanwhile, fn is applied once more to the latest copy as long as this
+// worker still holds the lease; otherwise ErrVersionConflict is returned.
+func (q *Queue) updateHeld(ctx context.Context, job *models.Job, fn func(*models.Job)) error {
+	updated := *job
+	fn(&updated)
+	updated.UpdatedAt = time.Now().UTC()
+	err := q.store.Update(ctx, &updated)
+	if !errors.Is(err, store.ErrVersionConflict) {
+		if err == nil {
+			*job = updated
+		}
+		return err
+	}
+
+	latest, err := q.store.Get(ctx, job.ID)
+	if err != nil {
+		return err
+	}
+	if latest.Status != models.JobStatusRunning || latest.LeaseOwner != q.config.WorkerID || latest.Attempts != job.Attempts {
+		return store.ErrVersionConflict
+	}
+	fn(&latest)
+	latest.UpdatedAt = time.Now().UTC()
+	if err := q.store.Update(ctx, &latest); err != nil {
+		return err
+	}
+	*job = latest
+	return nil
+}
+
+// release hands back a job whose worker died: it returns to the queue if it
+// has attempts left and is abandoned otherwise
+func (q *Queue) release(ctx context.Context, runner Runner, job models.Job) error {
+	now := time.Now().UTC()
+	job.LeaseOwner = ""
+	job.LeaseExpiresAt = nil
+	job.UpdatedAt = now
+
+	if job.Attempts < job.MaxAttempts {
+		job.Status = models.JobStatusPending
+		return q.store.Update(ctx, &job)
+	}
+
+	job.Status = models.JobStatusAbandoned
+	job.Error = fmt.Sprintf("worker stopped before the job finished on all %d attempts", job.Attempts)
+	job.FinishedAt = &now
+	if err := q.store.Update(ctx, &job); err != nil {
+		return err
+	}
+
+	log.Printf("Abandoned job %s after %d attempts", job.ID, job.Attempts)
+	runner.AbandonJob(ctx, job)
+	return nil
+}
@@ -0,0 +1,58 @@
+package jobs
+
+import (
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+)
+
+// schedule orders waiting jobs fairly across users. Each turn goes to the
+// user with the fewest jobs running or already scheduled, and within a user to
+// the oldest job, so a burst of requests from one user is interleaved with
+// everyone else's instead of holding them up. candidates must be sorted
+// oldest first; running is not modified.
+func schedule(candidates []models.Job, running map[string]int) []models.Job {
+	queues := make(map[string][]models.Job)
+	var users []string
+	for _, job := range candidates {
+		if _, ok := queues[job.UserID]; !ok {
+			users = append(users, job.UserID)
+		}
+		queues[job.UserID] = append(queues[job.UserID], job)
+	}
+
+	load := make(map[string]int, len(users))
+	for _, user := range users {
+		load[user] = running[user]
+	}
+
+	ordered := make([]models.Job, 0, len(candidates))
+	for len(ordered) < len(candidates) {
+		next := -1
+		for i, user := range users {
+			if len(queues[user]) == 0 {
+				continue
+			}
+			if next < 0 || fairer(queues[user][0], load[user], queues[users[next]][0], load[users[next]]) {
+				next = i
+			}
+		}
+
+		user := users[next]
+		ordered = append(ordered, queues[user][0])
+		queues[user] = queues[user][1:]
+		load[user]++
+	}
+
+	return ordered
+}
+
+// fairer reports whether job a, whose user carries loadA jobs, should run
+// before job b, whose user carries loadB
+func fairer(a models.Job, loadA int, b models.Job, loadB int) bool {
+	if loadA != loadB {
+		return loadA < loadB
+	}
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.ID < b.ID
+}
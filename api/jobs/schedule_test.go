@@ -0,0 +1,101 @@
+package jobs
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+)
+
+// testJob returns a job of user created minute minutes after a fixed time
+func testJob(id, user string, minute int) models.Job {
+	created := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+	return models.Job{ID: id, UserID: user, CreatedAt: created.Add(time.Duration(minute) * time.Minute)}
+}
+
+func TestSchedule(t *testing.T) {
+	tests := []struct {
+		name       string
+		candidates []models.Job
+		running    map[string]int
+		want       []string
+	}{
+		{
+			name:       "nothing waiting",
+			candidates: nil,
+			want:       []string{},
+		},
+		{
+			name: "one user in creation order",
+			candidates: []models.Job{
+				testJob("a1", "alice", 0), testJob("a2", "alice", 1), testJob("a3", "alice", 2),
+			},
+			want: []string{"a1", "a2", "a3"},
+		},
+		{
+			name: "burst interleaved with later users",
+			candidates: []models.Job{
+				testJob("a1", "alice", 0), testJob("a2", "alice", 1), testJob("a3", "alice", 2),
+				testJob("b1", "bob", 3), testJob("c1", "carol", 4),
+			},
+			want: []string{"a1", "b1", "c1", "a2", "a3"},
+		},
+		{
+			name: "turns alternate between equally busy users",
+			candidates: []models.Job{
+				testJob("a1", "alice", 0), testJob("a2", "alice", 1), testJob("a3", "alice", 2),
+				testJob("b1", "bob", 3), testJob("b2", "bob", 4),
+			},
+			want: []string{"a1", "b1", "a2", "b2", "a3"},
+		},
+		{
+			name: "running jobs count against their user",
+			candidates: []models.Job{
+				testJob("a1", "alice", 0), testJob("b1", "bob", 1), testJob("b2", "bob", 2), testJob("b3", "bob", 3),
+			},
+			running: map[string]int{"alice": 2},
+			want:    []string{"b1", "b2", "a1", "b3"},
+		},
+		{
+			name: "users with jobs running elsewhere only",
+			candidates: []models.Job{
+				testJob("a1", "alice", 0), testJob("b1", "bob", 1),
+			},
+			running: map[string]int{"carol": 5, "bob": 1},
+			want:    []string{"a1", "b1"},
+		},
+		{
+			name: "ties broken by ID",
+			candidates: []models.Job{
+				testJob("j2", "bob", 0), testJob("j1", "alice", 0),
+			},
+			want: []string{"j1", "j2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			running := make(map[string]int, len(tt.running))
+			for user, n := range tt.running {
+				running[user] = n
+			}
+
+			ordered := schedule(tt.candidates, running)
+			got := []string{}
+			for _, job := range ordered {
+				got = append(got, job.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if len(running) != len(tt.running) {
+				t.Errorf("running changed to %v", running)
+			}
+			for user, n := range tt.running {
+				if running[user] != n {
+					t.Errorf("running changed to %v", running)
+				}
+			}
+		})
+	}
+}
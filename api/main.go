@@ -3,39 +3,258 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/go-playground/validator/v10"
-	"github.com/google/uuid"
 	"github.com/gorilla/mux"
-	"github.com/yourusername/k8s-env-provisioner/api/handlers"
-	"github.com/yourusername/k8s-env-provisioner/api/middleware"
-	"github.com/yourusername/k8s-env-provisioner/api/models"
-	"github.com/yourusername/k8s-env-provisioner/api/terraform"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/handlers"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/jobs"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/middleware"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/pricing"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/store"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/terraform"
 )
 
 func main() {
 	log.Println("Starting K8s Environment Provisioner API")
 
-	// Load configuration
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion("us-west-2"))
+	// Initialize the environment store. STORE_BACKEND=bolt runs the API
+	// entirely offline against a local database file.
+	var environmentStore store.EnvironmentStore
+	var templateStore store.TemplateStore
+	var userStore store.UserStore
+	var teamStore store.TeamStore
+	var jobStore store.JobStore
+	var eventStore store.EventStore
+	var logStore store.LogStore
+	var lockStore store.LockStore
+	var planStore store.PlanStore
+
+	backend := getEnv("STORE_BACKEND", "dynamodb")
+	switch backend {
+	case "dynamodb":
+		// Load configuration
+		cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion("us-west-2"))
+		if err != nil {
+			log.Fatalf("Failed to load AWS SDK configuration: %v", err)
+		}
+
+		// Initialize DynamoDB client
+		dynamoClient := dynamodb.NewFromConfig(cfg)
+
+		// Create the table and its indexes on first start
+		dynamoStore := store.NewDynamoDBEnvironmentStore(dynamoClient, "environments")
+		if err := dynamoStore.EnsureTable(context.TODO()); err != nil {
+			log.Fatalf("Failed to prepare environments table: %v", err)
+		}
+		environmentStore = dynamoStore
+
+		dynamoTemplateStore := store.NewDynamoDBTemplateStore(dynamoClient, "templates", "template_revisions")
+		if err := dynamoTemplateStore.EnsureTable(context.TODO()); err != nil {
+			log.Fatalf("Failed to prepare templates table: %v", err)
+		}
+		templateStore = dynamoTemplateStore
+
+		dynamoUserStore := store.NewDynamoDBUserStore(dynamoClient, "users")
+		if err := dynamoUserStore.EnsureTable(context.TODO()); err != nil {
+			log.Fatalf("Failed to prepare users table: %v", err)
+		}
+		userStore = dynamoUserStore
+
+		dynamoTeamStore := store.NewDynamoDBTeamStore(dynamoClient, "teams")
+		if err := dynamoTeamStore.EnsureTable(context.TODO()); err != nil {
+			log.Fatalf("Failed to prepare teams table: %v", err)
+		}
+		teamStore = dynamoTeamStore
+
+		dynamoJobStore := store.NewDynamoDBJobStore(dynamoClient, "jobs")
+		if err := dynamoJobStore.EnsureTable(context.TODO()); err != nil {
+			log.Fatalf("Failed to prepare jobs table: %v", err)
+		}
+		jobStore = dynamoJobStore
+
+		dynamoEventStore := store.NewDynamoDBEventStore(dynamoClient, "environment_events")
+		if err := dynamoEventStore.EnsureTable(context.TODO()); err != nil {
+			log.Fatalf("Failed to prepare environment events table: %v", err)
+		}
+		eventStore = dynamoEventStore
+
+		dynamoLogStore := store.NewDynamoDBLogStore(dynamoClient, "environment_logs")
+		if err := dynamoLogStore.EnsureTable(context.TODO()); err != nil {
+			log.Fatalf("Failed to prepare environment logs table: %v", err)
+		}
+		logStore = dynamoLogStore
+
+		dynamoLockStore := store.NewDynamoDBLockStore(dynamoClient, "environment_locks")
+		if err := dynamoLockStore.EnsureTable(context.TODO()); err != nil {
+			log.Fatalf("Failed to prepare environment locks table: %v", err)
+		}
+		lockStore = dynamoLockStore
+
+		dynamoPlanStore := store.NewDynamoDBPlanStore(dynamoClient, "environment_plans")
+		if err := dynamoPlanStore.EnsureTable(context.TODO()); err != nil {
+			log.Fatalf("Failed to prepare environment plans table: %v", err)
+		}
+		planStore = dynamoPlanStore
+	case "bolt":
+		db, err := store.OpenBolt(getEnv("BOLT_PATH", "provisioner.db"))
+		if err != nil {
+			log.Fatalf("Failed to open local database: %v", err)
+		}
+		defer db.Close()
+
+		environmentStore, err = store.NewBoltEnvironmentStore(db)
+		if err != nil {
+			log.Fatalf("Failed to initialize environment store: %v", err)
+		}
+
+		templateStore, err = store.NewBoltTemplateStore(db)
+		if err != nil {
+			log.Fatalf("Failed to initialize template store: %v", err)
+		}
+
+		userStore, err = store.NewBoltUserStore(db)
+		if err != nil {
+			log.Fatalf("Failed to initialize user store: %v", err)
+		}
+
+		teamStore, err = store.NewBoltTeamStore(db)
+		if err != nil {
+			log.Fatalf("Failed to initialize team store: %v", err)
+		}
+
+		jobStore, err = store.NewBoltJobStore(db)
+		if err != nil {
+			log.Fatalf("Failed to initialize job store: %v", err)
+		}
+
+		eventStore, err = store.NewBoltEventStore(db)
+		if err != nil {
+			log.Fatalf("Failed to initialize event store: %v", err)
+		}
+
+		logStore, err = store.NewBoltLogStore(db)
+		if err != nil {
+			log.Fatalf("Failed to initialize log store: %v", err)
+		}
+
+		lockStore, err = store.NewBoltLockStore(db)
+		if err != nil {
+			log.Fatalf("Failed to initialize lock store: %v", err)
+		}
+
+		planStore, err = store.NewBoltPlanStore(db)
+		if err != nil {
+			log.Fatalf("Failed to initialize plan store: %v", err)
+		}
+	default:
+		log.Fatalf("Unknown STORE_BACKEND %q (expected dynamodb or bolt)", backend)
+	}
+	log.Printf("Using %s environment store", backend)
+
+	// Load the instance-type prices used for cost estimates
+	pricingTable, err := pricing.LoadOrEmpty(getEnv("PRICING_FILE", "pricing.json"))
+	if err != nil {
+		log.Fatalf("Failed to load pricing table: %v", err)
+	}
+
+	// Initialize the Terraform state backend. Each environment gets its own
+	// working directory and its own state in it.
+	var stateBackend terraform.Backend
+	switch stateBackendKind := getEnv("TERRAFORM_STATE_BACKEND", "local"); stateBackendKind {
+	case "local":
+		stateBackend = terraform.LocalBackend{Dir: getEnv("TERRAFORM_STATE_DIR", "/var/lib/provisioner/state")}
+	case "s3":
+		bucket := os.Getenv("TERRAFORM_STATE_BUCKET")
+		if bucket == "" {
+			log.Fatalf("TERRAFORM_STATE_BUCKET is required for the s3 state backend")
+		}
+		stateBackend = terraform.S3Backend{
+			Bucket:    bucket,
+			Prefix:    getEnv("TERRAFORM_STATE_PREFIX", "environments"),
+			Region:    getEnv("TERRAFORM_STATE_REGION", "us-west-2"),
+			Endpoint:  os.Getenv("TERRAFORM_STATE_ENDPOINT"),
+			LockTable: os.Getenv("TERRAFORM_STATE_LOCK_TABLE"),
+		}
+	default:
+		log.Fatalf("Unknown TERRAFORM_STATE_BACKEND %q (expected local or s3)", stateBackendKind)
+	}
+	// Templates pick the tool their environments are provisioned with. All
+	// tools share the workspaces and state, so a template can switch tools.
+	workspaceDir := getEnv("TERRAFORM_WORKSPACE_DIR", "/var/lib/provisioner/workspaces")
+	executors := map[string]*terraform.Executor{
+		terraform.ToolTerraform:  terraform.NewExecutor("../provisioning", workspaceDir, stateBackend),
+		terraform.ToolOpenTofu:   terraform.NewOpenTofuExecutor("../provisioning", workspaceDir, stateBackend),
+		terraform.ToolTerragrunt: terraform.NewTerragruntExecutor("../provisioning", workspaceDir, stateBackend),
+	}
+
+	// Transient failures such as AWS throttling are retried with exponential
+	// backoff. Rules in TERRAFORM_RETRY_RULES_FILE are checked before the
+	// built-in ones.
+	retryPolicy := terraform.DefaultRetryPolicy
+	retryPolicy.MaxAttempts = getEnvInt("TERRAFORM_RETRY_MAX_ATTEMPTS", retryPolicy.MaxAttempts)
+	retryPolicy.InitialBackoff = getEnvDuration("TERRAFORM_RETRY_BACKOFF", retryPolicy.InitialBackoff)
+	retryPolicy.MaxBackoff = getEnvDuration("TERRAFORM_RETRY_MAX_BACKOFF", retryPolicy.MaxBackoff)
+	if path := os.Getenv("TERRAFORM_RETRY_RULES_FILE"); path != "" {
+		rules, err := terraform.LoadFailureRules(path)
+		if err != nil {
+			log.Fatalf("Failed to load Terraform failure rules: %v", err)
+		}
+		retryPolicy.Rules = append(rules, terraform.DefaultFailureRules...)
+	}
+
+	// Providers are downloaded once into a cache shared by every workspace.
+	// TERRAFORM_PROVIDER_MIRROR_DIR installs them from a filesystem mirror
+	// only, for air-gapped installs.
+	providerCache, err := terraform.NewProviderCache(
+		getEnv("TERRAFORM_PLUGIN_CACHE_DIR", filepath.Join(workspaceDir, ".plugin-cache")),
+		os.Getenv("TERRAFORM_PROVIDER_MIRROR_DIR"),
+	)
 	if err != nil {
-		log.Fatalf("Failed to load AWS SDK configuration: %v", err)
+		log.Fatalf("Failed to prepare provider cache: %v", err)
+	}
+
+	provisioners := terraform.Provisioners{}
+	for tool, executor := range executors {
+		executor.SetRetryPolicy(retryPolicy)
+		executor.SetProviderCache(providerCache)
+		provisioners[tool] = executor
 	}
 
-	// Initialize DynamoDB client
-	dynamoClient := dynamodb.NewFromConfig(cfg)
+	// Initialize the job queue. Workers identify themselves by host name so
+	// that a restarted pod reclaims the jobs it was running.
+	workerID := os.Getenv("JOB_WORKER_ID")
+	if workerID == "" {
+		workerID, _ = os.Hostname()
+	}
+	// Every operation on an environment, in any replica, first takes the
+	// environment's lock. A lock whose holder dies expires after
+	// ENVIRONMENT_LOCK_TTL without heartbeats.
+	locker := jobs.NewLocker(lockStore, workerID, getEnvDuration("ENVIRONMENT_LOCK_TTL", 2*time.Minute))
+	jobQueue := jobs.NewQueue(jobStore, locker, jobs.Config{
+		WorkerID:          workerID,
+		Workers:           getEnvInt("JOB_WORKERS", 4),
+		MaxRunning:        getEnvInt("JOB_MAX_RUNNING", 0),
+		MaxRunningPerUser: getEnvInt("JOB_MAX_RUNNING_PER_USER", 2),
+	})
 
-	// Initialize Terraform executor
-	terraformExecutor := terraform.NewExecutor("../provisioning")
+	// Reclaim the disk taken by the workspaces of deleted environments and
+	// by expired plans. Environments that are not DELETED keep their
+	// providers, lock file and state.
+	janitorInterval := getEnvDuration("WORKSPACE_JANITOR_INTERVAL", time.Hour)
+	janitor := jobs.NewJanitor(environmentStore, locker, executors[terraform.ToolTerraform], jobs.RetentionPolicy{
+		Deleted:  getEnvDuration("WORKSPACE_RETENTION_DELETED", jobs.DefaultRetentionPolicy.Deleted),
+		Orphaned: getEnvDuration("WORKSPACE_RETENTION_ORPHANED", jobs.DefaultRetentionPolicy.Orphaned),
+	}, janitorInterval)
 
 	// Initialize validator
 	validate := validator.New()
@@ -52,40 +271,93 @@ func main() {
 	// API routes
 	apiRouter := router.PathPrefix("/api/v1").Subrouter()
 
+	// Authentication against the configured OIDC provider. OIDC_JWKS_FILE
+	// verifies tokens against a local key set for offline use.
+	authMiddleware, err := middleware.NewAuthMiddleware(context.TODO(), middleware.AuthConfig{
+		Issuer:     os.Getenv("OIDC_ISSUER"),
+		Audience:   os.Getenv("OIDC_AUDIENCE"),
+		JWKSURL:    os.Getenv("OIDC_JWKS_URL"),
+		JWKSFile:   os.Getenv("OIDC_JWKS_FILE"),
+		AdminGroup: getEnv("PLATFORM_ADMIN_GROUP", "platform-admins"),
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize authentication: %v", err)
+	}
+
 	// Middleware
 	apiRouter.Use(middleware.LoggingMiddleware)
-	apiRouter.Use(middleware.AuthMiddleware)
+	apiRouter.Use(authMiddleware)
+	apiRouter.Use(middleware.NewTeamMiddleware(func(ctx context.Context, userID string) (map[string]string, error) {
+		teams, err := teamStore.ListForUser(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		roles := make(map[string]string, len(teams))
+		for _, team := range teams {
+			roles[team.ID] = team.Role(userID)
+		}
+		return roles, nil
+	}))
 	apiRouter.Use(middleware.ContentTypeMiddleware)
 
 	// Environment routes
-	environmentHandler := handlers.NewEnvironmentHandler(dynamoClient, terraformExecutor, validate)
+	environmentHandler := handlers.NewEnvironmentHandler(environmentStore, templateStore, jobQueue, locker, eventStore, logStore, planStore, provisioners, validate)
 	apiRouter.HandleFunc("/environments", environmentHandler.ListEnvironments).Methods("GET")
 	apiRouter.HandleFunc("/environments", environmentHandler.CreateEnvironment).Methods("POST")
+	apiRouter.HandleFunc("/environments/outdated", environmentHandler.ListOutdatedEnvironments).Methods("GET")
+	apiRouter.HandleFunc("/environments/lifecycle", environmentHandler.GetLifecycle).Methods("GET")
 	apiRouter.HandleFunc("/environments/{id}", environmentHandler.GetEnvironment).Methods("GET")
 	apiRouter.HandleFunc("/environments/{id}", environmentHandler.UpdateEnvironment).Methods("PATCH")
 	apiRouter.HandleFunc("/environments/{id}", environmentHandler.DeleteEnvironment).Methods("DELETE")
 	apiRouter.HandleFunc("/environments/{id}/status", environmentHandler.GetEnvironmentStatus).Methods("GET")
+	apiRouter.HandleFunc("/environments/{id}/upgrade", environmentHandler.UpgradeEnvironmentTemplate).Methods("POST")
+	apiRouter.HandleFunc("/environments/{id}/jobs", environmentHandler.ListEnvironmentJobs).Methods("GET")
+	apiRouter.HandleFunc("/environments/{id}/events", environmentHandler.ListEnvironmentEvents).Methods("GET")
+	apiRouter.HandleFunc("/environments/{id}/logs", environmentHandler.ListEnvironmentLogs).Methods("GET")
+	apiRouter.HandleFunc("/environments/{id}/cancel", environmentHandler.CancelEnvironmentOperation).Methods("POST")
+	apiRouter.HandleFunc("/environments/{id}/reconcile", environmentHandler.ReconcileEnvironment).Methods("POST")
+	apiRouter.HandleFunc("/environments/{id}/lock", environmentHandler.GetEnvironmentLock).Methods("GET")
+	apiRouter.HandleFunc("/environments/{id}/lock", environmentHandler.BreakEnvironmentLock).Methods("DELETE")
+	apiRouter.HandleFunc("/environments/{id}/plan", environmentHandler.PlanEnvironment).Methods("POST")
+	apiRouter.HandleFunc("/environments/{id}/plans/{planId}", environmentHandler.GetEnvironmentPlan).Methods("GET")
+	apiRouter.HandleFunc("/environments/{id}/plans/{planId}/apply", environmentHandler.ApplyEnvironmentPlan).Methods("POST")
+
+	// Job queue routes
+	jobHandler := handlers.NewJobHandler(jobQueue)
+	apiRouter.HandleFunc("/jobs", jobHandler.ListJobs).Methods("GET")
+	apiRouter.HandleFunc("/jobs/{id}", jobHandler.GetJob).Methods("GET")
 
 	// Cluster template routes
-	templateHandler := handlers.NewTemplateHandler(dynamoClient, validate)
+	templateHandler := handlers.NewTemplateHandler(templateStore, validate)
 	apiRouter.HandleFunc("/templates", templateHandler.ListTemplates).Methods("GET")
 	apiRouter.HandleFunc("/templates", templateHandler.CreateTemplate).Methods("POST")
 	apiRouter.HandleFunc("/templates/{id}", templateHandler.GetTemplate).Methods("GET")
 	apiRouter.HandleFunc("/templates/{id}", templateHandler.UpdateTemplate).Methods("PATCH")
 	apiRouter.HandleFunc("/templates/{id}", templateHandler.DeleteTemplate).Methods("DELETE")
+	apiRouter.HandleFunc("/templates/{id}/revisions", templateHandler.ListTemplateRevisions).Methods("GET")
+	apiRouter.HandleFunc("/templates/{id}/revisions/{revision}", templateHandler.GetTemplateRevision).Methods("GET")
 
-	// User routes
-	userHandler := handlers.NewUserHandler(dynamoClient, validate)
+	// User and team routes
+	userHandler := handlers.NewUserHandler(userStore, teamStore, validate)
 	apiRouter.HandleFunc("/users", userHandler.ListUsers).Methods("GET")
 	apiRouter.HandleFunc("/users", userHandler.CreateUser).Methods("POST")
 	apiRouter.HandleFunc("/users/{id}", userHandler.GetUser).Methods("GET")
 	apiRouter.HandleFunc("/users/{id}", userHandler.UpdateUser).Methods("PATCH")
 	apiRouter.HandleFunc("/users/{id}", userHandler.DeleteUser).Methods("DELETE")
+	apiRouter.HandleFunc("/teams", userHandler.ListTeams).Methods("GET")
+	apiRouter.HandleFunc("/teams", userHandler.CreateTeam).Methods("POST")
+	apiRouter.HandleFunc("/teams/{id}", userHandler.GetTeam).Methods("GET")
+	apiRouter.HandleFunc("/teams/{id}", userHandler.UpdateTeam).Methods("PATCH")
+	apiRouter.HandleFunc("/teams/{id}", userHandler.DeleteTeam).Methods("DELETE")
+	apiRouter.HandleFunc("/teams/{id}/members/{userId}", userHandler.SetTeamMember).Methods("PUT")
+	apiRouter.HandleFunc("/teams/{id}/members/{userId}", userHandler.RemoveTeamMember).Methods("DELETE")
 
 	// Metrics routes
-	metricHandler := handlers.NewMetricHandler(dynamoClient)
+	metricHandler := handlers.NewMetricHandler(environmentStore, templateStore, pricingTable, janitor, provisioners)
 	apiRouter.HandleFunc("/metrics/usage", metricHandler.GetUsageMetrics).Methods("GET")
 	apiRouter.HandleFunc("/metrics/cost", metricHandler.GetCostMetrics).Methods("GET")
+	apiRouter.HandleFunc("/metrics/workspaces", metricHandler.GetWorkspaceMetrics).Methods("GET")
+	apiRouter.HandleFunc("/metrics/phases", metricHandler.GetPhaseMetrics).Methods("GET")
 
 	// Documentation
 	router.PathPrefix("/api/docs/").Handler(http.StripPrefix("/api/docs/", http.FileServer(http.Dir("./docs"))))
@@ -99,6 +371,22 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// Start the job workers, resuming work interrupted by a previous restart
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	if err := jobQueue.Start(workerCtx, environmentHandler); err != nil {
+		log.Fatalf("Failed to start job workers: %v", err)
+	}
+
+	// Check active environments for drift in the background
+	if driftInterval := getEnvDuration("DRIFT_CHECK_INTERVAL", 6*time.Hour); driftInterval > 0 {
+		jobs.NewDriftScheduler(environmentStore, jobQueue, driftInterval).Start(workerCtx)
+	}
+
+	// Sweep the workspaces on this replica's disk in the background
+	if janitorInterval > 0 {
+		janitor.Start(workerCtx)
+	}
+
 	// Start server in a goroutine
 	go func() {
 		log.Printf("Server listening on %s", server.Addr)
@@ -113,58 +401,50 @@ func main() {
 	<-stop
 
 	log.Println("Shutting down server...")
+	stopWorkers()
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatalf("Server shutdown failed: %v", err)
 	}
+
+	// Let interrupted Terraform runs save their state before exiting
+	jobQueue.Wait()
 	log.Println("Server gracefully stopped")
 }
 
-// Example of a handler implementation
-func createEnvironmentHandler(w http.ResponseWriter, r *http.Request, dynamoClient *dynamodb.Client, validate *validator.Validate) {
-	var env models.EnvironmentRequest
-	if err := json.NewDecoder(r.Body).Decode(&env); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
-		return
+// getEnv returns the value of an environment variable or a fallback if unset
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
 	}
+	return fallback
+}
 
-	// Validate request
-	if err := validate.Struct(env); err != nil {
-		http.Error(w, fmt.Sprintf("Validation error: %v", err), http.StatusBadRequest)
-		return
+// getEnvInt returns the integer value of an environment variable or a fallback
+// if unset, exiting if the value is not an integer
+func getEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
 	}
-
-	// Create environment record
-	envID := uuid.New().String()
-	environment := models.Environment{
-		ID:             envID,
-		Name:           env.Name,
-		Description:    env.Description,
-		TemplateID:     env.TemplateID,
-		UserID:         env.UserID,
-		ResourceLimits: env.ResourceLimits,
-		Status:         "CREATING",
-		CreatedAt:      time.Now().UTC(),
-		UpdatedAt:      time.Now().UTC(),
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Invalid %s %q: expected an integer", key, value)
 	}
-
-	// Store in DynamoDB
-	// (Actual implementation would go here)
-
-	// Trigger Terraform in a goroutine
-	go provisionEnvironment(environment)
-
-	// Return the created environment
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(environment)
+	return n
 }
 
-// Simulate provisioning an environment
-func provisionEnvironment(env models.Environment) {
-	log.Printf("Provisioning environment: %s", env.Name)
-	// Actual implementation would use Terraform to provision the environment
-	time.Sleep(5 * time.Second)
-	log.Printf("Environment provisioned: %s", env.Name)
-	// Update status in DynamoDB
-}
\ No newline at end of file
+// getEnvDuration returns the duration value of an environment variable, such
+// as "6h", or a fallback if unset, exiting if the value is not a duration
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid %s %q: expected a duration such as \"6h\"", key, value)
+	}
+	return d
+}
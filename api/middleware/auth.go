@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// AuthConfig configures bearer-token authentication against an OIDC provider
+type AuthConfig struct {
+	// Issuer is the expected "iss" claim. Unless JWKSURL or JWKSFile is set,
+	// signing keys are discovered from the issuer's OpenID configuration.
+	Issuer string
+
+	// Audience, if set, must appear in the "aud" claim
+	Audience string
+
+	// JWKSURL overrides the discovered jwks_uri
+	JWKSURL string
+
+	// JWKSFile loads signing keys from a local file for offline use
+	JWKSFile string
+
+	// AdminGroup is the "groups" claim value that grants the platform-admin role
+	AdminGroup string
+}
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	Subject string
+	Email   string
+	Name    string
+	Groups  []string
+
+	// Admin is set for members of the platform-admin group, who may act on
+	// every environment
+	Admin bool
+
+	// Teams maps the IDs of the teams the caller belongs to to their role in
+	// each. It is filled in by the team middleware.
+	Teams map[string]string
+}
+
+// claims are the JWT claims the API reads from an ID or access token
+type claims struct {
+	Email  string   `json:"email"`
+	Name   string   `json:"name"`
+	Groups []string `json:"groups"`
+	jwt.RegisteredClaims
+}
+
+type principalContextKey struct{}
+
+// signingMethods are the asymmetric algorithms accepted from the provider
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// NewAuthMiddleware returns middleware that requires a valid bearer JWT on
+// every request and stores the authenticated Principal in the request context
+func NewAuthMiddleware(ctx context.Context, config AuthConfig) (func(http.Handler) http.Handler, error) {
+	if config.Issuer == "" {
+		return nil, errors.New("OIDC issuer is required")
+	}
+
+	jwksURL := config.JWKSURL
+	if config.JWKSFile == "" && jwksURL == "" {
+		discovered, err := discoverJWKSURL(ctx, config.Issuer)
+		if err != nil {
+			return nil, err
+		}
+		jwksURL = discovered
+	}
+
+	keys, err := newKeySet(ctx, config.JWKSFile, jwksURL)
+	if err != nil {
+		return nil, err
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(signingMethods),
+		jwt.WithIssuer(config.Issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if config.Audience != "" {
+		options = append(options, jwt.WithAudience(config.Audience))
+	}
+	parser := jwt.NewParser(options...)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokenString, ok := bearerToken(r)
+			if !ok {
+				unauthorized(w, "missing bearer token")
+				return
+			}
+
+			var tokenClaims claims
+			_, err := parser.ParseWithClaims(tokenString, &tokenClaims, func(token *jwt.Token) (interface{}, error) {
+				kid, _ := token.Header["kid"].(string)
+				return keys.key(r.Context(), kid)
+			})
+			if err != nil {
+				log.Printf("Rejected bearer token: %v", err)
+				unauthorized(w, "invalid token")
+				return
+			}
+			if tokenClaims.Subject == "" {
+				unauthorized(w, "token has no subject")
+				return
+			}
+
+			principal := Principal{
+				Subject: tokenClaims.Subject,
+				Email:   tokenClaims.Email,
+				Name:    tokenClaims.Name,
+				Groups:  tokenClaims.Groups,
+			}
+			for _, group := range tokenClaims.Groups {
+				if config.AdminGroup != "" && group == config.AdminGroup {
+					principal.Admin = true
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+		})
+	}, nil
+}
+
+// WithPrincipal returns a copy of ctx carrying the principal
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal stored by the auth middleware
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey{}).(Principal)
+	return principal, ok
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// unauthorized writes a 401 response with a Bearer challenge
+func unauthorized(w http.ResponseWriter, description string) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="invalid_token", error_description=%q`, description))
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"mime"
+	"net/http"
+)
+
+// ContentTypeMiddleware rejects request bodies that are not JSON
+func ContentTypeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hasBody := r.ContentLength > 0 || len(r.TransferEncoding) > 0
+		if hasBody {
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || mediaType != "application/json" {
+				http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net/http"
+)
+
+// TeamLookup returns the roles a user holds in their teams, keyed by team ID
+type TeamLookup func(ctx context.Context, userID string) (map[string]string, error)
+
+// NewTeamMiddleware returns middleware that resolves the authenticated
+// principal's team memberships. It must run after the auth middleware.
+func NewTeamMiddleware(lookup TeamLookup) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := PrincipalFromContext(r.Context())
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			teams, err := lookup(r.Context(), principal.Subject)
+			if err != nil {
+				log.Printf("Failed to resolve teams of %s: %v", principal.Subject, err)
+				http.Error(w, "Failed to resolve team membership", http.StatusInternalServerError)
+				return
+			}
+			principal.Teams = teams
+
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
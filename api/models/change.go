@@ -0,0 +1,48 @@
+package models
+
+// Update steps, the work that applies a changed field of an environment's
+// spec to the environment
+const (
+	// UpdateStepTerraform re-applies the environment's infrastructure, for
+	// node counts, addons and AWS tags
+	UpdateStepTerraform = "TERRAFORM"
+
+	// UpdateStepKubernetes would reconcile the objects inside the cluster,
+	// for resource quotas, network policies, the service mesh, monitoring
+	// and GitOps. Nothing reconciles them yet, so updates changing these
+	// fields are refused.
+	UpdateStepKubernetes = "KUBERNETES"
+
+	// UpdateStepNone marks metadata, such as the description, that is only
+	// stored
+	UpdateStepNone = "NONE"
+)
+
+// UpdateSteps lists the steps of an update that can run, in the order they
+// run
+var UpdateSteps = []string{UpdateStepTerraform}
+
+// SpecChange is a field of an environment's spec changed by an update, and
+// the step that applies it
+type SpecChange struct {
+	// Field is the JSON path of the field, such as "networkPolicy" or
+	// "resourceLimits.cpu"
+	Field string `json:"field"`
+	Step  string `json:"step"`
+}
+
+// ChangeSteps returns the steps that apply changes, in the order they run
+func ChangeSteps(changes []SpecChange) []string {
+	needed := make(map[string]bool)
+	for _, change := range changes {
+		needed[change.Step] = true
+	}
+
+	var steps []string
+	for _, step := range UpdateSteps {
+		if needed[step] {
+			steps = append(steps, step)
+		}
+	}
+	return steps
+}
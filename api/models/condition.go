@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+)
+
+// Environment condition types
+const (
+	// ConditionDrifted is true when the environment's infrastructure no
+	// longer matches what Terraform last applied, because it was changed
+	// outside the provisioner
+	ConditionDrifted = "DRIFTED"
+)
+
+// EnvironmentCondition is an observation about an environment that does not
+// change its lifecycle state
+type EnvironmentCondition struct {
+	Type    string `json:"type"`
+	Status  bool   `json:"status"`
+	Message string `json:"message,omitempty"`
+
+	// Resources lists the resources the condition is about, such as the
+	// drifted ones
+	Resources []ConditionResource `json:"resources,omitempty"`
+
+	// LastCheckedAt is when the condition was last observed, and
+	// LastTransitionAt when its status last changed
+	LastCheckedAt    time.Time `json:"lastCheckedAt"`
+	LastTransitionAt time.Time `json:"lastTransitionAt"`
+}
+
+// ConditionResource names a resource of an environment's infrastructure and
+// what happened to it, such as "update" for a resource changed outside the
+// provisioner or "delete" for one deleted
+type ConditionResource struct {
+	Address string `json:"address"`
+	Type    string `json:"type"`
+	Action  string `json:"action"`
+}
+
+// Condition returns the environment's condition of a type, if it has one
+func (e *Environment) Condition(conditionType string) (EnvironmentCondition, bool) {
+	for _, condition := range e.Conditions {
+		if condition.Type == conditionType {
+			return condition, true
+		}
+	}
+	return EnvironmentCondition{}, false
+}
+
+// SetCondition adds or replaces the environment's condition of the same type.
+// LastTransitionAt is kept unless the status changes.
+func (e *Environment) SetCondition(condition EnvironmentCondition) {
+	condition.LastTransitionAt = condition.LastCheckedAt
+	for i, existing := range e.Conditions {
+		if existing.Type != condition.Type {
+			continue
+		}
+		if existing.Status == condition.Status {
+			condition.LastTransitionAt = existing.LastTransitionAt
+		}
+		e.Conditions[i] = condition
+		return
+	}
+	e.Conditions = append(e.Conditions, condition)
+}
@@ -61,46 +61,61 @@ type GitOpsConfig struct {
 
 // EnvironmentRequest is used when creating a new environment
 type EnvironmentRequest struct {
-	Name           string            `json:"name" validate:"required,min=3,max=63"`
-	Description    string            `json:"description" validate:"max=255"`
-	TemplateID     string            `json:"templateId" validate:"required"`
-	UserID         string            `json:"userId" validate:"required"`
-	ResourceLimits ResourceLimits    `json:"resourceLimits" validate:"required"`
-	NetworkPolicy  *NetworkPolicy    `json:"networkPolicy"`
-	ServiceMesh    *ServiceMeshConfig `json:"serviceMesh"`
-	Monitoring     *MonitoringConfig `json:"monitoring"`
-	GitOps         *GitOpsConfig     `json:"gitOps"`
-	Addons         []string          `json:"addons"`
-	Tags           map[string]string `json:"tags"`
+	Name             string             `json:"name" validate:"required,min=3,max=63"`
+	Description      string             `json:"description" validate:"max=255"`
+	TemplateID       string             `json:"templateId" validate:"required"`
+	TemplateRevision int64              `json:"templateRevision" validate:"gte=0"` // 0 selects the latest revision
+	UserID           string             `json:"userId"`
+	TeamID           string             `json:"teamId"`
+	ResourceLimits   ResourceLimits     `json:"resourceLimits" validate:"required"`
+	NetworkPolicy    *NetworkPolicy     `json:"networkPolicy"`
+	ServiceMesh      *ServiceMeshConfig `json:"serviceMesh"`
+	Monitoring       *MonitoringConfig  `json:"monitoring"`
+	GitOps           *GitOpsConfig      `json:"gitOps"`
+	Addons           []string           `json:"addons"`
+	Tags             map[string]string  `json:"tags"`
 }
 
 // Environment represents a Kubernetes environment in the system
 type Environment struct {
-	ID             string            `json:"id"`
-	Name           string            `json:"name"`
-	Description    string            `json:"description"`
-	TemplateID     string            `json:"templateId"`
-	UserID         string            `json:"userId"`
-	ResourceLimits ResourceLimits    `json:"resourceLimits"`
-	NetworkPolicy  *NetworkPolicy    `json:"networkPolicy"`
-	ServiceMesh    *ServiceMeshConfig `json:"serviceMesh"`
-	Monitoring     *MonitoringConfig `json:"monitoring"`
-	GitOps         *GitOpsConfig     `json:"gitOps"`
-	Addons         []string          `json:"addons"`
-	Tags           map[string]string `json:"tags"`
-	Status         string            `json:"status"`
-	StatusMessage  string            `json:"statusMessage"`
-	ClusterName    string            `json:"clusterName"`
-	KubeConfig     string            `json:"kubeConfig,omitempty"`
-	ConsoleURL     string            `json:"consoleUrl"`
-	CreatedAt      time.Time         `json:"createdAt"`
-	UpdatedAt      time.Time         `json:"updatedAt"`
-	DeletedAt      *time.Time        `json:"deletedAt,omitempty"`
+	ID               string             `json:"id"`
+	Name             string             `json:"name"`
+	Description      string             `json:"description"`
+	TemplateID       string             `json:"templateId"`
+	TemplateRevision int64              `json:"templateRevision"`
+	UserID           string             `json:"userId" dynamodbav:"UserID,omitempty"`
+	TeamID           string             `json:"teamId,omitempty" dynamodbav:"TeamID,omitempty"`
+	ResourceLimits   ResourceLimits     `json:"resourceLimits"`
+	NetworkPolicy    *NetworkPolicy     `json:"networkPolicy"`
+	ServiceMesh      *ServiceMeshConfig `json:"serviceMesh"`
+	Monitoring       *MonitoringConfig  `json:"monitoring"`
+	GitOps           *GitOpsConfig      `json:"gitOps"`
+	Addons           []string           `json:"addons"`
+	Tags             map[string]string  `json:"tags"`
+	Status           EnvironmentState   `json:"status"`
+	StatusMessage    string             `json:"statusMessage"`
+	ClusterName      string             `json:"clusterName"`
+	KubeConfig       string             `json:"kubeConfig,omitempty"`
+	ConsoleURL       string             `json:"consoleUrl"`
+	Version          int64              `json:"version"`
+	CreatedAt        time.Time          `json:"createdAt"`
+	UpdatedAt        time.Time          `json:"updatedAt"`
+	DeletedAt        *time.Time         `json:"deletedAt,omitempty" dynamodbav:",omitempty"`
+
+	// Conditions are observations about the environment, such as drift,
+	// that do not change its status
+	Conditions []EnvironmentCondition `json:"conditions,omitempty"`
+
+	// FailureCategory classifies the failure that put the environment in
+	// ERROR, such as THROTTLING or PERMISSION, telling a failure worth
+	// retrying from one that needs a fix first
+	FailureCategory string `json:"failureCategory,omitempty"`
 }
 
 // EnvironmentPatch represents the fields that can be updated
 type EnvironmentPatch struct {
 	Description    *string            `json:"description"`
+	TeamID         *string            `json:"teamId"`
 	ResourceLimits *ResourceLimits    `json:"resourceLimits"`
 	NetworkPolicy  *NetworkPolicy     `json:"networkPolicy"`
 	ServiceMesh    *ServiceMeshConfig `json:"serviceMesh"`
@@ -110,10 +125,18 @@ type EnvironmentPatch struct {
 	Tags           map[string]string  `json:"tags"`
 }
 
+// EnvironmentList is one page of environments returned by the list endpoint
+type EnvironmentList struct {
+	Items      []Environment `json:"items"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+}
+
 // EnvironmentStatus defines the detailed status of an environment
 type EnvironmentStatus struct {
-	Status                  string            `json:"status"`
+	Status                  EnvironmentState  `json:"status"`
 	StatusMessage           string            `json:"statusMessage"`
+	FailureCategory         string            `json:"failureCategory,omitempty"`
+	QueuePosition           int               `json:"queuePosition,omitempty"`
 	ResourceUtilization     ResourceUsage     `json:"resourceUtilization"`
 	NodeStatus              []NodeStatus      `json:"nodeStatus"`
 	NamespaceStatuses       []NamespaceStatus `json:"namespaceStatuses"`
@@ -128,22 +151,42 @@ type EnvironmentStatus struct {
 
 // ResourceUsage defines the current resource usage of an environment
 type ResourceUsage struct {
-	CPUUsage       string `json:"cpuUsage"`
-	CPUPercentage  float64 `json:"cpuPercentage"`
-	MemoryUsage    string `json:"memoryUsage"`
-	MemoryPercentage float64 `json:"memoryPercentage"`
-	StorageUsage   string `json:"storageUsage"`
+	CPUUsage          string  `json:"cpuUsage"`
+	CPUPercentage     float64 `json:"cpuPercentage"`
+	MemoryUsage       string  `json:"memoryUsage"`
+	MemoryPercentage  float64 `json:"memoryPercentage"`
+	StorageUsage      string  `json:"storageUsage"`
 	StoragePercentage float64 `json:"storagePercentage"`
-	NodeCount      int    `json:"nodeCount"`
-	NamespaceCount int    `json:"namespaceCount"`
-	PodCount       int    `json:"podCount"`
-	ServiceCount   int    `json:"serviceCount"`
+	NodeCount         int     `json:"nodeCount"`
+	NamespaceCount    int     `json:"namespaceCount"`
+	PodCount          int     `json:"podCount"`
+	ServiceCount      int     `json:"serviceCount"`
 }
 
 // NodeStatus defines the status of a node in the environment
 type NodeStatus struct {
-	Name           string  `json:"name"`
-	Status         string  `json:"status"`
-	CPUPercentage  float64 `json:"cpuPercentage"`
+	Name             string  `json:"name"`
+	Status           string  `json:"status"`
+	CPUPercentage    float64 `json:"cpuPercentage"`
 	MemoryPercentage float64 `json:"memoryPercentage"`
-	PodCount       int     `json:"
\ No newline at end of file
+	PodCount         int     `json:"podCount"`
+	Ready            bool    `json:"ready"`
+	Age              string  `json:"age"`
+	Version          string  `json:"version"`
+	InternalIP       string  `json:"internalIp"`
+}
+
+// NamespaceStatus defines the status of a namespace in the environment
+type NamespaceStatus struct {
+	Name             string  `json:"name"`
+	Status           string  `json:"status"`
+	PodCount         int     `json:"podCount"`
+	ServiceCount     int     `json:"serviceCount"`
+	CPUUsage         string  `json:"cpuUsage"`
+	CPUPercentage    float64 `json:"cpuPercentage"`
+	MemoryUsage      string  `json:"memoryUsage"`
+	MemoryPercentage float64 `json:"memoryPercentage"`
+	StorageUsage     string  `json:"storageUsage"`
+	Age              string  `json:"age"`
+	Owner            string  `json:"owner"`
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+)
+
+// Environment event types, one per step in an environment's lifecycle
+const (
+	EventRequested                = "REQUESTED"
+	EventQueued                   = "QUEUED"
+	EventJobStarted               = "JOB_STARTED"
+	EventTerraformInitStarted     = "TERRAFORM_INIT_STARTED"
+	EventTerraformInitFinished    = "TERRAFORM_INIT_FINISHED"
+	EventTerraformInitSkipped     = "TERRAFORM_INIT_SKIPPED"
+	EventTerraformPlanStarted     = "TERRAFORM_PLAN_STARTED"
+	EventTerraformPlanFinished    = "TERRAFORM_PLAN_FINISHED"
+	EventPlanCreated              = "PLAN_CREATED"
+	EventTerraformApplyStarted    = "TERRAFORM_APPLY_STARTED"
+	EventTerraformApplyFinished   = "TERRAFORM_APPLY_FINISHED"
+	EventTerraformDestroyStarted  = "TERRAFORM_DESTROY_STARTED"
+	EventTerraformDestroyFinished = "TERRAFORM_DESTROY_FINISHED"
+	EventTerraformRetrying        = "TERRAFORM_RETRYING"
+	EventOutputsRead              = "OUTPUTS_READ"
+	EventChangesApplied           = "CHANGES_APPLIED"
+	EventStatusChanged            = "STATUS_CHANGED"
+	EventFailed                   = "FAILED"
+	EventCancelRequested          = "CANCEL_REQUESTED"
+	EventCancelled                = "CANCELLED"
+	EventDriftDetected            = "DRIFT_DETECTED"
+	EventDriftResolved            = "DRIFT_RESOLVED"
+	EventLockBroken               = "LOCK_BROKEN"
+)
+
+// ActorSystem is the actor of events recorded by background workers
+const ActorSystem = "system"
+
+// EnvironmentEvent records one step in the life of an environment. Events are
+// append-only and make up the environment's timeline.
+type EnvironmentEvent struct {
+	ID            string `json:"id"`
+	EnvironmentID string `json:"environmentId"`
+
+	// Sequence orders the events of one environment
+	Sequence int64 `json:"sequence"`
+
+	// JobID is the background job the event belongs to, if any
+	JobID string `json:"jobId,omitempty"`
+
+	Type    string            `json:"type"`
+	Actor   string            `json:"actor"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+}
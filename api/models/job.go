@@ -0,0 +1,84 @@
+package models
+
+import (
+	"time"
+)
+
+// Job types, one per kind of background work on an environment
+const (
+	JobTypeProvision = "PROVISION"
+	JobTypeUpdate    = "UPDATE"
+	JobTypeDelete    = "DELETE"
+
+	// JobTypeApplyPlan applies a saved plan, named by the job's PlanID
+	JobTypeApplyPlan = "APPLY_PLAN"
+
+	// JobTypeDetectDrift checks an environment's infrastructure for changes
+	// made outside Terraform. It leaves the environment's status alone.
+	JobTypeDetectDrift = "DETECT_DRIFT"
+)
+
+// Job statuses. PENDING and RUNNING jobs are unfinished; the rest are final.
+const (
+	JobStatusPending   = "PENDING"
+	JobStatusRunning   = "RUNNING"
+	JobStatusSucceeded = "SUCCEEDED"
+	JobStatusFailed    = "FAILED"
+
+	// JobStatusAbandoned marks a job whose workers kept dying before it
+	// finished, so it was given up after MaxAttempts
+	JobStatusAbandoned = "ABANDONED"
+
+	// JobStatusCancelled marks a job stopped at a user's request
+	JobStatusCancelled = "CANCELLED"
+)
+
+// Job is a unit of background work on an environment. Jobs are persisted so
+// that work interrupted by an API restart is picked up again by the next
+// worker that claims it.
+type Job struct {
+	ID            string `json:"id"`
+	EnvironmentID string `json:"environmentId"`
+
+	// UserID is the user who asked for the work. Concurrency limits and fair
+	// scheduling are applied per user.
+	UserID string `json:"userId,omitempty"`
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+
+	// PlanID is the saved plan an APPLY_PLAN job applies
+	PlanID string `json:"planId,omitempty"`
+
+	// Changes are the spec fields an UPDATE job applies, and Steps the
+	// update steps it runs to apply them. An UPDATE job without steps runs
+	// all of them.
+	Changes []SpecChange `json:"changes,omitempty"`
+	Steps   []string     `json:"steps,omitempty"`
+
+	// Attempts counts how many times a worker has claimed the job
+	Attempts    int `json:"attempts"`
+	MaxAttempts int `json:"maxAttempts"`
+
+	// LeaseOwner is the worker running the job. The lease lapses at
+	// LeaseExpiresAt unless the worker renews it, after which another worker
+	// may take the job over.
+	LeaseOwner     string     `json:"leaseOwner,omitempty"`
+	LeaseExpiresAt *time.Time `json:"leaseExpiresAt,omitempty"`
+
+	// CancelRequestedAt is set when a user asks to cancel the job while it
+	// runs. The worker running it notices and interrupts the work.
+	CancelRequestedAt *time.Time `json:"cancelRequestedAt,omitempty"`
+	CancelledBy       string     `json:"cancelledBy,omitempty"`
+
+	Version    int64      `json:"version"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// Finished reports whether the job has reached a final status
+func (j Job) Finished() bool {
+	return j.Status != JobStatusPending && j.Status != JobStatusRunning
+}
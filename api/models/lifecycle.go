@@ -0,0 +1,183 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// EnvironmentState is a stage in the lifecycle of an environment
+type EnvironmentState string
+
+// Environment lifecycle states
+const (
+	StateCreating     EnvironmentState = "CREATING"
+	StateProvisioning EnvironmentState = "PROVISIONING"
+	StateActive       EnvironmentState = "ACTIVE"
+	StateUpdating     EnvironmentState = "UPDATING"
+	StateDeleting     EnvironmentState = "DELETING"
+	StateError        EnvironmentState = "ERROR"
+	StateDeleted      EnvironmentState = "DELETED"
+)
+
+// Operations that users start on an existing environment. Each one moves the
+// environment into the state named by OperationState.
+const (
+	OperationUpdate  = "update"
+	OperationUpgrade = "upgrade"
+	OperationDelete  = "delete"
+
+	// OperationApply applies a saved plan of the environment
+	OperationApply = "apply"
+
+	// OperationReconcile re-applies the environment's configuration to undo
+	// drift
+	OperationReconcile = "reconcile"
+)
+
+// OperationCreate names the creation of a new environment, which starts in
+// StateCreating rather than moving an existing environment
+const OperationCreate = "create"
+
+// ErrInvalidTransition is returned when a status write would move an
+// environment along an edge missing from the transition table
+var ErrInvalidTransition = errors.New("invalid environment state transition")
+
+// environmentStates lists every state in lifecycle order
+var environmentStates = []EnvironmentState{
+	StateCreating,
+	StateProvisioning,
+	StateActive,
+	StateUpdating,
+	StateDeleting,
+	StateError,
+	StateDeleted,
+}
+
+// environmentTransitions is the transition table of the lifecycle: the states
+// each state may move to. Work in flight must finish or fail before users can
+// start something new, and DELETED is final.
+var environmentTransitions = map[EnvironmentState][]EnvironmentState{
+	StateCreating:     {StateProvisioning, StateError},
+	StateProvisioning: {StateActive, StateError},
+	StateActive:       {StateUpdating, StateDeleting},
+	StateUpdating:     {StateActive, StateError},
+	StateDeleting:     {StateDeleted, StateError},
+	StateError:        {StateUpdating, StateDeleting},
+	StateDeleted:      {},
+}
+
+// operationStates maps each operation to the state it moves an environment to
+var operationStates = map[string]EnvironmentState{
+	OperationUpdate:    StateUpdating,
+	OperationUpgrade:   StateUpdating,
+	OperationDelete:    StateDeleting,
+	OperationApply:     StateUpdating,
+	OperationReconcile: StateUpdating,
+}
+
+// Valid reports whether s is a known state
+func (s EnvironmentState) Valid() bool {
+	_, ok := environmentTransitions[s]
+	return ok
+}
+
+// CanTransition reports whether an environment in state s may move to next.
+// Rewriting the current state is allowed so that a job resumed after a
+// restart can repeat its status writes.
+func (s EnvironmentState) CanTransition(next EnvironmentState) bool {
+	if !next.Valid() {
+		return false
+	}
+	if s == next {
+		return true
+	}
+	for _, allowed := range environmentTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// Sources returns the states from which an environment may move to s,
+// including s itself
+func (s EnvironmentState) Sources() []EnvironmentState {
+	var sources []EnvironmentState
+	for _, state := range environmentStates {
+		if state.CanTransition(s) {
+			sources = append(sources, state)
+		}
+	}
+	return sources
+}
+
+// Allows reports whether an operation may be started on an environment in
+// state s. Unlike CanTransition it rejects repeating an operation that is
+// already in flight.
+func (s EnvironmentState) Allows(operation string) bool {
+	target, ok := operationStates[operation]
+	return ok && s != target && s.CanTransition(target)
+}
+
+// OperationState returns the state an operation moves an environment to
+func OperationState(operation string) EnvironmentState {
+	return operationStates[operation]
+}
+
+// Transition moves the environment to next with a new status message and
+// clears its failure category, which a failure sets after moving to ERROR. It
+// returns an error wrapping ErrInvalidTransition, and leaves the environment
+// unchanged, if the transition table does not allow the move.
+func (e *Environment) Transition(next EnvironmentState, message string) error {
+	if !e.Status.CanTransition(next) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, e.Status, next)
+	}
+
+	e.Status = next
+	e.StatusMessage = message
+	e.FailureCategory = ""
+	return nil
+}
+
+// Lifecycle describes the environment lifecycle for clients such as the
+// portal, which use it to decide which actions to offer
+type Lifecycle struct {
+	States      []EnvironmentState                      `json:"states"`
+	Initial     EnvironmentState                        `json:"initial"`
+	Final       []EnvironmentState                      `json:"final"`
+	Transitions map[EnvironmentState][]EnvironmentState `json:"transitions"`
+
+	// Operations maps each user operation to the states it may be started in
+	Operations map[string][]EnvironmentState `json:"operations"`
+}
+
+// EnvironmentLifecycle returns the lifecycle's states, transition table and
+// the states each operation is allowed in
+func EnvironmentLifecycle() Lifecycle {
+	lifecycle := Lifecycle{
+		States:      append([]EnvironmentState{}, environmentStates...),
+		Initial:     StateCreating,
+		Transitions: make(map[EnvironmentState][]EnvironmentState, len(environmentTransitions)),
+		Operations:  make(map[string][]EnvironmentState, len(operationStates)),
+	}
+
+	for _, state := range environmentStates {
+		next := environmentTransitions[state]
+		lifecycle.Transitions[state] = append([]EnvironmentState{}, next...)
+		if len(next) == 0 {
+			lifecycle.Final = append(lifecycle.Final, state)
+		}
+	}
+
+	for operation := range operationStates {
+		allowed := []EnvironmentState{}
+		for _, state := range environmentStates {
+			if state.Allows(operation) {
+				allowed = append(allowed, state)
+			}
+		}
+		lifecycle.Operations[operation] = allowed
+	}
+
+	return lifecycle
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// allowedTransitions lists every move between two different states the
+// lifecycle allows
+var allowedTransitions = map[[2]EnvironmentState]bool{
+	{StateCreating, StateProvisioning}: true,
+	{StateCreating, StateError}:        true,
+	{StateProvisioning, StateActive}:   true,
+	{StateProvisioning, StateError}:    true,
+	{StateActive, StateUpdating}:       true,
+	{StateActive, StateDeleting}:       true,
+	{StateUpdating, StateActive}:       true,
+	{StateUpdating, StateError}:        true,
+	{StateDeleting, StateDeleted}:      true,
+	{StateDeleting, StateError}:        true,
+	{StateError, StateUpdating}:        true,
+	{StateError, StateDeleting}:        true,
+}
+
+func TestCanTransition(t *testing.T) {
+	for _, from := range environmentStates {
+		for _, to := range environmentStates {
+			want := from == to || allowedTransitions[[2]EnvironmentState{from, to}]
+			if got := from.CanTransition(to); got != want {
+				t.Errorf("%s to %s: got %v, want %v", from, to, got, want)
+			}
+		}
+		if from.CanTransition("RUNNING") {
+			t.Errorf("%s to unknown state RUNNING allowed", from)
+		}
+	}
+}
+
+func TestTransition(t *testing.T) {
+	for _, from := range environmentStates {
+		for _, to := range environmentStates {
+			env := Environment{Status: from, StatusMessage: "before", FailureCategory: "THROTTLING"}
+			err := env.Transition(to, "after")
+
+			if from == to || allowedTransitions[[2]EnvironmentState{from, to}] {
+				if err != nil {
+					t.Errorf("%s to %s: %v", from, to, err)
+					continue
+				}
+				want := Environment{Status: to, StatusMessage: "after"}
+				if !reflect.DeepEqual(env, want) {
+					t.Errorf("%s to %s: got %+v, want %+v", from, to, env, want)
+				}
+				continue
+			}
+
+			if !errors.Is(err, ErrInvalidTransition) {
+				t.Errorf("%s to %s: got error %v, want ErrInvalidTransition", from, to, err)
+			}
+			want := Environment{Status: from, StatusMessage: "before", FailureCategory: "THROTTLING"}
+			if !reflect.DeepEqual(env, want) {
+				t.Errorf("refused %s to %s changed the environment to %+v", from, to, env)
+			}
+		}
+	}
+}
+
+func TestSources(t *testing.T) {
+	tests := []struct {
+		state EnvironmentState
+		want  []EnvironmentState
+	}{
+		{StateCreating, []EnvironmentState{StateCreating}},
+		{StateActive, []EnvironmentState{StateProvisioning, StateActive, StateUpdating}},
+		{StateUpdating, []EnvironmentState{StateActive, StateUpdating, StateError}},
+		{StateError, []EnvironmentState{StateCreating, StateProvisioning, StateUpdating, StateDeleting, StateError}},
+		{StateDeleted, []EnvironmentState{StateDeleting, StateDeleted}},
+		{"RUNNING", nil},
+	}
+	for _, tt := range tests {
+		if got := tt.state.Sources(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.state, got, tt.want)
+		}
+	}
+}
+
+func TestAllows(t *testing.T) {
+	changes := []EnvironmentState{StateActive, StateError}
+	tests := map[string][]EnvironmentState{
+		OperationUpdate:    changes,
+		OperationUpgrade:   changes,
+		OperationApply:     changes,
+		OperationReconcile: changes,
+		OperationDelete:    changes,
+		OperationCreate:    {},
+		"restart":          {},
+	}
+	for operation, want := range tests {
+		got := []EnvironmentState{}
+		for _, state := range environmentStates {
+			if state.Allows(operation) {
+				got = append(got, state)
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: allowed in %v, want %v", operation, got, want)
+		}
+	}
+}
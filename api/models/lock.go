@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+)
+
+// LockOperationPlan is the operation of a lock held while a plan is made at
+// a user's request. Locks held by jobs carry the job's type.
+const LockOperationPlan = "PLAN"
+
+// LockOperationCleanup is the operation of a lock held while the workspace
+// janitor removes an environment's files
+const LockOperationCleanup = "CLEANUP"
+
+// EnvironmentLock is a lease on an environment held by the one operation
+// allowed to work on it. The holder renews the lease with heartbeats; a lock
+// whose holder stops renewing it expires and may be taken by anyone.
+type EnvironmentLock struct {
+	EnvironmentID string `json:"environmentId"`
+
+	// Token identifies one acquisition of the lock. Only the holder is
+	// given it, so a holder whose lock was broken cannot renew or release
+	// the lock of whoever took it next. The API leaves it out.
+	Token string `json:"token,omitempty"`
+
+	// Owner is the worker process holding the lock, and Operation, JobID
+	// and UserID the work it holds it for
+	Owner     string `json:"owner"`
+	Operation string `json:"operation"`
+	JobID     string `json:"jobId,omitempty"`
+	UserID    string `json:"userId,omitempty"`
+
+	AcquiredAt  time.Time `json:"acquiredAt"`
+	HeartbeatAt time.Time `json:"heartbeatAt"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// Expired reports whether the lock's holder has stopped renewing it
+func (l EnvironmentLock) Expired(now time.Time) bool {
+	return !now.Before(l.ExpiresAt)
+}
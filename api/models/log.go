@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+)
+
+// Streams a log line can come from
+const (
+	LogStreamStdout = "stdout"
+	LogStreamStderr = "stderr"
+)
+
+// LogLine is one line of output from a Terraform command run for an
+// environment
+type LogLine struct {
+	EnvironmentID string `json:"environmentId"`
+
+	// Sequence orders the log lines of one environment
+	Sequence int64 `json:"sequence"`
+
+	// JobID is the background job whose command wrote the line
+	JobID string `json:"jobId,omitempty"`
+
+	// Phase is the Terraform subcommand that wrote the line, such as
+	// "init" or "apply"
+	Phase  string `json:"phase"`
+	Stream string `json:"stream"`
+	Text   string `json:"text"`
+
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"time"
+)
+
+// UsageGroup aggregates the usage of the environments sharing a group key
+type UsageGroup struct {
+	Key              string  `json:"key"`
+	EnvironmentCount int     `json:"environmentCount"`
+	EnvironmentHours float64 `json:"environmentHours"`
+	NodeHours        float64 `json:"nodeHours"`
+	CPUCores         float64 `json:"cpuCores"`
+	MemoryGiB        float64 `json:"memoryGiB"`
+	StorageGiB       float64 `json:"storageGiB"`
+}
+
+// UsageReport is the response of the usage metrics endpoint. Resource figures
+// are the ResourceLimits allocated to the environments, not measured usage.
+type UsageReport struct {
+	From    time.Time    `json:"from"`
+	To      time.Time    `json:"to"`
+	GroupBy string       `json:"groupBy"`
+	Groups  []UsageGroup `json:"groups"`
+	Total   UsageGroup   `json:"total"`
+}
+
+// CostGroup aggregates the estimated cost of the environments sharing a
+// group key
+type CostGroup struct {
+	Key            string             `json:"key"`
+	ClusterHours   float64            `json:"clusterHours"`
+	NodeHours      float64            `json:"nodeHours"`
+	ClusterCost    float64            `json:"clusterCost"`
+	NodeCost       float64            `json:"nodeCost"`
+	Cost           float64            `json:"cost"`
+	ByInstanceType map[string]float64 `json:"byInstanceType"`
+}
+
+// CostReport is the response of the cost metrics endpoint
+type CostReport struct {
+	From     time.Time   `json:"from"`
+	To       time.Time   `json:"to"`
+	GroupBy  string      `json:"groupBy"`
+	Currency string      `json:"currency"`
+	Groups   []CostGroup `json:"groups"`
+	Total    CostGroup   `json:"total"`
+
+	// UnpricedInstanceTypes lists instance types missing from the pricing
+	// table, whose node hours were counted at zero cost
+	UnpricedInstanceTypes []string `json:"unpricedInstanceTypes,omitempty"`
+}
+
+// WorkspaceDiskUsage is the disk space taken by one environment's workspace
+// and local state on the replica that measured it
+type WorkspaceDiskUsage struct {
+	EnvironmentID string `json:"environmentId"`
+
+	// Status is the environment's status, or "" for a workspace whose
+	// environment is not in the store
+	Status EnvironmentState `json:"status,omitempty"`
+
+	WorkspaceBytes int64     `json:"workspaceBytes"`
+	ProviderBytes  int64     `json:"providerBytes"`
+	PlanBytes      int64     `json:"planBytes"`
+	StateBytes     int64     `json:"stateBytes"`
+	ModifiedAt     time.Time `json:"modifiedAt"`
+}
+
+// WorkspaceReport is the response of the workspace metrics endpoint: the disk
+// used by Terraform workspaces and local state after the last janitor sweep,
+// and what the sweep reclaimed
+type WorkspaceReport struct {
+	MeasuredAt     time.Time `json:"measuredAt"`
+	WorkspaceBytes int64     `json:"workspaceBytes"`
+	StateBytes     int64     `json:"stateBytes"`
+	TotalBytes     int64     `json:"totalBytes"`
+
+	// ReclaimedBytes and RemovedWorkspaces count what the last sweep freed,
+	// and TotalReclaimedBytes what every sweep since the replica started
+	// freed
+	ReclaimedBytes      int64 `json:"reclaimedBytes"`
+	RemovedWorkspaces   int   `json:"removedWorkspaces"`
+	TotalReclaimedBytes int64 `json:"totalReclaimedBytes"`
+
+	Workspaces []WorkspaceDiskUsage `json:"workspaces"`
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+)
+
+// Plan is a saved Terraform plan of an environment and the changes it makes
+type Plan struct {
+	ID            string           `json:"id"`
+	EnvironmentID string           `json:"environmentId"`
+	Tool          string           `json:"tool"`
+	Summary       PlanSummary      `json:"summary"`
+	Changes       []ResourceChange `json:"resourceChanges"`
+	CreatedAt     time.Time        `json:"createdAt"`
+	ExpiresAt     time.Time        `json:"expiresAt"`
+
+	// VarsDigest identifies the variables the plan was made with
+	VarsDigest string `json:"varsDigest"`
+
+	// File is the plan file Terraform applies. It carries the variables and
+	// the prior state, so the API leaves it out.
+	File []byte `json:"-" dynamodbav:"-"`
+}
+
+// Expired reports whether the plan can no longer be applied
+func (p Plan) Expired(now time.Time) bool {
+	return !now.Before(p.ExpiresAt)
+}
+
+// PlanSummary counts a plan's changes the way Terraform reports them: a
+// replaced resource counts as one to add and one to destroy, and is also
+// counted in Replace
+type PlanSummary struct {
+	Add     int `json:"add"`
+	Change  int `json:"change"`
+	Destroy int `json:"destroy"`
+	Replace int `json:"replace"`
+}
+
+// ResourceChange is the change a plan makes to one managed resource
+type ResourceChange struct {
+	Address string `json:"address"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+
+	// Action is "create", "update", "delete" or "replace"
+	Action string `json:"action"`
+
+	// Reason says why Terraform chose the action, such as
+	// "replace_because_cannot_update"
+	Reason string `json:"reason,omitempty"`
+
+	// ReplacePaths are the attributes whose change forces a replacement,
+	// such as "scaling_config.0.max_size"
+	ReplacePaths []string `json:"replacePaths,omitempty"`
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// ClusterTemplate defines the cluster shape and guardrails environments are
+// provisioned from. Templates are versioned: every change is stored as a new,
+// immutable revision so that environments built from an earlier revision keep
+// re-applying exactly what they were created with.
+type ClusterTemplate struct {
+	ID                string         `json:"id"`
+	Revision          int64          `json:"revision"`
+	Name              string         `json:"name"`
+	Description       string         `json:"description"`
+	Region            string         `json:"region"`
+	InstanceTypes     []string       `json:"instanceTypes"`
+	MinNodes          int            `json:"minNodes"`
+	MaxNodes          int            `json:"maxNodes"`
+	DesiredNodes      int            `json:"desiredNodes"`
+	KubernetesVersion string         `json:"kubernetesVersion"`
+	VPCCIDR           string         `json:"vpcCidr"`
+	AllowedAddons     []string       `json:"allowedAddons"`
+	ResourceLimits    ResourceLimits `json:"resourceLimits"`
+	CreatedAt         time.Time      `json:"createdAt"`
+	UpdatedAt         time.Time      `json:"updatedAt"` // when this revision was created
+	DeletedAt         *time.Time     `json:"deletedAt,omitempty" dynamodbav:",omitempty"`
+
+	// Timeouts bounds the Terraform phases run for the template's
+	// environments
+	Timeouts TerraformTimeouts `json:"timeouts"`
+
+	// Provisioner is the tool the template's environments are provisioned
+	// with: terraform (the default), opentofu or terragrunt
+	Provisioner string `json:"provisioner,omitempty"`
+}
+
+// TemplateRequest is used when creating a new cluster template. ResourceLimits
+// are the ceilings environments created from the template may request.
+type TemplateRequest struct {
+	Name              string         `json:"name" validate:"required,min=3,max=63"`
+	Description       string         `json:"description" validate:"max=255"`
+	Region            string         `json:"region" validate:"required"`
+	InstanceTypes     []string       `json:"instanceTypes" validate:"required,min=1,dive,required"`
+	MinNodes          int            `json:"minNodes" validate:"required,gte=1"`
+	MaxNodes          int            `json:"maxNodes" validate:"required,gtefield=MinNodes"`
+	DesiredNodes      int            `json:"desiredNodes" validate:"required,gtefield=MinNodes,ltefield=MaxNodes"`
+	KubernetesVersion string         `json:"kubernetesVersion" validate:"required"`
+	VPCCIDR           string         `json:"vpcCidr" validate:"required,cidrv4"`
+	AllowedAddons     []string       `json:"allowedAddons"`
+	ResourceLimits    ResourceLimits `json:"resourceLimits" validate:"required"`
+
+	Timeouts    TerraformTimeouts `json:"timeouts"`
+	Provisioner string            `json:"provisioner" validate:"omitempty,oneof=terraform opentofu terragrunt"`
+}
+
+// TemplatePatch represents the template fields that can change in a new revision
+type TemplatePatch struct {
+	Description       *string         `json:"description" validate:"omitempty,max=255"`
+	InstanceTypes     []string        `json:"instanceTypes" validate:"omitempty,min=1,dive,required"`
+	MinNodes          *int            `json:"minNodes" validate:"omitempty,gte=1"`
+	MaxNodes          *int            `json:"maxNodes" validate:"omitempty,gte=1"`
+	DesiredNodes      *int            `json:"desiredNodes" validate:"omitempty,gte=1"`
+	KubernetesVersion *string         `json:"kubernetesVersion"`
+	AllowedAddons     []string        `json:"allowedAddons"`
+	ResourceLimits    *ResourceLimits `json:"resourceLimits"`
+
+	Timeouts    *TerraformTimeouts `json:"timeouts"`
+	Provisioner *string            `json:"provisioner" validate:"omitempty,oneof=terraform opentofu terragrunt"`
+}
+
+// TerraformTimeouts bounds each Terraform phase as a duration such as "45m".
+// Empty fields fall back to the server's defaults.
+type TerraformTimeouts struct {
+	Init    string `json:"init,omitempty"`
+	Plan    string `json:"plan,omitempty"`
+	Apply   string `json:"apply,omitempty"`
+	Destroy string `json:"destroy,omitempty"`
+}
+
+// Durations parses the timeouts that are set, keyed by Terraform subcommand.
+// It returns an error naming the first one that is not a positive duration.
+func (t TerraformTimeouts) Durations() (map[string]time.Duration, error) {
+	phases := []struct{ name, value string }{
+		{"init", t.Init},
+		{"plan", t.Plan},
+		{"apply", t.Apply},
+		{"destroy", t.Destroy},
+	}
+
+	durations := make(map[string]time.Duration)
+	for _, phase := range phases {
+		if phase.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(phase.value)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("timeouts.%s must be a positive duration such as \"30m\"", phase.name)
+		}
+		durations[phase.name] = duration
+	}
+	return durations, nil
+}
+
+// OutdatedEnvironment is an environment built from an older revision of its
+// template than the latest one
+type OutdatedEnvironment struct {
+	EnvironmentID    string `json:"environmentId"`
+	Name             string `json:"name"`
+	TemplateID       string `json:"templateId"`
+	TemplateRevision int64  `json:"templateRevision"`
+	LatestRevision   int64  `json:"latestRevision"`
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"time"
+)
+
+// Team membership roles. Members can use the team's environments;
+// maintainers can also manage the team and its membership.
+const (
+	TeamRoleMember     = "member"
+	TeamRoleMaintainer = "maintainer"
+)
+
+// User is a person known to the platform. The ID is the subject of the
+// user's OIDC tokens.
+type User struct {
+	ID        string     `json:"id"`
+	Email     string     `json:"email"`
+	Name      string     `json:"name"`
+	Version   int64      `json:"version"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty" dynamodbav:",omitempty"`
+}
+
+// UserRequest is used when registering a user
+type UserRequest struct {
+	ID    string `json:"id" validate:"required"`
+	Email string `json:"email" validate:"required,email"`
+	Name  string `json:"name" validate:"required,max=255"`
+}
+
+// UserPatch represents the user fields that can be updated
+type UserPatch struct {
+	Email *string `json:"email" validate:"omitempty,email"`
+	Name  *string `json:"name" validate:"omitempty,max=255"`
+}
+
+// TeamMember is a user's membership of a team
+type TeamMember struct {
+	UserID string `json:"userId" validate:"required"`
+	Role   string `json:"role" validate:"required,oneof=member maintainer"`
+}
+
+// Team is a group of users that can own environments together
+type Team struct {
+	ID          string       `json:"id"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Members     []TeamMember `json:"members"`
+	Version     int64        `json:"version"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	UpdatedAt   time.Time    `json:"updatedAt"`
+	DeletedAt   *time.Time   `json:"deletedAt,omitempty" dynamodbav:",omitempty"`
+}
+
+// TeamRequest is used when creating a team. The creator becomes its first
+// maintainer.
+type TeamRequest struct {
+	Name        string       `json:"name" validate:"required,min=3,max=63"`
+	Description string       `json:"description" validate:"max=255"`
+	Members     []TeamMember `json:"members" validate:"dive"`
+}
+
+// TeamPatch represents the team fields that can be updated
+type TeamPatch struct {
+	Name        *string `json:"name" validate:"omitempty,min=3,max=63"`
+	Description *string `json:"description" validate:"omitempty,max=255"`
+}
+
+// MembershipRequest sets a user's role in a team
+type MembershipRequest struct {
+	Role string `json:"role" validate:"required,oneof=member maintainer"`
+}
+
+// Role returns the user's role in the team, or "" if they are not a member
+func (t Team) Role(userID string) string {
+	for _, member := range t.Members {
+		if member.UserID == userID {
+			return member.Role
+		}
+	}
+	return ""
+}
@@ -0,0 +1,60 @@
+package pricing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Table holds the hourly prices used to estimate environment costs
+type Table struct {
+	// Currency is the ISO 4217 code the prices are expressed in
+	Currency string `json:"currency"`
+
+	// ClusterHourly is the flat hourly price of a cluster's control plane
+	ClusterHourly float64 `json:"clusterHourly"`
+
+	// InstanceTypes maps an instance type to its hourly on-demand price
+	InstanceTypes map[string]float64 `json:"instanceTypes"`
+}
+
+// Load reads a pricing table from a JSON file
+func Load(path string) (*Table, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pricing file: %w", err)
+	}
+
+	var table Table
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("failed to parse pricing file %s: %w", path, err)
+	}
+	if table.Currency == "" {
+		table.Currency = "USD"
+	}
+	for instanceType, price := range table.InstanceTypes {
+		if price < 0 {
+			return nil, fmt.Errorf("pricing file %s has a negative price for %s", path, instanceType)
+		}
+	}
+
+	return &table, nil
+}
+
+// LoadOrEmpty loads a pricing table, falling back to an empty table that
+// prices everything at zero if the file does not exist
+func LoadOrEmpty(path string) (*Table, error) {
+	table, err := Load(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Table{Currency: "USD", InstanceTypes: map[string]float64{}}, nil
+	}
+	return table, err
+}
+
+// InstanceHourly returns the hourly price of an instance type and whether the
+// table knows it
+func (t *Table) InstanceHourly(instanceType string) (float64, bool) {
+	price, ok := t.InstanceTypes[instanceType]
+	return price, ok
+}
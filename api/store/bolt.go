@@ -0,0 +1,166 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+var environmentsBucket = []byte("environments")
+
+// OpenBolt opens (or creates) the embedded database file used by the Bolt stores
+func OpenBolt(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt database: %w", err)
+	}
+	return db, nil
+}
+
+// BoltEnvironmentStore stores environments in an embedded BoltDB file
+type BoltEnvironmentStore struct {
+	db *bolt.DB
+}
+
+// NewBoltEnvironmentStore creates a new Bolt-backed environment store
+func NewBoltEnvironmentStore(db *bolt.DB) (*BoltEnvironmentStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(environmentsBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create environments bucket: %w", err)
+	}
+
+	return &BoltEnvironmentStore{db: db}, nil
+}
+
+// Get returns the environment with the given ID
+func (s *BoltEnvironmentStore) Get(ctx context.Context, envID string) (models.Environment, error) {
+	var environment models.Environment
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(environmentsBucket).Get([]byte(envID))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &environment)
+	})
+
+	return environment, err
+}
+
+// List returns a page of environments matching the filter
+func (s *BoltEnvironmentStore) List(ctx context.Context, filter EnvironmentFilter, page PageRequest) (models.EnvironmentList, error) {
+	environments := []models.Environment{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(environmentsBucket).ForEach(func(_, data []byte) error {
+			var environment models.Environment
+			if err := json.Unmarshal(data, &environment); err != nil {
+				return err
+			}
+
+			if environment.DeletedAt != nil && !filter.IncludeDeleted {
+				return nil
+			}
+			if !filter.matchesOwner(environment) {
+				return nil
+			}
+			if filter.Status != "" && environment.Status != filter.Status {
+				return nil
+			}
+
+			environments = append(environments, environment)
+			return nil
+		})
+	})
+	if err != nil {
+		return models.EnvironmentList{}, fmt.Errorf("failed to list environments: %w", err)
+	}
+
+	return paginate(environments, page)
+}
+
+// Create stores a new environment
+func (s *BoltEnvironmentStore) Create(ctx context.Context, env *models.Environment) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(environmentsBucket).Get([]byte(env.ID)) != nil {
+			return ErrVersionConflict
+		}
+		env.Version = 1
+		return putEnvironment(tx, *env)
+	})
+}
+
+// Update replaces an existing environment if its version is unchanged
+func (s *BoltEnvironmentStore) Update(ctx context.Context, env *models.Environment) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket(environmentsBucket).Get([]byte(env.ID))
+		if data == nil {
+			return ErrNotFound
+		}
+
+		var stored models.Environment
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return err
+		}
+		if stored.Version != env.Version {
+			return ErrVersionConflict
+		}
+
+		next := *env
+		next.Version++
+		if err := putEnvironment(tx, next); err != nil {
+			return err
+		}
+
+		env.Version = next.Version
+		return nil
+	})
+}
+
+// SoftDelete marks an environment as deleted
+func (s *BoltEnvironmentStore) SoftDelete(ctx context.Context, env *models.Environment) error {
+	now := time.Now().UTC()
+	env.UpdatedAt = now
+	env.DeletedAt = &now
+
+	return s.Update(ctx, env)
+}
+
+// UpdateStatus moves an environment to a new status if its lifecycle allows
+func (s *BoltEnvironmentStore) UpdateStatus(ctx context.Context, envID string, status models.EnvironmentState, message string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket(environmentsBucket).Get([]byte(envID))
+		if data == nil {
+			return ErrNotFound
+		}
+
+		var environment models.Environment
+		if err := json.Unmarshal(data, &environment); err != nil {
+			return err
+		}
+
+		if err := environment.Transition(status, message); err != nil {
+			return err
+		}
+		environment.UpdatedAt = time.Now().UTC()
+		environment.Version++
+
+		return putEnvironment(tx, environment)
+	})
+}
+
+// putEnvironment writes an environment into the environments bucket
+func putEnvironment(tx *bolt.Tx, env models.Environment) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return fmt.Errorf("failed to marshal environment: %w", err)
+	}
+	return tx.Bucket(environmentsBucket).Put([]byte(env.ID), data)
+}
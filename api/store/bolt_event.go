@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+// eventsBucket holds one nested bucket per environment, keyed by event
+// sequence so that a cursor walks the timeline in order
+var eventsBucket = []byte("events")
+
+// BoltEventStore stores environment events in an embedded BoltDB file
+type BoltEventStore struct {
+	db *bolt.DB
+}
+
+// NewBoltEventStore creates a new Bolt-backed event store
+func NewBoltEventStore(db *bolt.DB) (*BoltEventStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(eventsBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create events bucket: %w", err)
+	}
+
+	return &BoltEventStore{db: db}, nil
+}
+
+// Append stores a new event at the next sequence of its environment
+func (s *BoltEventStore) Append(ctx context.Context, event *models.EnvironmentEvent) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		events, err := tx.Bucket(eventsBucket).CreateBucketIfNotExists([]byte(event.EnvironmentID))
+		if err != nil {
+			return fmt.Errorf("failed to create event bucket: %w", err)
+		}
+
+		sequence, err := events.NextSequence()
+		if err != nil {
+			return err
+		}
+		event.Sequence = int64(sequence)
+
+		data, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to marshal event: %w", err)
+		}
+		return events.Put(revisionKey(event.Sequence), data)
+	})
+}
+
+// List returns the events of an environment that match the filter in order
+func (s *BoltEventStore) List(ctx context.Context, envID string, filter EventFilter) ([]models.EnvironmentEvent, error) {
+	events := []models.EnvironmentEvent{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(eventsBucket).Bucket([]byte(envID))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(_, data []byte) error {
+			var event models.EnvironmentEvent
+			if err := json.Unmarshal(data, &event); err != nil {
+				return err
+			}
+			if filter.matches(event) {
+				events = append(events, event)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events: %w", err)
+	}
+
+	return events, nil
+}
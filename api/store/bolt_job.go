@@ -0,0 +1,118 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+var jobsBucket = []byte("jobs")
+
+// BoltJobStore stores jobs in the same embedded BoltDB file as environments
+type BoltJobStore struct {
+	db *bolt.DB
+}
+
+// NewBoltJobStore creates a new Bolt-backed job store
+func NewBoltJobStore(db *bolt.DB) (*BoltJobStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(jobsBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create jobs bucket: %w", err)
+	}
+
+	return &BoltJobStore{db: db}, nil
+}
+
+// Get returns the job with the given ID
+func (s *BoltJobStore) Get(ctx context.Context, jobID string) (models.Job, error) {
+	var job models.Job
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(jobsBucket).Get([]byte(jobID))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &job)
+	})
+
+	return job, err
+}
+
+// List returns the jobs that match the filter, oldest first
+func (s *BoltJobStore) List(ctx context.Context, filter JobFilter) ([]models.Job, error) {
+	jobs := []models.Job{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(_, data []byte) error {
+			var job models.Job
+			if err := json.Unmarshal(data, &job); err != nil {
+				return err
+			}
+			if filter.matches(job) {
+				jobs = append(jobs, job)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+
+	sortJobs(jobs)
+	return jobs, nil
+}
+
+// Create stores a new job
+func (s *BoltJobStore) Create(ctx context.Context, job *models.Job) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(jobsBucket).Get([]byte(job.ID)) != nil {
+			return ErrVersionConflict
+		}
+		job.Version = 1
+		return putRecord(tx, jobsBucket, job.ID, *job)
+	})
+}
+
+// Update replaces an existing job if its version is unchanged
+func (s *BoltJobStore) Update(ctx context.Context, job *models.Job) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket(jobsBucket).Get([]byte(job.ID))
+		if data == nil {
+			return ErrNotFound
+		}
+
+		var stored models.Job
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return err
+		}
+		if stored.Version != job.Version {
+			return ErrVersionConflict
+		}
+
+		next := *job
+		next.Version++
+		if err := putRecord(tx, jobsBucket, next.ID, next); err != nil {
+			return err
+		}
+
+		job.Version = next.Version
+		return nil
+	})
+}
+
+// sortJobs orders jobs by creation time, then ID
+func sortJobs(jobs []models.Job) {
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].CreatedAt.Equal(jobs[j].CreatedAt) {
+			return jobs[i].ID < jobs[j].ID
+		}
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+var locksBucket = []byte("environment_locks")
+
+// BoltLockStore stores environment locks in the same embedded BoltDB file as
+// environments. Bolt serializes write transactions, so each check-and-write
+// below is atomic.
+type BoltLockStore struct {
+	db *bolt.DB
+}
+
+// NewBoltLockStore creates a new Bolt-backed lock store
+func NewBoltLockStore(db *bolt.DB) (*BoltLockStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(locksBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create environment locks bucket: %w", err)
+	}
+
+	return &BoltLockStore{db: db}, nil
+}
+
+// Get returns the lock of an environment
+func (s *BoltLockStore) Get(ctx context.Context, envID string) (models.EnvironmentLock, error) {
+	var lock models.EnvironmentLock
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		lock, err = getLock(tx, envID)
+		return err
+	})
+	return lock, err
+}
+
+// Acquire stores lock unless another operation holds the environment
+func (s *BoltLockStore) Acquire(ctx context.Context, lock models.EnvironmentLock) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		held, err := getLock(tx, lock.EnvironmentID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if err == nil && !held.Expired(time.Now().UTC()) && !sameJob(held, lock) {
+			return ErrLocked
+		}
+		return putRecord(tx, locksBucket, lock.EnvironmentID, lock)
+	})
+}
+
+// Renew replaces the environment's lock if it still holds lock.Token
+func (s *BoltLockStore) Renew(ctx context.Context, lock models.EnvironmentLock) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		held, err := getLock(tx, lock.EnvironmentID)
+		if errors.Is(err, ErrNotFound) {
+			return ErrLockLost
+		}
+		if err != nil {
+			return err
+		}
+		if held.Token != lock.Token {
+			return ErrLockLost
+		}
+		return putRecord(tx, locksBucket, lock.EnvironmentID, lock)
+	})
+}
+
+// Release deletes the environment's lock if it still holds token
+func (s *BoltLockStore) Release(ctx context.Context, envID, token string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		held, err := getLock(tx, envID)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if held.Token != token {
+			return nil
+		}
+		return tx.Bucket(locksBucket).Delete([]byte(envID))
+	})
+}
+
+// Break deletes the environment's lock whoever holds it
+func (s *BoltLockStore) Break(ctx context.Context, envID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if _, err := getLock(tx, envID); err != nil {
+			return err
+		}
+		return tx.Bucket(locksBucket).Delete([]byte(envID))
+	})
+}
+
+// getLock reads the lock of an environment within a transaction
+func getLock(tx *bolt.Tx, envID string) (models.EnvironmentLock, error) {
+	var lock models.EnvironmentLock
+	data := tx.Bucket(locksBucket).Get([]byte(envID))
+	if data == nil {
+		return lock, ErrNotFound
+	}
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return lock, fmt.Errorf("failed to unmarshal environment lock: %w", err)
+	}
+	return lock, nil
+}
+
+// sameJob reports whether two locks are held for the same job
+func sameJob(held, lock models.EnvironmentLock) bool {
+	return lock.JobID != "" && held.JobID == lock.JobID
+}
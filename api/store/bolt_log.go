@@ -0,0 +1,86 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+// logsBucket holds one nested bucket per environment, keyed by line sequence
+var logsBucket = []byte("logs")
+
+// BoltLogStore stores environment logs in an embedded BoltDB file
+type BoltLogStore struct {
+	db *bolt.DB
+}
+
+// NewBoltLogStore creates a new Bolt-backed log store
+func NewBoltLogStore(db *bolt.DB) (*BoltLogStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(logsBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create logs bucket: %w", err)
+	}
+
+	return &BoltLogStore{db: db}, nil
+}
+
+// Append stores a new log line at the next sequence of its environment
+func (s *BoltLogStore) Append(ctx context.Context, line *models.LogLine) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		lines, err := tx.Bucket(logsBucket).CreateBucketIfNotExists([]byte(line.EnvironmentID))
+		if err != nil {
+			return fmt.Errorf("failed to create log bucket: %w", err)
+		}
+
+		sequence, err := lines.NextSequence()
+		if err != nil {
+			return err
+		}
+		line.Sequence = int64(sequence)
+
+		data, err := json.Marshal(line)
+		if err != nil {
+			return fmt.Errorf("failed to marshal log line: %w", err)
+		}
+		return lines.Put(revisionKey(line.Sequence), data)
+	})
+}
+
+// List returns the log lines of an environment that match the filter in order
+func (s *BoltLogStore) List(ctx context.Context, envID string, filter LogFilter) ([]models.LogLine, error) {
+	lines := []models.LogLine{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(logsBucket).Bucket([]byte(envID))
+		if bucket == nil {
+			return nil
+		}
+
+		cursor := bucket.Cursor()
+		for key, data := cursor.Seek(revisionKey(filter.AfterSequence + 1)); key != nil; key, data = cursor.Next() {
+			var line models.LogLine
+			if err := json.Unmarshal(data, &line); err != nil {
+				return err
+			}
+			if !filter.matches(line) {
+				continue
+			}
+			lines = append(lines, line)
+			if filter.Limit > 0 && len(lines) == filter.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list log lines: %w", err)
+	}
+
+	return lines, nil
+}
@@ -0,0 +1,142 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+// plansBucket holds one nested bucket per environment, keyed by plan ID. Each
+// plan's file is kept next to it under planFileKey.
+var plansBucket = []byte("plans")
+
+// planFileSuffix ends the keys of plan files
+const planFileSuffix = ".tfplan"
+
+// BoltPlanStore stores saved plans in an embedded BoltDB file
+type BoltPlanStore struct {
+	db *bolt.DB
+}
+
+// NewBoltPlanStore creates a new Bolt-backed plan store
+func NewBoltPlanStore(db *bolt.DB) (*BoltPlanStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(plansBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create plans bucket: %w", err)
+	}
+
+	return &BoltPlanStore{db: db}, nil
+}
+
+// Get returns a plan of an environment that has not expired
+func (s *BoltPlanStore) Get(ctx context.Context, envID, planID string) (models.Plan, error) {
+	var plan models.Plan
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		plans := tx.Bucket(plansBucket).Bucket([]byte(envID))
+		if plans == nil {
+			return ErrNotFound
+		}
+		data := plans.Get([]byte(planID))
+		file := plans.Get(planFileKey(planID))
+		if data == nil || file == nil {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(data, &plan); err != nil {
+			return fmt.Errorf("failed to unmarshal plan: %w", err)
+		}
+		plan.File = append([]byte(nil), file...)
+		return nil
+	})
+	if err != nil {
+		return models.Plan{}, err
+	}
+	if plan.Expired(time.Now()) {
+		return models.Plan{}, ErrNotFound
+	}
+
+	return plan, nil
+}
+
+// Create stores a new plan, removing the environment's expired plans
+func (s *BoltPlanStore) Create(ctx context.Context, plan *models.Plan) error {
+	data, err := json.Marshal(plan)
+	if err != nil {
+		return fmt.Errorf("failed to marshal plan: %w", err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		plans, err := tx.Bucket(plansBucket).CreateBucketIfNotExists([]byte(plan.EnvironmentID))
+		if err != nil {
+			return fmt.Errorf("failed to create plan bucket: %w", err)
+		}
+
+		if err := removeExpiredPlans(plans, time.Now()); err != nil {
+			return err
+		}
+
+		if err := plans.Put([]byte(plan.ID), data); err != nil {
+			return err
+		}
+		return plans.Put(planFileKey(plan.ID), plan.File)
+	})
+}
+
+// Delete removes a plan and its file
+func (s *BoltPlanStore) Delete(ctx context.Context, envID, planID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		plans := tx.Bucket(plansBucket).Bucket([]byte(envID))
+		if plans == nil {
+			return nil
+		}
+		if err := plans.Delete([]byte(planID)); err != nil {
+			return err
+		}
+		return plans.Delete(planFileKey(planID))
+	})
+}
+
+// removeExpiredPlans deletes the plans in an environment's bucket that have
+// expired, with their files
+func removeExpiredPlans(plans *bolt.Bucket, now time.Time) error {
+	var expired []string
+	err := plans.ForEach(func(key, data []byte) error {
+		if bytes.HasSuffix(key, []byte(planFileSuffix)) {
+			return nil
+		}
+		var plan models.Plan
+		if err := json.Unmarshal(data, &plan); err != nil {
+			return fmt.Errorf("failed to unmarshal plan: %w", err)
+		}
+		if plan.Expired(now) {
+			expired = append(expired, plan.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, planID := range expired {
+		if err := plans.Delete([]byte(planID)); err != nil {
+			return err
+		}
+		if err := plans.Delete(planFileKey(planID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// planFileKey returns the key of a plan's file in its environment's bucket
+func planFileKey(planID string) []byte {
+	return []byte(planID + planFileSuffix)
+}
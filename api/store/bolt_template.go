@@ -0,0 +1,250 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+// templatesBucket holds the latest revision of each template by ID;
+// templateRevisionsBucket holds one nested bucket of revisions per template
+var (
+	templatesBucket         = []byte("templates")
+	templateRevisionsBucket = []byte("template_revisions")
+)
+
+// BoltTemplateStore stores cluster templates in an embedded BoltDB file
+type BoltTemplateStore struct {
+	db *bolt.DB
+}
+
+// NewBoltTemplateStore creates a new Bolt-backed template store
+func NewBoltTemplateStore(db *bolt.DB) (*BoltTemplateStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(templatesBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(templateRevisionsBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create templates buckets: %w", err)
+	}
+
+	return &BoltTemplateStore{db: db}, nil
+}
+
+// Get returns the latest revision of the template with the given ID
+func (s *BoltTemplateStore) Get(ctx context.Context, templateID string) (models.ClusterTemplate, error) {
+	var template models.ClusterTemplate
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(templatesBucket).Get([]byte(templateID))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &template)
+	})
+
+	return template, err
+}
+
+// GetRevision returns one revision of a template
+func (s *BoltTemplateStore) GetRevision(ctx context.Context, templateID string, revision int64) (models.ClusterTemplate, error) {
+	var template models.ClusterTemplate
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		revisions := tx.Bucket(templateRevisionsBucket).Bucket([]byte(templateID))
+		if revisions == nil {
+			return ErrNotFound
+		}
+		data := revisions.Get(revisionKey(revision))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &template)
+	})
+
+	return template, err
+}
+
+// ListRevisions returns every revision of a template, oldest first
+func (s *BoltTemplateStore) ListRevisions(ctx context.Context, templateID string) ([]models.ClusterTemplate, error) {
+	templates := []models.ClusterTemplate{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		revisions := tx.Bucket(templateRevisionsBucket).Bucket([]byte(templateID))
+		if revisions == nil {
+			return ErrNotFound
+		}
+
+		// Keys are big-endian revision numbers, so iteration is in order
+		return revisions.ForEach(func(_, data []byte) error {
+			var template models.ClusterTemplate
+			if err := json.Unmarshal(data, &template); err != nil {
+				return err
+			}
+			templates = append(templates, template)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return templates, nil
+}
+
+// List returns the latest revision of every non-deleted template sorted by name
+func (s *BoltTemplateStore) List(ctx context.Context) ([]models.ClusterTemplate, error) {
+	templates := []models.ClusterTemplate{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(templatesBucket).ForEach(func(_, data []byte) error {
+			var template models.ClusterTemplate
+			if err := json.Unmarshal(data, &template); err != nil {
+				return err
+			}
+			if template.DeletedAt == nil {
+				templates = append(templates, template)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+
+	sortTemplates(templates)
+	return templates, nil
+}
+
+// Create stores a new template as revision 1
+func (s *BoltTemplateStore) Create(ctx context.Context, template *models.ClusterTemplate) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(templatesBucket).Get([]byte(template.ID)) != nil {
+			return ErrVersionConflict
+		}
+
+		next := *template
+		next.Revision = 1
+		if err := putTemplateRevision(tx, next); err != nil {
+			return err
+		}
+
+		template.Revision = next.Revision
+		return nil
+	})
+}
+
+// CreateRevision stores the template as its next revision
+func (s *BoltTemplateStore) CreateRevision(ctx context.Context, template *models.ClusterTemplate) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		latest, err := getLatestTemplate(tx, template.ID)
+		if err != nil {
+			return err
+		}
+		if latest.Revision != template.Revision || latest.DeletedAt != nil {
+			return ErrVersionConflict
+		}
+
+		next := *template
+		next.Revision++
+		if err := putTemplateRevision(tx, next); err != nil {
+			return err
+		}
+
+		template.Revision = next.Revision
+		return nil
+	})
+}
+
+// SoftDelete marks a template as deleted
+func (s *BoltTemplateStore) SoftDelete(ctx context.Context, template *models.ClusterTemplate) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		latest, err := getLatestTemplate(tx, template.ID)
+		if err != nil {
+			return err
+		}
+		if latest.Revision != template.Revision {
+			return ErrVersionConflict
+		}
+
+		now := time.Now().UTC()
+		latest.DeletedAt = &now
+		if err := putTemplate(tx, latest); err != nil {
+			return err
+		}
+
+		template.DeletedAt = latest.DeletedAt
+		return nil
+	})
+}
+
+// getLatestTemplate reads the latest revision of a template
+func getLatestTemplate(tx *bolt.Tx, templateID string) (models.ClusterTemplate, error) {
+	var template models.ClusterTemplate
+
+	data := tx.Bucket(templatesBucket).Get([]byte(templateID))
+	if data == nil {
+		return template, ErrNotFound
+	}
+	err := json.Unmarshal(data, &template)
+	return template, err
+}
+
+// putTemplateRevision writes a new immutable revision and makes it the
+// template's latest
+func putTemplateRevision(tx *bolt.Tx, template models.ClusterTemplate) error {
+	revisions, err := tx.Bucket(templateRevisionsBucket).CreateBucketIfNotExists([]byte(template.ID))
+	if err != nil {
+		return err
+	}
+
+	key := revisionKey(template.Revision)
+	if revisions.Get(key) != nil {
+		return ErrVersionConflict
+	}
+
+	data, err := json.Marshal(template)
+	if err != nil {
+		return fmt.Errorf("failed to marshal template: %w", err)
+	}
+	if err := revisions.Put(key, data); err != nil {
+		return err
+	}
+
+	return putTemplate(tx, template)
+}
+
+// putTemplate writes a template into the templates bucket
+func putTemplate(tx *bolt.Tx, template models.ClusterTemplate) error {
+	data, err := json.Marshal(template)
+	if err != nil {
+		return fmt.Errorf("failed to marshal template: %w", err)
+	}
+	return tx.Bucket(templatesBucket).Put([]byte(template.ID), data)
+}
+
+// revisionKey encodes a revision number so that keys sort numerically
+func revisionKey(revision int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(revision))
+	return key
+}
+
+// sortTemplates orders templates by name, then ID
+func sortTemplates(templates []models.ClusterTemplate) {
+	sort.Slice(templates, func(i, j int) bool {
+		if templates[i].Name == templates[j].Name {
+			return templates[i].ID < templates[j].ID
+		}
+		return templates[i].Name < templates[j].Name
+	})
+}
@@ -0,0 +1,274 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	usersBucket = []byte("users")
+	teamsBucket = []byte("teams")
+)
+
+// BoltUserStore stores users in an embedded BoltDB file
+type BoltUserStore struct {
+	db *bolt.DB
+}
+
+// NewBoltUserStore creates a new Bolt-backed user store
+func NewBoltUserStore(db *bolt.DB) (*BoltUserStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(usersBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create users bucket: %w", err)
+	}
+
+	return &BoltUserStore{db: db}, nil
+}
+
+// Get returns the user with the given ID
+func (s *BoltUserStore) Get(ctx context.Context, userID string) (models.User, error) {
+	var user models.User
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(usersBucket).Get([]byte(userID))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &user)
+	})
+
+	return user, err
+}
+
+// List returns all non-deleted users sorted by name
+func (s *BoltUserStore) List(ctx context.Context) ([]models.User, error) {
+	users := []models.User{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(usersBucket).ForEach(func(_, data []byte) error {
+			var user models.User
+			if err := json.Unmarshal(data, &user); err != nil {
+				return err
+			}
+			if user.DeletedAt == nil {
+				users = append(users, user)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	sortUsers(users)
+	return users, nil
+}
+
+// Create stores a new user
+func (s *BoltUserStore) Create(ctx context.Context, user *models.User) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(usersBucket).Get([]byte(user.ID)) != nil {
+			return ErrVersionConflict
+		}
+		user.Version = 1
+		return putRecord(tx, usersBucket, user.ID, *user)
+	})
+}
+
+// Update replaces an existing user if its version is unchanged
+func (s *BoltUserStore) Update(ctx context.Context, user *models.User) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket(usersBucket).Get([]byte(user.ID))
+		if data == nil {
+			return ErrNotFound
+		}
+
+		var stored models.User
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return err
+		}
+		if stored.Version != user.Version {
+			return ErrVersionConflict
+		}
+
+		next := *user
+		next.Version++
+		if err := putRecord(tx, usersBucket, next.ID, next); err != nil {
+			return err
+		}
+
+		user.Version = next.Version
+		return nil
+	})
+}
+
+// SoftDelete marks a user as deleted
+func (s *BoltUserStore) SoftDelete(ctx context.Context, user *models.User) error {
+	now := time.Now().UTC()
+	user.UpdatedAt = now
+	user.DeletedAt = &now
+
+	return s.Update(ctx, user)
+}
+
+// BoltTeamStore stores teams in an embedded BoltDB file
+type BoltTeamStore struct {
+	db *bolt.DB
+}
+
+// NewBoltTeamStore creates a new Bolt-backed team store
+func NewBoltTeamStore(db *bolt.DB) (*BoltTeamStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(teamsBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create teams bucket: %w", err)
+	}
+
+	return &BoltTeamStore{db: db}, nil
+}
+
+// Get returns the team with the given ID
+func (s *BoltTeamStore) Get(ctx context.Context, teamID string) (models.Team, error) {
+	var team models.Team
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(teamsBucket).Get([]byte(teamID))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &team)
+	})
+
+	return team, err
+}
+
+// List returns all non-deleted teams sorted by name
+func (s *BoltTeamStore) List(ctx context.Context) ([]models.Team, error) {
+	teams := []models.Team{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(teamsBucket).ForEach(func(_, data []byte) error {
+			var team models.Team
+			if err := json.Unmarshal(data, &team); err != nil {
+				return err
+			}
+			if team.DeletedAt == nil {
+				teams = append(teams, team)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list teams: %w", err)
+	}
+
+	sortTeams(teams)
+	return teams, nil
+}
+
+// ListForUser returns the non-deleted teams the user is a member of
+func (s *BoltTeamStore) ListForUser(ctx context.Context, userID string) ([]models.Team, error) {
+	teams, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return teamsWithMember(teams, userID), nil
+}
+
+// Create stores a new team
+func (s *BoltTeamStore) Create(ctx context.Context, team *models.Team) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(teamsBucket).Get([]byte(team.ID)) != nil {
+			return ErrVersionConflict
+		}
+		team.Version = 1
+		return putRecord(tx, teamsBucket, team.ID, *team)
+	})
+}
+
+// Update replaces an existing team if its version is unchanged
+func (s *BoltTeamStore) Update(ctx context.Context, team *models.Team) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket(teamsBucket).Get([]byte(team.ID))
+		if data == nil {
+			return ErrNotFound
+		}
+
+		var stored models.Team
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return err
+		}
+		if stored.Version != team.Version {
+			return ErrVersionConflict
+		}
+
+		next := *team
+		next.Version++
+		if err := putRecord(tx, teamsBucket, next.ID, next); err != nil {
+			return err
+		}
+
+		team.Version = next.Version
+		return nil
+	})
+}
+
+// SoftDelete marks a team as deleted
+func (s *BoltTeamStore) SoftDelete(ctx context.Context, team *models.Team) error {
+	now := time.Now().UTC()
+	team.UpdatedAt = now
+	team.DeletedAt = &now
+
+	return s.Update(ctx, team)
+}
+
+// putRecord writes a JSON-encoded record into a bucket
+func putRecord(tx *bolt.Tx, bucket []byte, id string, record interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal record: %w", err)
+	}
+	return tx.Bucket(bucket).Put([]byte(id), data)
+}
+
+// sortUsers orders users by name, then ID
+func sortUsers(users []models.User) {
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Name == users[j].Name {
+			return users[i].ID < users[j].ID
+		}
+		return users[i].Name < users[j].Name
+	})
+}
+
+// sortTeams orders teams by name, then ID
+func sortTeams(teams []models.Team) {
+	sort.Slice(teams, func(i, j int) bool {
+		if teams[i].Name == teams[j].Name {
+			return teams[i].ID < teams[j].ID
+		}
+		return teams[i].Name < teams[j].Name
+	})
+}
+
+// teamsWithMember returns the teams that include the user
+func teamsWithMember(teams []models.Team, userID string) []models.Team {
+	matching := []models.Team{}
+	for _, team := range teams {
+		if team.Role(userID) != "" {
+			matching = append(matching, team)
+		}
+	}
+	return matching
+}
@@ -0,0 +1,558 @@
+package store
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+)
+
+// The environments table has a global secondary index for every attribute
+// List partitions environments by and every sort field. The range key of
+// each index is an attribute written with the environment that orders it by
+// the field.
+var (
+	partitionAttributes = []string{"UserID", "TeamID", "Status"}
+	sortFields          = []string{SortByCreatedAt, SortByName, SortByStatus}
+	sortKeyAttributes   = map[string]string{
+		SortByCreatedAt: "CreatedAtSortKey",
+		SortByName:      "NameSortKey",
+		SortByStatus:    "StatusSortKey",
+	}
+)
+
+// DynamoDBEnvironmentStore stores environments in a DynamoDB table
+type DynamoDBEnvironmentStore struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+// NewDynamoDBEnvironmentStore creates a new DynamoDB-backed environment store
+func NewDynamoDBEnvironmentStore(client *dynamodb.Client, tableName string) *DynamoDBEnvironmentStore {
+	return &DynamoDBEnvironmentStore{
+		client:    client,
+		tableName: tableName,
+	}
+}
+
+// EnsureTable creates the environments table and its user, team and status
+// indexes if they are missing, and adds the sort keys to environments stored
+// before they were introduced
+func (s *DynamoDBEnvironmentStore) EnsureTable(ctx context.Context) error {
+	var indexes []indexSpec
+	for _, attribute := range partitionAttributes {
+		for _, field := range sortFields {
+			indexes = append(indexes, indexSpec{
+				Name:     environmentIndexName(attribute, field),
+				HashKey:  attribute,
+				RangeKey: sortKeyAttributes[field],
+			})
+		}
+	}
+
+	err := ensureTable(ctx, s.client, tableSpec{
+		Name:    s.tableName,
+		HashKey: "ID",
+		Indexes: indexes,
+	})
+	if err != nil {
+		return err
+	}
+	return s.addSortKeys(ctx)
+}
+
+// addSortKeys writes the sort keys of the environments that have none, which
+// the indexes would otherwise leave out
+func (s *DynamoDBEnvironmentStore) addSortKeys(ctx context.Context) error {
+	input := &dynamodb.ScanInput{
+		TableName:                aws.String(s.tableName),
+		FilterExpression:         aws.String("attribute_not_exists(#sortKey)"),
+		ExpressionAttributeNames: map[string]string{"#sortKey": sortKeyAttributes[SortByCreatedAt]},
+	}
+	for {
+		result, err := s.client.Scan(ctx, input)
+		if err != nil {
+			return fmt.Errorf("failed to scan environments: %w", err)
+		}
+
+		for _, item := range result.Items {
+			var environment models.Environment
+			if err := attributevalue.UnmarshalMap(item, &environment); err != nil {
+				return fmt.Errorf("failed to unmarshal environment: %w", err)
+			}
+
+			// Read the environment again if it changed since the scan
+			for {
+				err := s.setSortKeys(ctx, environment)
+				if !errors.Is(err, ErrVersionConflict) {
+					if err != nil {
+						return err
+					}
+					break
+				}
+				if environment, err = s.Get(ctx, environment.ID); err != nil {
+					return err
+				}
+			}
+		}
+
+		if result.LastEvaluatedKey == nil {
+			return nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// setSortKeys writes the sort keys of an environment if its version is
+// unchanged, without changing the version
+func (s *DynamoDBEnvironmentStore) setSortKeys(ctx context.Context, env models.Environment) error {
+	expressionAttributeValues := map[string]types.AttributeValue{}
+	var assignments []string
+	for i, field := range sortFields {
+		name := fmt.Sprintf(":key%d", i)
+		assignments = append(assignments, sortKeyAttributes[field]+" = "+name)
+		expressionAttributeValues[name] = &types.AttributeValueMemberS{Value: indexSortKey(env, field)}
+	}
+
+	// Items written before versioning was introduced have no Version attribute
+	condition := "attribute_exists(ID) AND #version = :expected"
+	if env.Version == 0 {
+		condition = "attribute_exists(ID) AND (attribute_not_exists(#version) OR #version = :expected)"
+	}
+	expressionAttributeValues[":expected"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(env.Version, 10)}
+
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(s.tableName),
+		Key:                       environmentKey(env.ID),
+		ConditionExpression:       aws.String(condition),
+		UpdateExpression:          aws.String("SET " + strings.Join(assignments, ", ")),
+		ExpressionAttributeNames:  map[string]string{"#version": "Version"},
+		ExpressionAttributeValues: expressionAttributeValues,
+	})
+	if err != nil {
+		return translateConditionError(err, ErrVersionConflict, "failed to save environment sort keys")
+	}
+	return nil
+}
+
+// Get returns the environment with the given ID
+func (s *DynamoDBEnvironmentStore) Get(ctx context.Context, envID string) (models.Environment, error) {
+	var environment models.Environment
+
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key:       environmentKey(envID),
+	})
+	if err != nil {
+		return environment, fmt.Errorf("failed to get environment: %w", err)
+	}
+
+	if result.Item == nil {
+		return environment, ErrNotFound
+	}
+
+	err = attributevalue.UnmarshalMap(result.Item, &environment)
+	if err != nil {
+		return environment, fmt.Errorf("failed to unmarshal environment: %w", err)
+	}
+
+	return environment, nil
+}
+
+// List returns a page of environments matching the filter, in the order
+// paginate gives them. Every listing is answered from the indexes ordered by
+// the sort field: owner filters read the partitions of the user and its
+// teams, a status filter the partition of that status, and an unfiltered
+// listing the partitions of every status. Each page reads at most a page from
+// each partition, resuming where the cursor left it.
+func (s *DynamoDBEnvironmentStore) List(ctx context.Context, filter EnvironmentFilter, page PageRequest) (models.EnvironmentList, error) {
+	if page.SortBy == "" {
+		page.SortBy = SortByCreatedAt
+	}
+	if _, ok := sortKeyAttributes[page.SortBy]; !ok {
+		return models.EnvironmentList{}, fmt.Errorf("unsupported sort field %q", page.SortBy)
+	}
+	if page.Limit <= 0 {
+		page.Limit = DefaultPageSize
+	}
+
+	status := string(filter.Status)
+	var partitions []indexPartition
+	switch {
+	case filter.UserID != "" || len(filter.TeamIDs) > 0:
+		if filter.UserID != "" {
+			partitions = append(partitions, indexPartition{"UserID", filter.UserID})
+		}
+		for _, teamID := range filter.TeamIDs {
+			partitions = append(partitions, indexPartition{"TeamID", teamID})
+		}
+	case filter.Status != "":
+		partitions = append(partitions, indexPartition{"Status", status})
+		status = ""
+	default:
+		for _, state := range models.EnvironmentLifecycle().States {
+			partitions = append(partitions, indexPartition{"Status", string(state)})
+		}
+	}
+
+	position := listPosition{SortBy: page.SortBy, Descending: page.Descending}
+	if page.Cursor != "" {
+		if err := decodeToken(page.Cursor, &position); err != nil || position.SortBy != page.SortBy || position.Descending != page.Descending {
+			return models.EnvironmentList{}, ErrInvalidCursor
+		}
+	}
+	done := make(map[string]bool)
+	for _, name := range position.Done {
+		done[name] = true
+	}
+
+	// before orders listed environments as the indexes do, by their sort
+	// key, which ends in the ID and so is never tied
+	before := func(a, b listedEnvironment) bool {
+		if page.Descending {
+			return a.sortKey > b.sortKey
+		}
+		return a.sortKey < b.sortKey
+	}
+
+	read := make([][]listedEnvironment, len(partitions))
+	more := make([]bool, len(partitions))
+	var merged []listedEnvironment
+	for i, partition := range partitions {
+		if done[partition.name()] {
+			continue
+		}
+		items, hasMore, err := s.queryPartition(ctx, partition, page.SortBy, status, filter.IncludeDeleted, page.Descending, position.After[partition.name()], page.Limit)
+		if err != nil {
+			return models.EnvironmentList{}, err
+		}
+		read[i], more[i] = items, hasMore
+		merged = append(merged, items...)
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return before(merged[i], merged[j])
+	})
+
+	// An environment can be owned by the user and one of their teams
+	result := models.EnvironmentList{Items: []models.Environment{}}
+	seen := make(map[string]bool)
+	var last listedEnvironment
+	for _, item := range merged {
+		if len(result.Items) == page.Limit {
+			break
+		}
+		if !seen[item.environment.ID] {
+			seen[item.environment.ID] = true
+			result.Items = append(result.Items, item.environment)
+		}
+		last = item
+	}
+
+	// Each partition resumes after the last of its environments the page
+	// took, or is done once it has none left
+	next := listPosition{SortBy: page.SortBy, Descending: page.Descending, After: map[string]map[string]string{}}
+	for i, partition := range partitions {
+		name := partition.name()
+		if done[name] {
+			next.Done = append(next.Done, name)
+			continue
+		}
+
+		taken := 0
+		for taken < len(read[i]) && !before(last, read[i][taken]) {
+			taken++
+		}
+		switch {
+		case taken == len(read[i]) && !more[i]:
+			next.Done = append(next.Done, name)
+		case taken > 0:
+			next.After[name] = read[i][taken-1].key
+		case position.After[name] != nil:
+			next.After[name] = position.After[name]
+		}
+	}
+	if len(next.Done) < len(partitions) {
+		result.NextCursor = encodeToken(next)
+	}
+
+	return result, nil
+}
+
+// indexPartition is the part of the indexes List reads, such as the
+// environments of one user
+type indexPartition struct {
+	attribute string
+	value     string
+}
+
+// name identifies the partition in a continuation token
+func (p indexPartition) name() string {
+	return p.attribute + "=" + p.value
+}
+
+// listPosition is the decoded form of the continuation token of a DynamoDB
+// listing: the sort order, the key each partition resumes after, and the
+// partitions that have been read to the end
+type listPosition struct {
+	SortBy     string                       `json:"s"`
+	Descending bool                         `json:"d"`
+	After      map[string]map[string]string `json:"a,omitempty"`
+	Done       []string                     `json:"x,omitempty"`
+}
+
+// listedEnvironment is an environment read from an index partition, with its
+// stored sort key, which orders the index, and its key in the index
+type listedEnvironment struct {
+	environment models.Environment
+	sortKey     string
+	key         map[string]string
+}
+
+// queryPartition reads up to limit environments of an index partition in the
+// order of a sort field, starting after the key after, optionally narrowed to a
+// status and skipping soft-deleted environments unless includeDeleted is set.
+// Filtered-out items count against DynamoDB's limit, so it keeps reading
+// until the page is full or the partition ends, and reports whether the
+// partition may have more.
+func (s *DynamoDBEnvironmentStore) queryPartition(ctx context.Context, partition indexPartition, sortBy, status string, includeDeleted, descending bool, after map[string]string, limit int) ([]listedEnvironment, bool, error) {
+	expressionAttributeNames := map[string]string{"#key": partition.attribute}
+	expressionAttributeValues := map[string]types.AttributeValue{
+		":key": &types.AttributeValueMemberS{Value: partition.value},
+	}
+
+	// Environments written before DeletedAt was omitted when empty store it
+	// as NULL
+	var conditions []string
+	if !includeDeleted {
+		conditions = append(conditions, "(attribute_not_exists(#deletedAt) OR attribute_type(#deletedAt, :null))")
+		expressionAttributeNames["#deletedAt"] = "DeletedAt"
+		expressionAttributeValues[":null"] = &types.AttributeValueMemberS{Value: "NULL"}
+	}
+	if status != "" {
+		conditions = append(conditions, "#status = :status")
+		expressionAttributeNames["#status"] = "Status"
+		expressionAttributeValues[":status"] = &types.AttributeValueMemberS{Value: status}
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(s.tableName),
+		IndexName:                 aws.String(environmentIndexName(partition.attribute, sortBy)),
+		KeyConditionExpression:    aws.String("#key = :key"),
+		ExpressionAttributeNames:  expressionAttributeNames,
+		ExpressionAttributeValues: expressionAttributeValues,
+		ScanIndexForward:          aws.Bool(!descending),
+	}
+	if len(conditions) > 0 {
+		input.FilterExpression = aws.String(strings.Join(conditions, " AND "))
+	}
+	if after != nil {
+		input.ExclusiveStartKey = make(map[string]types.AttributeValue, len(after))
+		for name, value := range after {
+			input.ExclusiveStartKey[name] = &types.AttributeValueMemberS{Value: value}
+		}
+	}
+
+	var listed []listedEnvironment
+	for {
+		input.Limit = aws.Int32(int32(limit - len(listed)))
+		result, err := s.client.Query(ctx, input)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to query environments: %w", err)
+		}
+
+		for _, item := range result.Items {
+			var environment models.Environment
+			if err := attributevalue.UnmarshalMap(item, &environment); err != nil {
+				return nil, false, fmt.Errorf("failed to unmarshal environment: %w", err)
+			}
+			key := make(map[string]string, 3)
+			for _, name := range []string{"ID", partition.attribute, sortKeyAttributes[sortBy]} {
+				if value, ok := item[name].(*types.AttributeValueMemberS); ok {
+					key[name] = value.Value
+				}
+			}
+			listed = append(listed, listedEnvironment{
+				environment: environment,
+				sortKey:     key[sortKeyAttributes[sortBy]],
+				key:         key,
+			})
+		}
+
+		if result.LastEvaluatedKey == nil {
+			return listed, false, nil
+		}
+		if len(listed) >= limit {
+			return listed, true, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// Create stores a new environment
+func (s *DynamoDBEnvironmentStore) Create(ctx context.Context, env *models.Environment) error {
+	env.Version = 1
+
+	item, err := marshalEnvironment(*env)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(ID)"),
+	})
+	if err != nil {
+		return translateConditionError(err, ErrVersionConflict, "failed to save environment")
+	}
+
+	return nil
+}
+
+// Update replaces an existing environment if its version is unchanged
+func (s *DynamoDBEnvironmentStore) Update(ctx context.Context, env *models.Environment) error {
+	expectedVersion := env.Version
+
+	next := *env
+	next.Version = expectedVersion + 1
+
+	item, err := marshalEnvironment(next)
+	if err != nil {
+		return err
+	}
+
+	// Items written before versioning was introduced have no Version attribute
+	condition := "attribute_exists(ID) AND #version = :expected"
+	values := map[string]types.AttributeValue{
+		":expected": &types.AttributeValueMemberN{Value: strconv.FormatInt(expectedVersion, 10)},
+	}
+	if expectedVersion == 0 {
+		condition = "attribute_exists(ID) AND attribute_not_exists(#version)"
+		values = nil
+	}
+
+	_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                 aws.String(s.tableName),
+		Item:                      item,
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  map[string]string{"#version": "Version"},
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		return translateConditionError(err, ErrVersionConflict, "failed to save environment")
+	}
+
+	env.Version = next.Version
+	return nil
+}
+
+// SoftDelete marks an environment as deleted
+func (s *DynamoDBEnvironmentStore) SoftDelete(ctx context.Context, env *models.Environment) error {
+	now := time.Now().UTC()
+	env.UpdatedAt = now
+	env.DeletedAt = &now
+
+	return s.Update(ctx, env)
+}
+
+// UpdateStatus moves an environment to a new status if its lifecycle allows.
+// The transition table is enforced in the condition expression, so that a
+// concurrent status change cannot slip in between a read and the write.
+func (s *DynamoDBEnvironmentStore) UpdateStatus(ctx context.Context, envID string, status models.EnvironmentState, message string) error {
+	expressionAttributeValues := map[string]types.AttributeValue{
+		":status":  &types.AttributeValueMemberS{Value: string(status)},
+		":message": &types.AttributeValueMemberS{Value: message},
+		":updated": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		":one":     &types.AttributeValueMemberN{Value: "1"},
+		":sortKey": &types.AttributeValueMemberS{Value: indexSortKey(models.Environment{ID: envID, Status: status}, SortByStatus)},
+	}
+	var sources []string
+	for i, source := range status.Sources() {
+		name := fmt.Sprintf(":from%d", i)
+		sources = append(sources, name)
+		expressionAttributeValues[name] = &types.AttributeValueMemberS{Value: string(source)}
+	}
+	if len(sources) == 0 {
+		return fmt.Errorf("%w: to %s", models.ErrInvalidTransition, status)
+	}
+
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.tableName),
+		Key:                 environmentKey(envID),
+		ConditionExpression: aws.String("attribute_exists(ID) AND #status IN (" + strings.Join(sources, ", ") + ")"),
+		UpdateExpression:    aws.String("SET #status = :status, StatusMessage = :message, UpdatedAt = :updated, StatusSortKey = :sortKey REMOVE FailureCategory ADD #version :one"),
+		ExpressionAttributeNames: map[string]string{
+			"#status":  "Status",
+			"#version": "Version",
+		},
+		ExpressionAttributeValues: expressionAttributeValues,
+	})
+
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		// Tell a missing environment apart from a forbidden transition
+		environment, getErr := s.Get(ctx, envID)
+		if getErr != nil {
+			return getErr
+		}
+		return fmt.Errorf("%w: %s to %s", models.ErrInvalidTransition, environment.Status, status)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update environment status: %w", err)
+	}
+
+	return nil
+}
+
+// marshalEnvironment marshals an environment with its sort keys
+func marshalEnvironment(env models.Environment) (map[string]types.AttributeValue, error) {
+	item, err := attributevalue.MarshalMap(env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal environment: %w", err)
+	}
+	for field, attribute := range sortKeyAttributes {
+		item[attribute] = &types.AttributeValueMemberS{Value: indexSortKey(env, field)}
+	}
+	return item, nil
+}
+
+// environmentIndexName names the index of the partitions of an attribute
+// ordered by a sort field
+func environmentIndexName(attribute, sortBy string) string {
+	return attribute + "-" + sortKeyAttributes[sortBy] + "-index"
+}
+
+// indexSortKey returns the sort key that orders an environment by a field in
+// the indexes: the hex-encoded sortKey, so that the separator sorts before any
+// of its characters, followed by the ID. The indexes thus order environments
+// by field, then ID, as paginate does.
+func indexSortKey(env models.Environment, field string) string {
+	return hex.EncodeToString([]byte(sortKey(env, field))) + "#" + env.ID
+}
+
+// environmentKey builds the primary key for an environment item
+func environmentKey(envID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"ID": &types.AttributeValueMemberS{Value: envID},
+	}
+}
+
+// translateConditionError maps a failed condition expression to the given
+// sentinel error and wraps anything else with message
+func translateConditionError(err, conditionFailed error, message string) error {
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return conditionFailed
+	}
+	return fmt.Errorf("%s: %w", message, err)
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+)
+
+// maxAppendAttempts bounds the retries when two events of one environment
+// land on the same sequence
+const maxAppendAttempts = 5
+
+// DynamoDBEventStore stores environment events in a DynamoDB table keyed by
+// environment and sequence
+type DynamoDBEventStore struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+// NewDynamoDBEventStore creates a new DynamoDB-backed event store
+func NewDynamoDBEventStore(client *dynamodb.Client, tableName string) *DynamoDBEventStore {
+	return &DynamoDBEventStore{
+		client:    client,
+		tableName: tableName,
+	}
+}
+
+// EnsureTable creates the events table if it is missing
+func (s *DynamoDBEventStore) EnsureTable(ctx context.Context) error {
+	return ensureTable(ctx, s.client, tableSpec{
+		Name:     s.tableName,
+		HashKey:  "EnvironmentID",
+		RangeKey: "Sequence",
+	})
+}
+
+// Append stores a new event. Its sequence is the creation time in
+// nanoseconds, bumped past any event already stored at the same instant.
+func (s *DynamoDBEventStore) Append(ctx context.Context, event *models.EnvironmentEvent) error {
+	event.Sequence = event.CreatedAt.UnixNano()
+
+	for attempt := 0; attempt < maxAppendAttempts; attempt++ {
+		item, err := attributevalue.MarshalMap(event)
+		if err != nil {
+			return fmt.Errorf("failed to marshal event: %w", err)
+		}
+
+		_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:           aws.String(s.tableName),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(#sequence)"),
+			ExpressionAttributeNames: map[string]string{
+				"#sequence": "Sequence",
+			},
+		})
+
+		var conditionErr *types.ConditionalCheckFailedException
+		if !errors.As(err, &conditionErr) {
+			if err != nil {
+				return fmt.Errorf("failed to save event: %w", err)
+			}
+			return nil
+		}
+		event.Sequence++
+	}
+
+	return ErrVersionConflict
+}
+
+// List returns the events of an environment that match the filter in order
+func (s *DynamoDBEventStore) List(ctx context.Context, envID string, filter EventFilter) ([]models.EnvironmentEvent, error) {
+	events := []models.EnvironmentEvent{}
+	paginator := dynamodb.NewQueryPaginator(s.client, &dynamodb.QueryInput{
+		TableName:                aws.String(s.tableName),
+		KeyConditionExpression:   aws.String("#environment = :environment"),
+		ExpressionAttributeNames: map[string]string{"#environment": "EnvironmentID"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":environment": &types.AttributeValueMemberS{Value: envID},
+		},
+	})
+	for paginator.HasMorePages() {
+		result, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query events: %w", err)
+		}
+
+		var batch []models.EnvironmentEvent
+		if err := attributevalue.UnmarshalListOfMaps(result.Items, &batch); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal events: %w", err)
+		}
+		for _, event := range batch {
+			if filter.matches(event) {
+				events = append(events, event)
+			}
+		}
+	}
+
+	return events, nil
+}
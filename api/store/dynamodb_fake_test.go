@@ -0,0 +1,725 @@
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// fakeDynamoDB serves the part of the DynamoDB API the stores use, keeping
+// its tables in memory. Expressions are evaluated for real, so conditional
+// writes and filters behave as they do against DynamoDB.
+type fakeDynamoDB struct {
+	mu     sync.Mutex
+	tables map[string]*fakeTable
+}
+
+// fakeTable is a table of the fake and its items, keyed by their primary key
+type fakeTable struct {
+	name    string
+	keys    fakeKeySchema
+	indexes map[string]fakeKeySchema
+	ttl     string
+	items   map[string]fakeItem
+}
+
+// fakeKeySchema names the hash and optional range key of a table or index
+type fakeKeySchema struct {
+	hash, rng string
+}
+
+// fakeItem is an item in DynamoDB's JSON form: attribute name to a
+// single-entry map from type to value, such as {"S": "abc"}
+type fakeItem map[string]map[string]interface{}
+
+// newFakeDynamoDB starts a fake and returns a client connected to it
+func newFakeDynamoDB(t *testing.T) *dynamodb.Client {
+	t.Helper()
+
+	fake := &fakeDynamoDB{tables: map[string]*fakeTable{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	return dynamodb.New(dynamodb.Options{
+		Region:           "us-east-1",
+		Credentials:      aws.AnonymousCredentials{},
+		EndpointResolver: dynamodb.EndpointResolverFromURL(server.URL),
+		RetryMaxAttempts: 1,
+	})
+}
+
+// fakeError is a DynamoDB error response
+type fakeError struct {
+	code    string
+	message string
+}
+
+func (e *fakeError) Error() string {
+	return e.code + ": " + e.message
+}
+
+// ServeHTTP dispatches an API call by its X-Amz-Target header
+func (f *fakeDynamoDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var input map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	output, err := f.call(strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810."), input)
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	if err != nil {
+		code := "ValidationException"
+		if fe, ok := err.(*fakeError); ok {
+			code = fe.code
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"__type":  "com.amazonaws.dynamodb.v20120810#" + code,
+			"message": err.Error(),
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(output)
+}
+
+func (f *fakeDynamoDB) call(operation string, input map[string]json.RawMessage) (interface{}, error) {
+	switch operation {
+	case "CreateTable":
+		return f.createTable(input)
+	case "DescribeTable":
+		table, err := f.table(input)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"Table": table.describe()}, nil
+	case "DescribeTimeToLive":
+		table, err := f.table(input)
+		if err != nil {
+			return nil, err
+		}
+		status := "DISABLED"
+		if table.ttl != "" {
+			status = "ENABLED"
+		}
+		return map[string]interface{}{"TimeToLiveDescription": map[string]string{"TimeToLiveStatus": status}}, nil
+	case "UpdateTimeToLive":
+		table, err := f.table(input)
+		if err != nil {
+			return nil, err
+		}
+		var spec struct{ AttributeName string }
+		json.Unmarshal(input["TimeToLiveSpecification"], &spec)
+		table.ttl = spec.AttributeName
+		return map[string]interface{}{}, nil
+	case "GetItem":
+		return f.getItem(input)
+	case "PutItem":
+		return f.putItem(input)
+	case "DeleteItem":
+		return f.deleteItem(input)
+	case "UpdateItem":
+		return f.updateItem(input)
+	case "Query", "Scan":
+		return f.read(input)
+	}
+	return nil, fmt.Errorf("operation %q is not supported by the fake", operation)
+}
+
+func (f *fakeDynamoDB) createTable(input map[string]json.RawMessage) (interface{}, error) {
+	var spec struct {
+		TableName string
+		KeySchema []struct {
+			AttributeName string
+			KeyType       string
+		}
+		GlobalSecondaryIndexes []struct {
+			IndexName string
+			KeySchema []struct {
+				AttributeName string
+				KeyType       string
+			}
+		}
+	}
+	raw, _ := json.Marshal(input)
+	if err := json.Unmarshal(raw, &spec); err != nil {
+		return nil, err
+	}
+	if f.tables[spec.TableName] != nil {
+		return nil, &fakeError{"ResourceInUseException", "table exists"}
+	}
+
+	table := &fakeTable{
+		name:    spec.TableName,
+		indexes: map[string]fakeKeySchema{},
+		items:   map[string]fakeItem{},
+	}
+	for _, key := range spec.KeySchema {
+		if key.KeyType == "HASH" {
+			table.keys.hash = key.AttributeName
+		} else {
+			table.keys.rng = key.AttributeName
+		}
+	}
+	for _, index := range spec.GlobalSecondaryIndexes {
+		var keys fakeKeySchema
+		for _, key := range index.KeySchema {
+			if key.KeyType == "HASH" {
+				keys.hash = key.AttributeName
+			} else {
+				keys.rng = key.AttributeName
+			}
+		}
+		table.indexes[index.IndexName] = keys
+	}
+	f.tables[spec.TableName] = table
+	return map[string]interface{}{"TableDescription": table.describe()}, nil
+}
+
+func (f *fakeDynamoDB) getItem(input map[string]json.RawMessage) (interface{}, error) {
+	table, err := f.table(input)
+	if err != nil {
+		return nil, err
+	}
+	var key fakeItem
+	if err := json.Unmarshal(input["Key"], &key); err != nil {
+		return nil, err
+	}
+
+	item, ok := table.items[table.keys.id(key)]
+	if !ok {
+		return map[string]interface{}{}, nil
+	}
+	return map[string]interface{}{"Item": item}, nil
+}
+
+func (f *fakeDynamoDB) putItem(input map[string]json.RawMessage) (interface{}, error) {
+	table, err := f.table(input)
+	if err != nil {
+		return nil, err
+	}
+	var item fakeItem
+	if err := json.Unmarshal(input["Item"], &item); err != nil {
+		return nil, err
+	}
+
+	id := table.keys.id(item)
+	if err := checkCondition(input, table.items[id]); err != nil {
+		return nil, err
+	}
+	table.items[id] = item
+	return map[string]interface{}{}, nil
+}
+
+func (f *fakeDynamoDB) deleteItem(input map[string]json.RawMessage) (interface{}, error) {
+	table, err := f.table(input)
+	if err != nil {
+		return nil, err
+	}
+	var key fakeItem
+	if err := json.Unmarshal(input["Key"], &key); err != nil {
+		return nil, err
+	}
+
+	id := table.keys.id(key)
+	old := table.items[id]
+	if err := checkCondition(input, old); err != nil {
+		return nil, err
+	}
+	delete(table.items, id)
+
+	var returnValues string
+	json.Unmarshal(input["ReturnValues"], &returnValues)
+	if returnValues == "ALL_OLD" && old != nil {
+		return map[string]interface{}{"Attributes": old}, nil
+	}
+	return map[string]interface{}{}, nil
+}
+
+func (f *fakeDynamoDB) updateItem(input map[string]json.RawMessage) (interface{}, error) {
+	table, err := f.table(input)
+	if err != nil {
+		return nil, err
+	}
+	var key fakeItem
+	if err := json.Unmarshal(input["Key"], &key); err != nil {
+		return nil, err
+	}
+
+	id := table.keys.id(key)
+	old := table.items[id]
+	if err := checkCondition(input, old); err != nil {
+		return nil, err
+	}
+
+	// Values are read from the item as it was before the update
+	item := fakeItem{}
+	for name, value := range key {
+		item[name] = value
+	}
+	for name, value := range old {
+		item[name] = value
+	}
+	var update string
+	json.Unmarshal(input["UpdateExpression"], &update)
+	e := newFakeExpression(update, input, old)
+	for e.pos < len(e.tokens) {
+		clause := strings.ToUpper(e.next())
+		for {
+			name := e.name()
+			switch clause {
+			case "SET":
+				if err := e.expect("="); err != nil {
+					return nil, err
+				}
+				item[name] = e.operand()
+			case "REMOVE":
+				delete(item, name)
+			case "ADD":
+				var sum float64
+				for _, value := range []map[string]interface{}{item[name], e.operand()} {
+					if n, ok := value["N"].(string); ok {
+						x, _ := strconv.ParseFloat(n, 64)
+						sum += x
+					}
+				}
+				item[name] = map[string]interface{}{"N": strconv.FormatFloat(sum, 'f', -1, 64)}
+			default:
+				return nil, fmt.Errorf("unsupported update clause %q", clause)
+			}
+			if e.peek() != "," {
+				break
+			}
+			e.next()
+		}
+	}
+
+	table.items[id] = item
+	return map[string]interface{}{}, nil
+}
+
+// read answers a Query or Scan. Items are read in the order of the table or
+// index, by range key and then primary key; the key condition selects the
+// items read and counted against Limit, and the filter the items returned.
+func (f *fakeDynamoDB) read(input map[string]json.RawMessage) (interface{}, error) {
+	table, err := f.table(input)
+	if err != nil {
+		return nil, err
+	}
+	keys := table.keys
+	var indexName string
+	json.Unmarshal(input["IndexName"], &indexName)
+	if indexName != "" {
+		var ok bool
+		if keys, ok = table.indexes[indexName]; !ok {
+			return nil, fmt.Errorf("index %s not found", indexName)
+		}
+	}
+
+	var keyCondition, filter string
+	json.Unmarshal(input["KeyConditionExpression"], &keyCondition)
+	json.Unmarshal(input["FilterExpression"], &filter)
+	forward := true
+	json.Unmarshal(input["ScanIndexForward"], &forward)
+	var limit int
+	json.Unmarshal(input["Limit"], &limit)
+	var start fakeItem
+	json.Unmarshal(input["ExclusiveStartKey"], &start)
+
+	// order compares two items in the order they are read
+	order := func(a, b fakeItem) int {
+		c := compare(a[keys.rng], b[keys.rng])
+		if c == 0 {
+			c = strings.Compare(table.keys.id(a), table.keys.id(b))
+		}
+		if !forward {
+			c = -c
+		}
+		return c
+	}
+
+	// Indexes are sparse: items without the index's keys are left out
+	var items []fakeItem
+	for _, item := range table.items {
+		if item[keys.hash] == nil || (keys.rng != "" && item[keys.rng] == nil) {
+			continue
+		}
+		if start != nil && order(item, start) <= 0 {
+			continue
+		}
+		if keyCondition != "" {
+			ok, err := evaluate(keyCondition, input, item)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return order(items[i], items[j]) < 0
+	})
+
+	output := map[string]interface{}{}
+	if limit > 0 && len(items) >= limit {
+		items = items[:limit]
+		last := fakeItem{}
+		for _, name := range []string{table.keys.hash, table.keys.rng, keys.hash, keys.rng} {
+			if name != "" {
+				last[name] = items[limit-1][name]
+			}
+		}
+		output["LastEvaluatedKey"] = last
+	}
+
+	returned := []fakeItem{}
+	for _, item := range items {
+		if filter != "" {
+			ok, err := evaluate(filter, input, item)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		returned = append(returned, item)
+	}
+	output["Items"] = returned
+	output["Count"] = len(returned)
+	output["ScannedCount"] = len(items)
+	return output, nil
+}
+
+// table returns the table named by an input's TableName
+func (f *fakeDynamoDB) table(input map[string]json.RawMessage) (*fakeTable, error) {
+	var name string
+	json.Unmarshal(input["TableName"], &name)
+	table := f.tables[name]
+	if table == nil {
+		return nil, &fakeError{"ResourceNotFoundException", "table " + name + " not found"}
+	}
+	return table, nil
+}
+
+// describe returns the table's description, with the table and its indexes
+// active
+func (t *fakeTable) describe() map[string]interface{} {
+	var indexes []map[string]string
+	for name := range t.indexes {
+		indexes = append(indexes, map[string]string{"IndexName": name, "IndexStatus": "ACTIVE"})
+	}
+	return map[string]interface{}{
+		"TableName":              t.name,
+		"TableStatus":            "ACTIVE",
+		"GlobalSecondaryIndexes": indexes,
+	}
+}
+
+// id returns the primary key of an item as a string
+func (k fakeKeySchema) id(item fakeItem) string {
+	data, _ := json.Marshal([]interface{}{item[k.hash], item[k.rng]})
+	return string(data)
+}
+
+// checkCondition evaluates an input's ConditionExpression against the item it
+// writes over, which is nil if there is none
+func checkCondition(input map[string]json.RawMessage, item fakeItem) error {
+	var condition string
+	json.Unmarshal(input["ConditionExpression"], &condition)
+	if condition == "" {
+		return nil
+	}
+
+	ok, err := evaluate(condition, input, item)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &fakeError{"ConditionalCheckFailedException", "The conditional request failed"}
+	}
+	return nil
+}
+
+// evaluate evaluates a condition, key condition or filter expression of an
+// input against an item
+func evaluate(expression string, input map[string]json.RawMessage, item fakeItem) (bool, error) {
+	e := newFakeExpression(expression, input, item)
+	result, err := e.condition()
+	if err != nil {
+		return false, err
+	}
+	if e.pos != len(e.tokens) {
+		return false, fmt.Errorf("unexpected %q in %q", e.tokens[e.pos], expression)
+	}
+	return result, nil
+}
+
+// tokenize splits an expression into names, values, keywords and operators
+func tokenize(expression string) []string {
+	var tokens []string
+	for i := 0; i < len(expression); {
+		c := rune(expression[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.ContainsRune("(),", c):
+			tokens = append(tokens, string(c))
+			i++
+		case strings.ContainsRune("<>=", c):
+			j := i + 1
+			for j < len(expression) && strings.ContainsRune("<>=", rune(expression[j])) {
+				j++
+			}
+			tokens = append(tokens, expression[i:j])
+			i = j
+		default:
+			j := i
+			for j < len(expression) && !unicode.IsSpace(rune(expression[j])) && !strings.ContainsRune("(),<>=", rune(expression[j])) {
+				j++
+			}
+			tokens = append(tokens, expression[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+// fakeExpression is a recursive-descent evaluator of DynamoDB expressions
+type fakeExpression struct {
+	tokens []string
+	pos    int
+	names  map[string]string
+	values map[string]map[string]interface{}
+	item   fakeItem
+}
+
+// newFakeExpression prepares an expression of an input for evaluation
+// against an item
+func newFakeExpression(expression string, input map[string]json.RawMessage, item fakeItem) *fakeExpression {
+	e := &fakeExpression{tokens: tokenize(expression), item: item}
+	json.Unmarshal(input["ExpressionAttributeNames"], &e.names)
+	json.Unmarshal(input["ExpressionAttributeValues"], &e.values)
+	return e
+}
+
+func (e *fakeExpression) peek() string {
+	if e.pos < len(e.tokens) {
+		return e.tokens[e.pos]
+	}
+	return ""
+}
+
+func (e *fakeExpression) next() string {
+	token := e.peek()
+	e.pos++
+	return token
+}
+
+func (e *fakeExpression) expect(token string) error {
+	if got := e.next(); got != token {
+		return fmt.Errorf("expected %q, got %q", token, got)
+	}
+	return nil
+}
+
+func (e *fakeExpression) condition() (bool, error) {
+	result, err := e.conjunction()
+	if err != nil {
+		return false, err
+	}
+	for strings.EqualFold(e.peek(), "OR") {
+		e.next()
+		right, err := e.conjunction()
+		if err != nil {
+			return false, err
+		}
+		result = result || right
+	}
+	return result, nil
+}
+
+func (e *fakeExpression) conjunction() (bool, error) {
+	result, err := e.negation()
+	if err != nil {
+		return false, err
+	}
+	for strings.EqualFold(e.peek(), "AND") {
+		e.next()
+		right, err := e.negation()
+		if err != nil {
+			return false, err
+		}
+		result = result && right
+	}
+	return result, nil
+}
+
+func (e *fakeExpression) negation() (bool, error) {
+	if strings.EqualFold(e.peek(), "NOT") {
+		e.next()
+		result, err := e.negation()
+		return !result, err
+	}
+	return e.primary()
+}
+
+func (e *fakeExpression) primary() (bool, error) {
+	if e.peek() == "(" {
+		e.next()
+		result, err := e.condition()
+		if err != nil {
+			return false, err
+		}
+		return result, e.expect(")")
+	}
+
+	switch function := e.peek(); function {
+	case "attribute_exists", "attribute_not_exists", "attribute_type", "begins_with", "contains":
+		e.next()
+		if err := e.expect("("); err != nil {
+			return false, err
+		}
+		args := []map[string]interface{}{e.operand()}
+		for e.peek() == "," {
+			e.next()
+			args = append(args, e.operand())
+		}
+		if err := e.expect(")"); err != nil {
+			return false, err
+		}
+		switch function {
+		case "attribute_exists":
+			return args[0] != nil, nil
+		case "attribute_not_exists":
+			return args[0] == nil, nil
+		case "attribute_type":
+			_, ok := args[0][fmt.Sprint(args[1]["S"])]
+			return args[0] != nil && ok, nil
+		case "begins_with":
+			return args[0] != nil && strings.HasPrefix(fmt.Sprint(args[0]["S"]), fmt.Sprint(args[1]["S"])), nil
+		default:
+			return args[0] != nil && strings.Contains(fmt.Sprint(args[0]["S"]), fmt.Sprint(args[1]["S"])), nil
+		}
+	}
+
+	left := e.operand()
+	switch operator := strings.ToUpper(e.next()); operator {
+	case "IN":
+		if err := e.expect("("); err != nil {
+			return false, err
+		}
+		found := compare(left, e.operand()) == 0
+		for e.peek() == "," {
+			e.next()
+			found = compare(left, e.operand()) == 0 || found
+		}
+		return found, e.expect(")")
+	case "BETWEEN":
+		low := e.operand()
+		if err := e.expect("AND"); err != nil {
+			return false, err
+		}
+		high := e.operand()
+		return left != nil && compare(left, low) >= 0 && compare(left, high) <= 0, nil
+	case "=", "<>", "<", "<=", ">", ">=":
+		right := e.operand()
+		if left == nil || right == nil {
+			return operator == "<>" && (left == nil) != (right == nil), nil
+		}
+		c := compare(left, right)
+		switch operator {
+		case "=":
+			return c == 0, nil
+		case "<>":
+			return c != 0, nil
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	default:
+		return false, fmt.Errorf("unsupported operator %q", operator)
+	}
+}
+
+// operand resolves a value placeholder or an attribute name to its value,
+// which is nil for an attribute the item does not have
+func (e *fakeExpression) operand() map[string]interface{} {
+	if strings.HasPrefix(e.peek(), ":") {
+		return e.values[e.next()]
+	}
+	return e.item[e.name()]
+}
+
+// name resolves an attribute name or name placeholder
+func (e *fakeExpression) name() string {
+	token := e.next()
+	if name, ok := e.names[token]; ok {
+		return name
+	}
+	return token
+}
+
+// compare orders two attribute values of the same scalar type, and reports
+// other values as equal only if they are identical
+func compare(a, b map[string]interface{}) int {
+	if a == nil || b == nil {
+		if a == nil && b == nil {
+			return 0
+		}
+		return 1
+	}
+	if s, ok := a["S"].(string); ok {
+		if t, ok := b["S"].(string); ok {
+			return strings.Compare(s, t)
+		}
+	}
+	if s, ok := a["N"].(string); ok {
+		if t, ok := b["N"].(string); ok {
+			x, _ := strconv.ParseFloat(s, 64)
+			y, _ := strconv.ParseFloat(t, 64)
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	if s, ok := a["B"].(string); ok {
+		if t, ok := b["B"].(string); ok {
+			x, _ := base64.StdEncoding.DecodeString(s)
+			y, _ := base64.StdEncoding.DecodeString(t)
+			return bytes.Compare(x, y)
+		}
+	}
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	if bytes.Equal(x, y) {
+		return 0
+	}
+	return 1
+}
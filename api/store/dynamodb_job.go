@@ -0,0 +1,149 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+)
+
+// Global secondary indexes on the jobs table
+const (
+	jobEnvironmentIndexName = "EnvironmentID-CreatedAt-index"
+	jobStatusIndexName      = "Status-CreatedAt-index"
+)
+
+// DynamoDBJobStore stores jobs in a DynamoDB table
+type DynamoDBJobStore struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+// NewDynamoDBJobStore creates a new DynamoDB-backed job store
+func NewDynamoDBJobStore(client *dynamodb.Client, tableName string) *DynamoDBJobStore {
+	return &DynamoDBJobStore{
+		client:    client,
+		tableName: tableName,
+	}
+}
+
+// EnsureTable creates the jobs table and its environment and status indexes
+// if they are missing
+func (s *DynamoDBJobStore) EnsureTable(ctx context.Context) error {
+	return ensureTable(ctx, s.client, tableSpec{
+		Name:    s.tableName,
+		HashKey: "ID",
+		Indexes: []indexSpec{
+			{Name: jobEnvironmentIndexName, HashKey: "EnvironmentID", RangeKey: "CreatedAt"},
+			{Name: jobStatusIndexName, HashKey: "Status", RangeKey: "CreatedAt"},
+		},
+	})
+}
+
+// Get returns the job with the given ID
+func (s *DynamoDBJobStore) Get(ctx context.Context, jobID string) (models.Job, error) {
+	var job models.Job
+	err := getRecord(ctx, s.client, s.tableName, jobID, &job)
+	return job, err
+}
+
+// List returns the jobs that match the filter, oldest first. Environment and
+// status filters are answered from the matching indexes; only an unfiltered
+// listing scans the table.
+func (s *DynamoDBJobStore) List(ctx context.Context, filter JobFilter) ([]models.Job, error) {
+	var candidates []models.Job
+	switch {
+	case filter.EnvironmentID != "":
+		jobs, err := s.queryIndex(ctx, jobEnvironmentIndexName, "EnvironmentID", filter.EnvironmentID)
+		if err != nil {
+			return nil, err
+		}
+		candidates = jobs
+	case len(filter.Statuses) > 0:
+		for _, status := range filter.Statuses {
+			jobs, err := s.queryIndex(ctx, jobStatusIndexName, "Status", status)
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, jobs...)
+		}
+	default:
+		paginator := dynamodb.NewScanPaginator(s.client, &dynamodb.ScanInput{
+			TableName: aws.String(s.tableName),
+		})
+		for paginator.HasMorePages() {
+			result, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to scan jobs: %w", err)
+			}
+
+			var batch []models.Job
+			if err := attributevalue.UnmarshalListOfMaps(result.Items, &batch); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal jobs: %w", err)
+			}
+			candidates = append(candidates, batch...)
+		}
+	}
+
+	jobs := []models.Job{}
+	for _, job := range candidates {
+		if filter.matches(job) {
+			jobs = append(jobs, job)
+		}
+	}
+
+	sortJobs(jobs)
+	return jobs, nil
+}
+
+// queryIndex reads every job whose keyAttribute equals keyValue from the
+// given index
+func (s *DynamoDBJobStore) queryIndex(ctx context.Context, indexName, keyAttribute, keyValue string) ([]models.Job, error) {
+	jobs := []models.Job{}
+	paginator := dynamodb.NewQueryPaginator(s.client, &dynamodb.QueryInput{
+		TableName:                aws.String(s.tableName),
+		IndexName:                aws.String(indexName),
+		KeyConditionExpression:   aws.String("#key = :key"),
+		ExpressionAttributeNames: map[string]string{"#key": keyAttribute},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":key": &types.AttributeValueMemberS{Value: keyValue},
+		},
+	})
+	for paginator.HasMorePages() {
+		result, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query jobs: %w", err)
+		}
+
+		var batch []models.Job
+		if err := attributevalue.UnmarshalListOfMaps(result.Items, &batch); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal jobs: %w", err)
+		}
+		jobs = append(jobs, batch...)
+	}
+
+	return jobs, nil
+}
+
+// Create stores a new job
+func (s *DynamoDBJobStore) Create(ctx context.Context, job *models.Job) error {
+	job.Version = 1
+	return createRecord(ctx, s.client, s.tableName, job)
+}
+
+// Update replaces an existing job if its version is unchanged
+func (s *DynamoDBJobStore) Update(ctx context.Context, job *models.Job) error {
+	next := *job
+	next.Version = job.Version + 1
+
+	if err := updateRecord(ctx, s.client, s.tableName, next, job.Version); err != nil {
+		return err
+	}
+
+	job.Version = next.Version
+	return nil
+}
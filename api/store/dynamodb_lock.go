@@ -0,0 +1,153 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+)
+
+// DynamoDBLockStore stores environment locks in a DynamoDB table keyed by
+// environment. Every write is conditioned on the token of the lock it
+// replaces, so two processes cannot both take over the same lock.
+type DynamoDBLockStore struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+// NewDynamoDBLockStore creates a new DynamoDB-backed lock store
+func NewDynamoDBLockStore(client *dynamodb.Client, tableName string) *DynamoDBLockStore {
+	return &DynamoDBLockStore{
+		client:    client,
+		tableName: tableName,
+	}
+}
+
+// EnsureTable creates the locks table if it is missing
+func (s *DynamoDBLockStore) EnsureTable(ctx context.Context) error {
+	return ensureTable(ctx, s.client, tableSpec{
+		Name:    s.tableName,
+		HashKey: "EnvironmentID",
+	})
+}
+
+// Get returns the lock of an environment, reading it consistently so that a
+// lock just taken elsewhere is seen
+func (s *DynamoDBLockStore) Get(ctx context.Context, envID string) (models.EnvironmentLock, error) {
+	var lock models.EnvironmentLock
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.tableName),
+		Key:            lockKey(envID),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return lock, fmt.Errorf("failed to get environment lock: %w", err)
+	}
+	if result.Item == nil {
+		return lock, ErrNotFound
+	}
+
+	if err := attributevalue.UnmarshalMap(result.Item, &lock); err != nil {
+		return lock, fmt.Errorf("failed to unmarshal environment lock: %w", err)
+	}
+	return lock, nil
+}
+
+// Acquire stores lock unless another operation holds the environment. The
+// write only succeeds if the lock it replaces is still the one found, so a
+// racing acquirer gets ErrLocked.
+func (s *DynamoDBLockStore) Acquire(ctx context.Context, lock models.EnvironmentLock) error {
+	held, err := s.Get(ctx, lock.EnvironmentID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if err == nil && !held.Expired(time.Now().UTC()) && !sameJob(held, lock) {
+		return ErrLocked
+	}
+
+	if errors.Is(err, ErrNotFound) {
+		return s.put(ctx, lock, "attribute_not_exists(EnvironmentID)", nil, ErrLocked)
+	}
+	return s.put(ctx, lock, "#token = :token", map[string]types.AttributeValue{
+		":token": &types.AttributeValueMemberS{Value: held.Token},
+	}, ErrLocked)
+}
+
+// Renew replaces the environment's lock if it still holds lock.Token
+func (s *DynamoDBLockStore) Renew(ctx context.Context, lock models.EnvironmentLock) error {
+	return s.put(ctx, lock, "#token = :token", map[string]types.AttributeValue{
+		":token": &types.AttributeValueMemberS{Value: lock.Token},
+	}, ErrLockLost)
+}
+
+// Release deletes the environment's lock if it still holds token
+func (s *DynamoDBLockStore) Release(ctx context.Context, envID, token string) error {
+	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:                aws.String(s.tableName),
+		Key:                      lockKey(envID),
+		ConditionExpression:      aws.String("#token = :token"),
+		ExpressionAttributeNames: map[string]string{"#token": "Token"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":token": &types.AttributeValueMemberS{Value: token},
+		},
+	})
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to release environment lock: %w", err)
+	}
+	return nil
+}
+
+// Break deletes the environment's lock whoever holds it
+func (s *DynamoDBLockStore) Break(ctx context.Context, envID string) error {
+	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:           aws.String(s.tableName),
+		Key:                 lockKey(envID),
+		ConditionExpression: aws.String("attribute_exists(EnvironmentID)"),
+	})
+	if err != nil {
+		return translateConditionError(err, ErrNotFound, "failed to break environment lock")
+	}
+	return nil
+}
+
+// put writes lock under a condition on the stored lock, returning
+// conditionFailed if it does not hold
+func (s *DynamoDBLockStore) put(ctx context.Context, lock models.EnvironmentLock, condition string, values map[string]types.AttributeValue, conditionFailed error) error {
+	item, err := attributevalue.MarshalMap(lock)
+	if err != nil {
+		return fmt.Errorf("failed to marshal environment lock: %w", err)
+	}
+
+	input := &dynamodb.PutItemInput{
+		TableName:                 aws.String(s.tableName),
+		Item:                      item,
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeValues: values,
+	}
+	if values != nil {
+		input.ExpressionAttributeNames = map[string]string{"#token": "Token"}
+	}
+
+	_, err = s.client.PutItem(ctx, input)
+	if err != nil {
+		return translateConditionError(err, conditionFailed, "failed to save environment lock")
+	}
+	return nil
+}
+
+// lockKey builds the primary key for an environment's lock item
+func lockKey(envID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"EnvironmentID": &types.AttributeValueMemberS{Value: envID},
+	}
+}
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+)
+
+// DynamoDBLogStore stores environment logs in a DynamoDB table keyed by
+// environment and sequence
+type DynamoDBLogStore struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+// NewDynamoDBLogStore creates a new DynamoDB-backed log store
+func NewDynamoDBLogStore(client *dynamodb.Client, tableName string) *DynamoDBLogStore {
+	return &DynamoDBLogStore{
+		client:    client,
+		tableName: tableName,
+	}
+}
+
+// EnsureTable creates the logs table if it is missing
+func (s *DynamoDBLogStore) EnsureTable(ctx context.Context) error {
+	return ensureTable(ctx, s.client, tableSpec{
+		Name:     s.tableName,
+		HashKey:  "EnvironmentID",
+		RangeKey: "Sequence",
+	})
+}
+
+// Append stores a new log line. Like events, its sequence is the creation
+// time in nanoseconds, bumped past any line stored at the same instant.
+func (s *DynamoDBLogStore) Append(ctx context.Context, line *models.LogLine) error {
+	line.Sequence = line.CreatedAt.UnixNano()
+
+	for attempt := 0; attempt < maxAppendAttempts; attempt++ {
+		item, err := attributevalue.MarshalMap(line)
+		if err != nil {
+			return fmt.Errorf("failed to marshal log line: %w", err)
+		}
+
+		_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:           aws.String(s.tableName),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(#sequence)"),
+			ExpressionAttributeNames: map[string]string{
+				"#sequence": "Sequence",
+			},
+		})
+
+		var conditionErr *types.ConditionalCheckFailedException
+		if !errors.As(err, &conditionErr) {
+			if err != nil {
+				return fmt.Errorf("failed to save log line: %w", err)
+			}
+			return nil
+		}
+		line.Sequence++
+	}
+
+	return ErrVersionConflict
+}
+
+// List returns the log lines of an environment that match the filter in order
+func (s *DynamoDBLogStore) List(ctx context.Context, envID string, filter LogFilter) ([]models.LogLine, error) {
+	lines := []models.LogLine{}
+	paginator := dynamodb.NewQueryPaginator(s.client, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		KeyConditionExpression: aws.String("#environment = :environment AND #sequence > :after"),
+		ExpressionAttributeNames: map[string]string{
+			"#environment": "EnvironmentID",
+			"#sequence":    "Sequence",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":environment": &types.AttributeValueMemberS{Value: envID},
+			":after":       &types.AttributeValueMemberN{Value: strconv.FormatInt(filter.AfterSequence, 10)},
+		},
+	})
+	for paginator.HasMorePages() {
+		result, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query log lines: %w", err)
+		}
+
+		var batch []models.LogLine
+		if err := attributevalue.UnmarshalListOfMaps(result.Items, &batch); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal log lines: %w", err)
+		}
+		for _, line := range batch {
+			if !filter.matches(line) {
+				continue
+			}
+			lines = append(lines, line)
+			if filter.Limit > 0 && len(lines) == filter.Limit {
+				return lines, nil
+			}
+		}
+	}
+
+	return lines, nil
+}
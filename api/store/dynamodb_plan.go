@@ -0,0 +1,219 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+)
+
+// planChunkSize is how much of a plan file one item holds. A plan file
+// carries the configuration and the prior state and easily outgrows the
+// 400 KB item limit, so it is split over several items.
+const planChunkSize = 350 * 1024
+
+// planTTLAttribute holds the epoch second after which DynamoDB may delete a
+// plan's items
+const planTTLAttribute = "TTL"
+
+// DynamoDBPlanStore stores saved plans in a DynamoDB table keyed by plan ID.
+// Each plan's file is split over chunk items keyed by the plan ID and the
+// chunk's index. DynamoDB's time to live removes expired plans.
+type DynamoDBPlanStore struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+// NewDynamoDBPlanStore creates a new DynamoDB-backed plan store
+func NewDynamoDBPlanStore(client *dynamodb.Client, tableName string) *DynamoDBPlanStore {
+	return &DynamoDBPlanStore{
+		client:    client,
+		tableName: tableName,
+	}
+}
+
+// EnsureTable creates the plans table if it is missing and turns on its time
+// to live
+func (s *DynamoDBPlanStore) EnsureTable(ctx context.Context) error {
+	err := ensureTable(ctx, s.client, tableSpec{
+		Name:    s.tableName,
+		HashKey: "ID",
+	})
+	if err != nil {
+		return err
+	}
+
+	described, err := s.client.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{
+		TableName: aws.String(s.tableName),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe time to live of table %s: %w", s.tableName, err)
+	}
+	if ttl := described.TimeToLiveDescription; ttl != nil &&
+		(ttl.TimeToLiveStatus == types.TimeToLiveStatusEnabled || ttl.TimeToLiveStatus == types.TimeToLiveStatusEnabling) {
+		return nil
+	}
+
+	_, err = s.client.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(s.tableName),
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: aws.String(planTTLAttribute),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enable time to live on table %s: %w", s.tableName, err)
+	}
+	return nil
+}
+
+// Get returns a plan of an environment that has not expired, reading it
+// consistently so that a plan just made by another replica is seen
+func (s *DynamoDBPlanStore) Get(ctx context.Context, envID, planID string) (models.Plan, error) {
+	var plan models.Plan
+	item, err := s.getItem(ctx, planID)
+	if err != nil {
+		return plan, err
+	}
+
+	if err := attributevalue.UnmarshalMap(item, &plan); err != nil {
+		return plan, fmt.Errorf("failed to unmarshal plan: %w", err)
+	}
+	if plan.EnvironmentID != envID || plan.Expired(time.Now()) {
+		return models.Plan{}, ErrNotFound
+	}
+
+	chunks, err := planChunks(item)
+	if err != nil {
+		return models.Plan{}, err
+	}
+	for i := 0; i < chunks; i++ {
+		chunk, err := s.getItem(ctx, planChunkID(planID, i))
+		if err != nil {
+			return models.Plan{}, err
+		}
+		data, ok := chunk["Data"].(*types.AttributeValueMemberB)
+		if !ok {
+			return models.Plan{}, fmt.Errorf("chunk %d of plan %s has no data", i, planID)
+		}
+		plan.File = append(plan.File, data.Value...)
+	}
+
+	return plan, nil
+}
+
+// Create stores a new plan. The chunks of its file are written first, so a
+// plan that can be read always has its whole file.
+func (s *DynamoDBPlanStore) Create(ctx context.Context, plan *models.Plan) error {
+	ttl := &types.AttributeValueMemberN{Value: strconv.FormatInt(plan.ExpiresAt.Unix(), 10)}
+
+	chunks := 0
+	for offset := 0; offset < len(plan.File); offset += planChunkSize {
+		end := offset + planChunkSize
+		if end > len(plan.File) {
+			end = len(plan.File)
+		}
+		_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(s.tableName),
+			Item: map[string]types.AttributeValue{
+				"ID":             &types.AttributeValueMemberS{Value: planChunkID(plan.ID, chunks)},
+				"Data":           &types.AttributeValueMemberB{Value: plan.File[offset:end]},
+				planTTLAttribute: ttl,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to save plan file: %w", err)
+		}
+		chunks++
+	}
+
+	item, err := attributevalue.MarshalMap(plan)
+	if err != nil {
+		return fmt.Errorf("failed to marshal plan: %w", err)
+	}
+	item["Chunks"] = &types.AttributeValueMemberN{Value: strconv.Itoa(chunks)}
+	item[planTTLAttribute] = ttl
+
+	_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(ID)"),
+	})
+	if err != nil {
+		return translateConditionError(err, ErrVersionConflict, "failed to save plan")
+	}
+	return nil
+}
+
+// Delete removes a plan, then the chunks of its file
+func (s *DynamoDBPlanStore) Delete(ctx context.Context, envID, planID string) error {
+	result, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:    aws.String(s.tableName),
+		Key:          planKey(planID),
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete plan: %w", err)
+	}
+	if result.Attributes == nil {
+		return nil
+	}
+
+	chunks, err := planChunks(result.Attributes)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < chunks; i++ {
+		_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(s.tableName),
+			Key:       planKey(planChunkID(planID, i)),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete plan file: %w", err)
+		}
+	}
+	return nil
+}
+
+// getItem reads the item with the given ID consistently
+func (s *DynamoDBPlanStore) getItem(ctx context.Context, id string) (map[string]types.AttributeValue, error) {
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.tableName),
+		Key:            planKey(id),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get plan: %w", err)
+	}
+	if result.Item == nil {
+		return nil, ErrNotFound
+	}
+	return result.Item, nil
+}
+
+// planChunks returns the number of chunks of the file of a plan item
+func planChunks(item map[string]types.AttributeValue) (int, error) {
+	var chunks int
+	if err := attributevalue.Unmarshal(item["Chunks"], &chunks); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal plan chunks: %w", err)
+	}
+	return chunks, nil
+}
+
+// planChunkID returns the ID of the item holding a chunk of a plan's file
+func planChunkID(planID string, chunk int) string {
+	return planID + "#" + strconv.Itoa(chunk)
+}
+
+// planKey builds the primary key for a plan or chunk item
+func planKey(id string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"ID": &types.AttributeValueMemberS{Value: id},
+	}
+}
@@ -0,0 +1,195 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// tableSpec describes a DynamoDB table and its global secondary indexes.
+// Key attributes are strings, except for a numeric table range key.
+type tableSpec struct {
+	Name     string
+	HashKey  string
+	RangeKey string // optional, numeric
+	Indexes  []indexSpec
+}
+
+// indexSpec describes a global secondary index projecting all attributes
+type indexSpec struct {
+	Name     string
+	HashKey  string
+	RangeKey string
+}
+
+// indexPollInterval is how often ensureTable checks on a backfilling index
+const indexPollInterval = 5 * time.Second
+
+// ensureTable creates the table described by spec if it does not exist, adds
+// any missing indexes, and waits until the table and its indexes are active
+func ensureTable(ctx context.Context, client *dynamodb.Client, spec tableSpec) error {
+	described, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(spec.Name),
+	})
+
+	var notFound *types.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		return createTable(ctx, client, spec)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to describe table %s: %w", spec.Name, err)
+	}
+
+	existing := make(map[string]bool)
+	for _, index := range described.Table.GlobalSecondaryIndexes {
+		existing[aws.ToString(index.IndexName)] = true
+	}
+
+	// DynamoDB only accepts one new index per UpdateTable call
+	for _, index := range spec.Indexes {
+		if existing[index.Name] {
+			continue
+		}
+
+		log.Printf("Creating index %s on table %s", index.Name, spec.Name)
+		_, err := client.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+			TableName:            aws.String(spec.Name),
+			AttributeDefinitions: attributeDefinitions(index.HashKey, index.RangeKey),
+			GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{
+				{
+					Create: &types.CreateGlobalSecondaryIndexAction{
+						IndexName:  aws.String(index.Name),
+						KeySchema:  indexKeySchema(index),
+						Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+					},
+				},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create index %s: %w", index.Name, err)
+		}
+
+		if err := waitForIndexes(ctx, client, spec.Name); err != nil {
+			return err
+		}
+	}
+
+	return waitForIndexes(ctx, client, spec.Name)
+}
+
+// createTable creates a new on-demand table with all of its indexes
+func createTable(ctx context.Context, client *dynamodb.Client, spec tableSpec) error {
+	log.Printf("Creating table %s", spec.Name)
+
+	keys := []string{spec.HashKey}
+	var indexes []types.GlobalSecondaryIndex
+	for _, index := range spec.Indexes {
+		keys = append(keys, index.HashKey, index.RangeKey)
+		indexes = append(indexes, types.GlobalSecondaryIndex{
+			IndexName:  aws.String(index.Name),
+			KeySchema:  indexKeySchema(index),
+			Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+		})
+	}
+
+	definitions := attributeDefinitions(keys...)
+	keySchema := []types.KeySchemaElement{
+		{AttributeName: aws.String(spec.HashKey), KeyType: types.KeyTypeHash},
+	}
+	if spec.RangeKey != "" {
+		definitions = append(definitions, types.AttributeDefinition{
+			AttributeName: aws.String(spec.RangeKey),
+			AttributeType: types.ScalarAttributeTypeN,
+		})
+		keySchema = append(keySchema, types.KeySchemaElement{
+			AttributeName: aws.String(spec.RangeKey),
+			KeyType:       types.KeyTypeRange,
+		})
+	}
+
+	_, err := client.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName:              aws.String(spec.Name),
+		BillingMode:            types.BillingModePayPerRequest,
+		AttributeDefinitions:   definitions,
+		GlobalSecondaryIndexes: indexes,
+		KeySchema:              keySchema,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", spec.Name, err)
+	}
+
+	waiter := dynamodb.NewTableExistsWaiter(client)
+	err = waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(spec.Name)}, 5*time.Minute)
+	if err != nil {
+		return fmt.Errorf("failed waiting for table %s: %w", spec.Name, err)
+	}
+
+	return waitForIndexes(ctx, client, spec.Name)
+}
+
+// waitForIndexes blocks until every index on the table is ACTIVE, since
+// queries against an index that is still backfilling are rejected
+func waitForIndexes(ctx context.Context, client *dynamodb.Client, tableName string) error {
+	for {
+		described, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+			TableName: aws.String(tableName),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to describe table %s: %w", tableName, err)
+		}
+
+		pending := 0
+		for _, index := range described.Table.GlobalSecondaryIndexes {
+			if index.IndexStatus != types.IndexStatusActive {
+				pending++
+			}
+		}
+		if pending == 0 {
+			return nil
+		}
+
+		log.Printf("Waiting for %d index(es) on table %s to become active", pending, tableName)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(indexPollInterval):
+		}
+	}
+}
+
+// attributeDefinitions declares the given key attributes as strings
+func attributeDefinitions(names ...string) []types.AttributeDefinition {
+	seen := make(map[string]bool)
+	var definitions []types.AttributeDefinition
+	for _, name := range names {
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		definitions = append(definitions, types.AttributeDefinition{
+			AttributeName: aws.String(name),
+			AttributeType: types.ScalarAttributeTypeS,
+		})
+	}
+	return definitions
+}
+
+// indexKeySchema builds the key schema for an index
+func indexKeySchema(index indexSpec) []types.KeySchemaElement {
+	schema := []types.KeySchemaElement{
+		{AttributeName: aws.String(index.HashKey), KeyType: types.KeyTypeHash},
+	}
+	if index.RangeKey != "" {
+		schema = append(schema, types.KeySchemaElement{
+			AttributeName: aws.String(index.RangeKey),
+			KeyType:       types.KeyTypeRange,
+		})
+	}
+	return schema
+}
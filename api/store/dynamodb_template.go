@@ -0,0 +1,247 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+)
+
+// DynamoDBTemplateStore stores cluster templates in two DynamoDB tables: one
+// item per template holding its latest revision, and one item per revision
+// keyed by template ID and revision number
+type DynamoDBTemplateStore struct {
+	client             *dynamodb.Client
+	tableName          string
+	revisionsTableName string
+}
+
+// NewDynamoDBTemplateStore creates a new DynamoDB-backed template store
+func NewDynamoDBTemplateStore(client *dynamodb.Client, tableName, revisionsTableName string) *DynamoDBTemplateStore {
+	return &DynamoDBTemplateStore{
+		client:             client,
+		tableName:          tableName,
+		revisionsTableName: revisionsTableName,
+	}
+}
+
+// EnsureTable creates the templates and template revisions tables if they
+// are missing
+func (s *DynamoDBTemplateStore) EnsureTable(ctx context.Context) error {
+	if err := ensureTable(ctx, s.client, tableSpec{Name: s.tableName, HashKey: "ID"}); err != nil {
+		return err
+	}
+	return ensureTable(ctx, s.client, tableSpec{Name: s.revisionsTableName, HashKey: "ID", RangeKey: "Revision"})
+}
+
+// Get returns the latest revision of the template with the given ID
+func (s *DynamoDBTemplateStore) Get(ctx context.Context, templateID string) (models.ClusterTemplate, error) {
+	return s.getItem(ctx, s.tableName, map[string]types.AttributeValue{
+		"ID": &types.AttributeValueMemberS{Value: templateID},
+	})
+}
+
+// GetRevision returns one revision of a template
+func (s *DynamoDBTemplateStore) GetRevision(ctx context.Context, templateID string, revision int64) (models.ClusterTemplate, error) {
+	return s.getItem(ctx, s.revisionsTableName, map[string]types.AttributeValue{
+		"ID":       &types.AttributeValueMemberS{Value: templateID},
+		"Revision": &types.AttributeValueMemberN{Value: strconv.FormatInt(revision, 10)},
+	})
+}
+
+// ListRevisions returns every revision of a template, oldest first
+func (s *DynamoDBTemplateStore) ListRevisions(ctx context.Context, templateID string) ([]models.ClusterTemplate, error) {
+	templates := []models.ClusterTemplate{}
+
+	paginator := dynamodb.NewQueryPaginator(s.client, &dynamodb.QueryInput{
+		TableName:              aws.String(s.revisionsTableName),
+		KeyConditionExpression: aws.String("ID = :id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":id": &types.AttributeValueMemberS{Value: templateID},
+		},
+	})
+	for paginator.HasMorePages() {
+		result, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query template revisions: %w", err)
+		}
+
+		var batch []models.ClusterTemplate
+		if err := attributevalue.UnmarshalListOfMaps(result.Items, &batch); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal template revisions: %w", err)
+		}
+		templates = append(templates, batch...)
+	}
+
+	if len(templates) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return templates, nil
+}
+
+// List returns the latest revision of every non-deleted template sorted by
+// name. The template catalog is small, so a scan is sufficient.
+func (s *DynamoDBTemplateStore) List(ctx context.Context) ([]models.ClusterTemplate, error) {
+	templates := []models.ClusterTemplate{}
+
+	paginator := dynamodb.NewScanPaginator(s.client, &dynamodb.ScanInput{
+		TableName:                aws.String(s.tableName),
+		FilterExpression:         aws.String("attribute_not_exists(#deletedAt)"),
+		ExpressionAttributeNames: map[string]string{"#deletedAt": "DeletedAt"},
+	})
+	for paginator.HasMorePages() {
+		result, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan templates: %w", err)
+		}
+
+		var batch []models.ClusterTemplate
+		if err := attributevalue.UnmarshalListOfMaps(result.Items, &batch); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal templates: %w", err)
+		}
+		templates = append(templates, batch...)
+	}
+
+	sortTemplates(templates)
+	return templates, nil
+}
+
+// Create stores a new template as revision 1
+func (s *DynamoDBTemplateStore) Create(ctx context.Context, template *models.ClusterTemplate) error {
+	next := *template
+	next.Revision = 1
+
+	if err := s.putRevision(ctx, next, "attribute_not_exists(ID)", nil); err != nil {
+		return err
+	}
+
+	template.Revision = next.Revision
+	return nil
+}
+
+// CreateRevision stores the template as its next revision
+func (s *DynamoDBTemplateStore) CreateRevision(ctx context.Context, template *models.ClusterTemplate) error {
+	next := *template
+	next.Revision = template.Revision + 1
+
+	err := s.putRevision(ctx, next,
+		"attribute_exists(ID) AND #revision = :expected AND attribute_not_exists(#deletedAt)",
+		map[string]types.AttributeValue{
+			":expected": &types.AttributeValueMemberN{Value: strconv.FormatInt(template.Revision, 10)},
+		})
+	if err != nil {
+		return err
+	}
+
+	template.Revision = next.Revision
+	return nil
+}
+
+// SoftDelete marks a template as deleted
+func (s *DynamoDBTemplateStore) SoftDelete(ctx context.Context, template *models.ClusterTemplate) error {
+	now := time.Now().UTC()
+
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: template.ID},
+		},
+		ConditionExpression:      aws.String("attribute_exists(ID) AND #revision = :expected"),
+		UpdateExpression:         aws.String("SET #deletedAt = :deleted"),
+		ExpressionAttributeNames: map[string]string{"#revision": "Revision", "#deletedAt": "DeletedAt"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":expected": &types.AttributeValueMemberN{Value: strconv.FormatInt(template.Revision, 10)},
+			":deleted":  &types.AttributeValueMemberS{Value: now.Format(time.RFC3339Nano)},
+		},
+	})
+	if err != nil {
+		return translateConditionError(err, ErrVersionConflict, "failed to delete template")
+	}
+
+	template.DeletedAt = &now
+	return nil
+}
+
+// getItem reads a single template item from the given table
+func (s *DynamoDBTemplateStore) getItem(ctx context.Context, tableName string, key map[string]types.AttributeValue) (models.ClusterTemplate, error) {
+	var template models.ClusterTemplate
+
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key:       key,
+	})
+	if err != nil {
+		return template, fmt.Errorf("failed to get template: %w", err)
+	}
+	if result.Item == nil {
+		return template, ErrNotFound
+	}
+
+	if err := attributevalue.UnmarshalMap(result.Item, &template); err != nil {
+		return template, fmt.Errorf("failed to unmarshal template: %w", err)
+	}
+
+	return template, nil
+}
+
+// putRevision atomically writes a new revision item and replaces the latest
+// revision item, provided the latter still satisfies condition
+func (s *DynamoDBTemplateStore) putRevision(ctx context.Context, template models.ClusterTemplate, condition string, values map[string]types.AttributeValue) error {
+	item, err := attributevalue.MarshalMap(template)
+	if err != nil {
+		return fmt.Errorf("failed to marshal template: %w", err)
+	}
+
+	var names map[string]string
+	if values != nil {
+		names = map[string]string{"#revision": "Revision", "#deletedAt": "DeletedAt"}
+	}
+
+	_, err = s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Put: &types.Put{
+					TableName:           aws.String(s.revisionsTableName),
+					Item:                item,
+					ConditionExpression: aws.String("attribute_not_exists(ID)"),
+				},
+			},
+			{
+				Put: &types.Put{
+					TableName:                 aws.String(s.tableName),
+					Item:                      item,
+					ConditionExpression:       aws.String(condition),
+					ExpressionAttributeNames:  names,
+					ExpressionAttributeValues: values,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return translateTransactionError(err, "failed to save template")
+	}
+
+	return nil
+}
+
+// translateTransactionError maps a transaction cancelled by a failed
+// condition to ErrVersionConflict and wraps anything else with message
+func translateTransactionError(err error, message string) error {
+	var cancelled *types.TransactionCanceledException
+	if errors.As(err, &cancelled) {
+		for _, reason := range cancelled.CancellationReasons {
+			if aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+				return ErrVersionConflict
+			}
+		}
+	}
+	return fmt.Errorf("%s: %w", message, err)
+}
@@ -0,0 +1,253 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+)
+
+// DynamoDBUserStore stores users in a DynamoDB table
+type DynamoDBUserStore struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+// NewDynamoDBUserStore creates a new DynamoDB-backed user store
+func NewDynamoDBUserStore(client *dynamodb.Client, tableName string) *DynamoDBUserStore {
+	return &DynamoDBUserStore{
+		client:    client,
+		tableName: tableName,
+	}
+}
+
+// EnsureTable creates the users table if it is missing
+func (s *DynamoDBUserStore) EnsureTable(ctx context.Context) error {
+	return ensureTable(ctx, s.client, tableSpec{Name: s.tableName, HashKey: "ID"})
+}
+
+// Get returns the user with the given ID
+func (s *DynamoDBUserStore) Get(ctx context.Context, userID string) (models.User, error) {
+	var user models.User
+	err := getRecord(ctx, s.client, s.tableName, userID, &user)
+	return user, err
+}
+
+// List returns all non-deleted users sorted by name
+func (s *DynamoDBUserStore) List(ctx context.Context) ([]models.User, error) {
+	users := []models.User{}
+	if err := scanRecords(ctx, s.client, s.tableName, func(items []map[string]types.AttributeValue) error {
+		var batch []models.User
+		if err := attributevalue.UnmarshalListOfMaps(items, &batch); err != nil {
+			return err
+		}
+		users = append(users, batch...)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	sortUsers(users)
+	return users, nil
+}
+
+// Create stores a new user
+func (s *DynamoDBUserStore) Create(ctx context.Context, user *models.User) error {
+	user.Version = 1
+	return createRecord(ctx, s.client, s.tableName, user)
+}
+
+// Update replaces an existing user if its version is unchanged
+func (s *DynamoDBUserStore) Update(ctx context.Context, user *models.User) error {
+	next := *user
+	next.Version = user.Version + 1
+
+	if err := updateRecord(ctx, s.client, s.tableName, next, user.Version); err != nil {
+		return err
+	}
+
+	user.Version = next.Version
+	return nil
+}
+
+// SoftDelete marks a user as deleted
+func (s *DynamoDBUserStore) SoftDelete(ctx context.Context, user *models.User) error {
+	now := time.Now().UTC()
+	user.UpdatedAt = now
+	user.DeletedAt = &now
+
+	return s.Update(ctx, user)
+}
+
+// DynamoDBTeamStore stores teams, with their membership embedded, in a
+// DynamoDB table
+type DynamoDBTeamStore struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+// NewDynamoDBTeamStore creates a new DynamoDB-backed team store
+func NewDynamoDBTeamStore(client *dynamodb.Client, tableName string) *DynamoDBTeamStore {
+	return &DynamoDBTeamStore{
+		client:    client,
+		tableName: tableName,
+	}
+}
+
+// EnsureTable creates the teams table if it is missing
+func (s *DynamoDBTeamStore) EnsureTable(ctx context.Context) error {
+	return ensureTable(ctx, s.client, tableSpec{Name: s.tableName, HashKey: "ID"})
+}
+
+// Get returns the team with the given ID
+func (s *DynamoDBTeamStore) Get(ctx context.Context, teamID string) (models.Team, error) {
+	var team models.Team
+	err := getRecord(ctx, s.client, s.tableName, teamID, &team)
+	return team, err
+}
+
+// List returns all non-deleted teams sorted by name
+func (s *DynamoDBTeamStore) List(ctx context.Context) ([]models.Team, error) {
+	teams := []models.Team{}
+	if err := scanRecords(ctx, s.client, s.tableName, func(items []map[string]types.AttributeValue) error {
+		var batch []models.Team
+		if err := attributevalue.UnmarshalListOfMaps(items, &batch); err != nil {
+			return err
+		}
+		teams = append(teams, batch...)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to list teams: %w", err)
+	}
+
+	sortTeams(teams)
+	return teams, nil
+}
+
+// ListForUser returns the non-deleted teams the user is a member of. Teams
+// are few enough that filtering a full listing is cheaper than maintaining a
+// membership index.
+func (s *DynamoDBTeamStore) ListForUser(ctx context.Context, userID string) ([]models.Team, error) {
+	teams, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return teamsWithMember(teams, userID), nil
+}
+
+// Create stores a new team
+func (s *DynamoDBTeamStore) Create(ctx context.Context, team *models.Team) error {
+	team.Version = 1
+	return createRecord(ctx, s.client, s.tableName, team)
+}
+
+// Update replaces an existing team if its version is unchanged
+func (s *DynamoDBTeamStore) Update(ctx context.Context, team *models.Team) error {
+	next := *team
+	next.Version = team.Version + 1
+
+	if err := updateRecord(ctx, s.client, s.tableName, next, team.Version); err != nil {
+		return err
+	}
+
+	team.Version = next.Version
+	return nil
+}
+
+// SoftDelete marks a team as deleted
+func (s *DynamoDBTeamStore) SoftDelete(ctx context.Context, team *models.Team) error {
+	now := time.Now().UTC()
+	team.UpdatedAt = now
+	team.DeletedAt = &now
+
+	return s.Update(ctx, team)
+}
+
+// getRecord reads the item with the given ID into out
+func getRecord(ctx context.Context, client *dynamodb.Client, tableName, id string, out interface{}) error {
+	result, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get item from %s: %w", tableName, err)
+	}
+	if result.Item == nil {
+		return ErrNotFound
+	}
+
+	if err := attributevalue.UnmarshalMap(result.Item, out); err != nil {
+		return fmt.Errorf("failed to unmarshal item from %s: %w", tableName, err)
+	}
+	return nil
+}
+
+// scanRecords passes every non-deleted item of the table to collect, a page
+// at a time
+func scanRecords(ctx context.Context, client *dynamodb.Client, tableName string, collect func([]map[string]types.AttributeValue) error) error {
+	paginator := dynamodb.NewScanPaginator(client, &dynamodb.ScanInput{
+		TableName:                aws.String(tableName),
+		FilterExpression:         aws.String("attribute_not_exists(#deletedAt)"),
+		ExpressionAttributeNames: map[string]string{"#deletedAt": "DeletedAt"},
+	})
+	for paginator.HasMorePages() {
+		result, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		if err := collect(result.Items); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createRecord writes a new item, failing with ErrVersionConflict if an item
+// with the same ID exists
+func createRecord(ctx context.Context, client *dynamodb.Client, tableName string, record interface{}) error {
+	item, err := attributevalue.MarshalMap(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal item for %s: %w", tableName, err)
+	}
+
+	_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(ID)"),
+	})
+	if err != nil {
+		return translateConditionError(err, ErrVersionConflict, "failed to save item to "+tableName)
+	}
+	return nil
+}
+
+// updateRecord replaces an existing item if its stored Version still equals
+// expectedVersion
+func updateRecord(ctx context.Context, client *dynamodb.Client, tableName string, record interface{}, expectedVersion int64) error {
+	item, err := attributevalue.MarshalMap(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal item for %s: %w", tableName, err)
+	}
+
+	_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                aws.String(tableName),
+		Item:                     item,
+		ConditionExpression:      aws.String("attribute_exists(ID) AND #version = :expected"),
+		ExpressionAttributeNames: map[string]string{"#version": "Version"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":expected": &types.AttributeValueMemberN{Value: strconv.FormatInt(expectedVersion, 10)},
+		},
+	})
+	if err != nil {
+		return translateConditionError(err, ErrVersionConflict, "failed to save item to "+tableName)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+)
+
+// environmentStores returns an environment store of each backend, each with
+// its own empty database
+func environmentStores(t *testing.T) map[string]EnvironmentStore {
+	t.Helper()
+
+	db, err := OpenBolt(filepath.Join(t.TempDir(), "provisioner.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	boltStore, err := NewBoltEnvironmentStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dynamoStore := NewDynamoDBEnvironmentStore(newFakeDynamoDB(t), "environments")
+	if err := dynamoStore.EnsureTable(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]EnvironmentStore{"bolt": boltStore, "dynamodb": dynamoStore}
+}
+
+// testEnvironments returns environments created within two seconds. The
+// creation times of the first four fall in one second and have fractions of
+// different lengths, which RFC 3339 strings do not order correctly.
+func testEnvironments() []models.Environment {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	return []models.Environment{
+		{ID: "env-1", Name: "web", Status: models.StateActive, UserID: "u1", CreatedAt: created.Add(120 * time.Millisecond)},
+		{ID: "env-2", Name: "api", Status: models.StateProvisioning, UserID: "u1", CreatedAt: created.Add(100 * time.Millisecond)},
+		{ID: "env-3", Name: "web", Status: models.StateActive, UserID: "u2", TeamID: "t1", CreatedAt: created},
+		{ID: "env-4", Name: "web-api", Status: models.StateError, UserID: "u1", TeamID: "t1", CreatedAt: created.Add(time.Second)},
+		{ID: "env-5", Name: "api v2", Status: models.StateActive, UserID: "u1", CreatedAt: created.Add(123456789 * time.Nanosecond)},
+		{ID: "env-6", Name: "db", Status: models.StateError, UserID: "u3", CreatedAt: created.Add(2 * time.Second)},
+	}
+}
+
+// listAll reads every page of a listing, limit environments at a time, and
+// returns the IDs in the order they were listed
+func listAll(t *testing.T, environmentStore EnvironmentStore, filter EnvironmentFilter, page PageRequest) []string {
+	t.Helper()
+
+	ids := []string{}
+	for i := 0; ; i++ {
+		if i > 10 {
+			t.Fatal("listing did not end")
+		}
+		list, err := environmentStore.List(context.Background(), filter, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list.Items) > page.Limit {
+			t.Fatalf("got %d environments, want at most %d", len(list.Items), page.Limit)
+		}
+		for _, env := range list.Items {
+			ids = append(ids, env.ID)
+		}
+		if list.NextCursor == "" {
+			return ids
+		}
+		page.Cursor = list.NextCursor
+	}
+}
+
+func TestListSortOrders(t *testing.T) {
+	owned := EnvironmentFilter{UserID: "u1", TeamIDs: []string{"t1"}}
+	active := EnvironmentFilter{Status: models.StateActive}
+
+	tests := []struct {
+		name   string
+		filter EnvironmentFilter
+		sortBy string
+		want   []string
+	}{
+		{"all by creation", EnvironmentFilter{}, SortByCreatedAt, []string{"env-3", "env-2", "env-1", "env-5", "env-4", "env-6"}},
+		{"all by name", EnvironmentFilter{}, SortByName, []string{"env-2", "env-5", "env-6", "env-1", "env-3", "env-4"}},
+		{"all by status", EnvironmentFilter{}, SortByStatus, []string{"env-1", "env-3", "env-5", "env-4", "env-6", "env-2"}},
+		{"owned by creation", owned, SortByCreatedAt, []string{"env-3", "env-2", "env-1", "env-5", "env-4"}},
+		{"owned by name", owned, SortByName, []string{"env-2", "env-5", "env-1", "env-3", "env-4"}},
+		{"owned by status", owned, SortByStatus, []string{"env-1", "env-3", "env-5", "env-4", "env-2"}},
+		{"active by creation", active, SortByCreatedAt, []string{"env-3", "env-1", "env-5"}},
+		{"active by name", active, SortByName, []string{"env-5", "env-1", "env-3"}},
+		{"active by status", active, SortByStatus, []string{"env-1", "env-3", "env-5"}},
+	}
+
+	for backend, environmentStore := range environmentStores(t) {
+		for _, env := range testEnvironments() {
+			if err := environmentStore.Create(context.Background(), &env); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, tt := range tests {
+			t.Run(backend+"/"+tt.name, func(t *testing.T) {
+				for _, limit := range []int{1, 2, 50} {
+					got := listAll(t, environmentStore, tt.filter, PageRequest{Limit: limit, SortBy: tt.sortBy})
+					if !reflect.DeepEqual(got, tt.want) {
+						t.Errorf("ascending with limit %d = %v, want %v", limit, got, tt.want)
+					}
+
+					want := make([]string, len(tt.want))
+					for i, id := range tt.want {
+						want[len(want)-1-i] = id
+					}
+					got = listAll(t, environmentStore, tt.filter, PageRequest{Limit: limit, SortBy: tt.sortBy, Descending: true})
+					if !reflect.DeepEqual(got, want) {
+						t.Errorf("descending with limit %d = %v, want %v", limit, got, want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestListSortedByChangedStatus(t *testing.T) {
+	for backend, environmentStore := range environmentStores(t) {
+		t.Run(backend, func(t *testing.T) {
+			for _, env := range testEnvironments() {
+				if err := environmentStore.Create(context.Background(), &env); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := environmentStore.UpdateStatus(context.Background(), "env-2", models.StateActive, ""); err != nil {
+				t.Fatal(err)
+			}
+
+			got := listAll(t, environmentStore, EnvironmentFilter{}, PageRequest{Limit: 2, SortBy: SortByStatus})
+			want := []string{"env-1", "env-2", "env-3", "env-5", "env-4", "env-6"}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestEnsureTableAddsSortKeys(t *testing.T) {
+	client := newFakeDynamoDB(t)
+	environmentStore := NewDynamoDBEnvironmentStore(client, "environments")
+	if err := environmentStore.EnsureTable(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Environments stored before the sort keys were introduced have none
+	for _, env := range testEnvironments() {
+		item, err := attributevalue.MarshalMap(env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.PutItem(context.Background(), &dynamodb.PutItemInput{
+			TableName: aws.String("environments"),
+			Item:      item,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := listAll(t, environmentStore, EnvironmentFilter{}, PageRequest{Limit: 50}); len(got) != 0 {
+		t.Fatalf("listed %v before the sort keys were added", got)
+	}
+
+	if err := environmentStore.EnsureTable(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got := listAll(t, environmentStore, EnvironmentFilter{}, PageRequest{Limit: 2, SortBy: SortByName})
+	want := []string{"env-2", "env-5", "env-6", "env-1", "env-3", "env-4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	env, err := environmentStore.Get(context.Background(), "env-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Version != 0 {
+		t.Errorf("adding sort keys changed the version to %d", env.Version)
+	}
+}
+
+func TestUpdateStatusTransitions(t *testing.T) {
+	states := models.EnvironmentLifecycle().States
+	for backend, environmentStore := range environmentStores(t) {
+		t.Run(backend, func(t *testing.T) {
+			for _, from := range states {
+				for _, to := range states {
+					env := models.Environment{ID: string(from) + "-" + string(to), Name: "env", Status: from, UserID: "u1"}
+					if err := environmentStore.Create(context.Background(), &env); err != nil {
+						t.Fatal(err)
+					}
+
+					err := environmentStore.UpdateStatus(context.Background(), env.ID, to, "moved")
+					want := from
+					if from.CanTransition(to) {
+						if err != nil {
+							t.Errorf("%s to %s: %v", from, to, err)
+						}
+						want = to
+					} else if !errors.Is(err, models.ErrInvalidTransition) {
+						t.Errorf("%s to %s: got error %v, want ErrInvalidTransition", from, to, err)
+					}
+
+					stored, err := environmentStore.Get(context.Background(), env.ID)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if stored.Status != want {
+						t.Errorf("%s to %s: stored status %s, want %s", from, to, stored.Status, want)
+					}
+				}
+			}
+
+			err := environmentStore.UpdateStatus(context.Background(), "missing", models.StateActive, "")
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("missing environment: got error %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+)
+
+// Sort fields accepted by List
+const (
+	SortByCreatedAt = "createdAt"
+	SortByName      = "name"
+	SortByStatus    = "status"
+)
+
+// Page size bounds for List
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// ErrInvalidCursor is returned when a continuation token cannot be decoded or
+// was issued for a different sort order
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// PageRequest selects one page of a sorted listing
+type PageRequest struct {
+	Limit      int
+	Cursor     string
+	SortBy     string
+	Descending bool
+}
+
+// cursor is the decoded form of the opaque continuation token. It records the
+// position of the last item returned so that the next page starts right after
+// it even if environments were created or deleted in between.
+type cursor struct {
+	SortBy     string `json:"s"`
+	Descending bool   `json:"d"`
+	Key        string `json:"k"`
+	ID         string `json:"i"`
+}
+
+// ValidSortField reports whether field can be used as PageRequest.SortBy
+func ValidSortField(field string) bool {
+	switch field {
+	case SortByCreatedAt, SortByName, SortByStatus:
+		return true
+	}
+	return false
+}
+
+// paginate sorts environments and returns the page selected by the request
+func paginate(environments []models.Environment, page PageRequest) (models.EnvironmentList, error) {
+	if page.SortBy == "" {
+		page.SortBy = SortByCreatedAt
+	}
+	if !ValidSortField(page.SortBy) {
+		return models.EnvironmentList{}, fmt.Errorf("unsupported sort field %q", page.SortBy)
+	}
+	if page.Limit <= 0 {
+		page.Limit = DefaultPageSize
+	}
+
+	// before orders by sort key, then by ID so that ties are stable
+	before := func(aKey, aID, bKey, bID string) bool {
+		if aKey == bKey {
+			aKey, bKey = aID, bID
+		}
+		if page.Descending {
+			return aKey > bKey
+		}
+		return aKey < bKey
+	}
+
+	sort.Slice(environments, func(i, j int) bool {
+		return before(sortKey(environments[i], page.SortBy), environments[i].ID,
+			sortKey(environments[j], page.SortBy), environments[j].ID)
+	})
+
+	start := 0
+	if page.Cursor != "" {
+		after, err := decodeCursor(page.Cursor)
+		if err != nil {
+			return models.EnvironmentList{}, err
+		}
+		if after.SortBy != page.SortBy || after.Descending != page.Descending {
+			return models.EnvironmentList{}, ErrInvalidCursor
+		}
+
+		start = sort.Search(len(environments), func(i int) bool {
+			return before(after.Key, after.ID, sortKey(environments[i], page.SortBy), environments[i].ID)
+		})
+	}
+
+	end := start + page.Limit
+	if end > len(environments) {
+		end = len(environments)
+	}
+
+	result := models.EnvironmentList{Items: environments[start:end]}
+	if end < len(environments) {
+		last := environments[end-1]
+		result.NextCursor = encodeCursor(cursor{
+			SortBy:     page.SortBy,
+			Descending: page.Descending,
+			Key:        sortKey(last, page.SortBy),
+			ID:         last.ID,
+		})
+	}
+
+	return result, nil
+}
+
+// sortKey returns a string that orders environments lexically by field
+func sortKey(env models.Environment, field string) string {
+	switch field {
+	case SortByName:
+		return env.Name
+	case SortByStatus:
+		return string(env.Status)
+	default:
+		return fmt.Sprintf("%020d", env.CreatedAt.UnixNano())
+	}
+}
+
+// encodeCursor turns a cursor into an opaque continuation token
+func encodeCursor(c cursor) string {
+	return encodeToken(c)
+}
+
+// decodeCursor parses a continuation token produced by encodeCursor
+func decodeCursor(token string) (cursor, error) {
+	var c cursor
+	if err := decodeToken(token, &c); err != nil || c.ID == "" {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// encodeToken turns the position of a listing into an opaque continuation
+// token
+func encodeToken(position interface{}) string {
+	data, _ := json.Marshal(position)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeToken parses a continuation token produced by encodeToken into
+// position, returning ErrInvalidCursor if it is malformed
+func decodeToken(token string, position interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, position); err != nil {
+		return ErrInvalidCursor
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	want := cursor{SortBy: SortByName, Descending: true, Key: "web", ID: "env-1"}
+	got, err := decodeCursor(encodeCursor(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	for _, token := range []string{
+		"not base64!",
+		base64.RawURLEncoding.EncodeToString([]byte("not json")),
+		encodeCursor(cursor{SortBy: SortByName, Key: "web"}),
+	} {
+		if _, err := decodeCursor(token); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("decoding %q: got error %v, want ErrInvalidCursor", token, err)
+		}
+	}
+}
+
+func TestListResumesAfterCursor(t *testing.T) {
+	for backend, environmentStore := range environmentStores(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			for _, env := range testEnvironments() {
+				if err := environmentStore.Create(ctx, &env); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			page := PageRequest{Limit: 2, SortBy: SortByCreatedAt}
+			first, err := environmentStore.List(ctx, EnvironmentFilter{}, page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := environmentIDs(first); !reflect.DeepEqual(got, []string{"env-3", "env-2"}) {
+				t.Fatalf("first page %v, want [env-3 env-2]", got)
+			}
+
+			// Between pages, an environment is created before the cursor and
+			// one after it, one already listed and one not yet listed are
+			// deleted
+			created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+			for _, env := range []models.Environment{
+				{ID: "env-0", Name: "early", Status: models.StateActive, UserID: "u1", CreatedAt: created.Add(-time.Hour)},
+				{ID: "env-7", Name: "late", Status: models.StateActive, UserID: "u1", CreatedAt: created.Add(time.Hour)},
+			} {
+				if err := environmentStore.Create(ctx, &env); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, id := range []string{"env-3", "env-5"} {
+				env, err := environmentStore.Get(ctx, id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := environmentStore.SoftDelete(ctx, &env); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			page.Cursor = first.NextCursor
+			got := listAll(t, environmentStore, EnvironmentFilter{}, page)
+			want := []string{"env-1", "env-4", "env-6", "env-7"}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("after the cursor got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestListRejectsForeignCursor(t *testing.T) {
+	for backend, environmentStore := range environmentStores(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			for _, env := range testEnvironments() {
+				if err := environmentStore.Create(ctx, &env); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			first, err := environmentStore.List(ctx, EnvironmentFilter{}, PageRequest{Limit: 2, SortBy: SortByName})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if first.NextCursor == "" {
+				t.Fatal("first page has no cursor")
+			}
+
+			for name, page := range map[string]PageRequest{
+				"other sort":  {Limit: 2, SortBy: SortByStatus, Cursor: first.NextCursor},
+				"other order": {Limit: 2, SortBy: SortByName, Descending: true, Cursor: first.NextCursor},
+				"malformed":   {Limit: 2, SortBy: SortByName, Cursor: "not a cursor"},
+			} {
+				if _, err := environmentStore.List(ctx, EnvironmentFilter{}, page); !errors.Is(err, ErrInvalidCursor) {
+					t.Errorf("%s: got error %v, want ErrInvalidCursor", name, err)
+				}
+			}
+		})
+	}
+}
+
+// environmentIDs returns the IDs of a page's environments in order
+func environmentIDs(list models.EnvironmentList) []string {
+	ids := []string{}
+	for _, env := range list.Items {
+		ids = append(ids, env.ID)
+	}
+	return ids
+}
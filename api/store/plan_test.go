@@ -0,0 +1,124 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+)
+
+// planStores returns a plan store of each backend, each with its own empty
+// database
+func planStores(t *testing.T) map[string]PlanStore {
+	t.Helper()
+
+	db, err := OpenBolt(filepath.Join(t.TempDir(), "provisioner.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	boltStore, err := NewBoltPlanStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dynamoStore := NewDynamoDBPlanStore(newFakeDynamoDB(t), "environment_plans")
+	if err := dynamoStore.EnsureTable(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]PlanStore{"bolt": boltStore, "dynamodb": dynamoStore}
+}
+
+// testPlan returns a plan of an environment with a file of size bytes
+func testPlan(envID, planID string, size int, expiresAt time.Time) models.Plan {
+	file := make([]byte, size)
+	for i := range file {
+		file[i] = byte(i % 251)
+	}
+	return models.Plan{
+		ID:            planID,
+		EnvironmentID: envID,
+		Tool:          "terraform",
+		Summary:       models.PlanSummary{Add: 1, Replace: 1, Destroy: 1},
+		Changes: []models.ResourceChange{
+			{Address: "aws_kms_key.eks", Type: "aws_kms_key", Name: "eks", Action: "replace", ReplacePaths: []string{"key_usage"}},
+		},
+		CreatedAt:  expiresAt.Add(-24 * time.Hour),
+		ExpiresAt:  expiresAt,
+		VarsDigest: "digest",
+		File:       file,
+	}
+}
+
+func TestPlanStore(t *testing.T) {
+	ctx := context.Background()
+	future := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+
+	for name, plans := range planStores(t) {
+		t.Run(name, func(t *testing.T) {
+			tests := []struct {
+				name string
+				size int
+			}{
+				{"small file", 1024},
+				{"file over one DynamoDB item", 2*planChunkSize + 17},
+				{"empty file", 0},
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					plan := testPlan("env-1", tt.name, tt.size, future)
+					if err := plans.Create(ctx, &plan); err != nil {
+						t.Fatal(err)
+					}
+
+					got, err := plans.Get(ctx, "env-1", plan.ID)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(got.File, plan.File) {
+						t.Fatalf("got a file of %d bytes, want the %d bytes stored", len(got.File), len(plan.File))
+					}
+					if got.Summary != plan.Summary || len(got.Changes) != 1 || got.Changes[0].ReplacePaths[0] != "key_usage" || !got.ExpiresAt.Equal(plan.ExpiresAt) {
+						t.Fatalf("got %+v, want %+v", got, plan)
+					}
+
+					// A plan is only found under its own environment
+					if _, err := plans.Get(ctx, "env-2", plan.ID); !errors.Is(err, ErrNotFound) {
+						t.Fatalf("got %v for another environment, want ErrNotFound", err)
+					}
+
+					if err := plans.Delete(ctx, "env-1", plan.ID); err != nil {
+						t.Fatal(err)
+					}
+					if _, err := plans.Get(ctx, "env-1", plan.ID); !errors.Is(err, ErrNotFound) {
+						t.Fatalf("got %v after delete, want ErrNotFound", err)
+					}
+					if err := plans.Delete(ctx, "env-1", plan.ID); err != nil {
+						t.Fatalf("deleting a deleted plan: %v", err)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestPlanStoreExpired(t *testing.T) {
+	ctx := context.Background()
+
+	for name, plans := range planStores(t) {
+		t.Run(name, func(t *testing.T) {
+			plan := testPlan("env-1", "expired", 10, time.Now().UTC().Add(-time.Minute))
+			if err := plans.Create(ctx, &plan); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := plans.Get(ctx, "env-1", plan.ID); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("got %v for an expired plan, want ErrNotFound", err)
+			}
+		})
+	}
+}
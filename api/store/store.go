@@ -0,0 +1,318 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+)
+
+// ErrNotFound is returned when a requested record does not exist
+var ErrNotFound = errors.New("record not found")
+
+// ErrVersionConflict is returned when a conditional write finds that the
+// record was modified since it was read
+var ErrVersionConflict = errors.New("record version conflict")
+
+// ErrLocked is returned when another operation holds an environment's lock
+var ErrLocked = errors.New("environment locked by another operation")
+
+// ErrLockLost is returned when renewing or releasing a lock that was broken
+// or has expired and been taken by another operation
+var ErrLockLost = errors.New("environment lock lost")
+
+// EnvironmentFilter narrows the environments returned by List. UserID and
+// TeamIDs select owners and are OR'ed together: an environment matches if it
+// belongs to the user or to any of the teams.
+type EnvironmentFilter struct {
+	UserID  string
+	TeamIDs []string
+	Status  models.EnvironmentState
+
+	// IncludeDeleted also returns soft-deleted environments, for reports
+	// covering past usage
+	IncludeDeleted bool
+}
+
+// matchesOwner reports whether env belongs to the filter's user or teams
+func (f EnvironmentFilter) matchesOwner(env models.Environment) bool {
+	if f.UserID == "" && len(f.TeamIDs) == 0 {
+		return true
+	}
+	if f.UserID != "" && env.UserID == f.UserID {
+		return true
+	}
+	for _, teamID := range f.TeamIDs {
+		if env.TeamID != "" && env.TeamID == teamID {
+			return true
+		}
+	}
+	return false
+}
+
+// EnvironmentStore persists environments
+type EnvironmentStore interface {
+	// Get returns the environment with the given ID, including soft-deleted ones
+	Get(ctx context.Context, envID string) (models.Environment, error)
+
+	// List returns one sorted page of the environments that match the
+	// filter, leaving out soft-deleted ones unless the filter includes them
+	List(ctx context.Context, filter EnvironmentFilter, page PageRequest) (models.EnvironmentList, error)
+
+	// Create stores a new environment at version 1
+	Create(ctx context.Context, env *models.Environment) error
+
+	// Update replaces an existing environment if its stored version still
+	// equals env.Version, and bumps env.Version on success. It returns
+	// ErrVersionConflict if another writer got there first.
+	Update(ctx context.Context, env *models.Environment) error
+
+	// SoftDelete stamps DeletedAt and UpdatedAt on the environment and
+	// persists it with the same version check as Update
+	SoftDelete(ctx context.Context, env *models.Environment) error
+
+	// UpdateStatus moves an environment to a new status with a status
+	// message and bumps its version. It returns an error wrapping
+	// models.ErrInvalidTransition if the lifecycle does not allow the move
+	// from the stored status.
+	UpdateStatus(ctx context.Context, envID string, status models.EnvironmentState, message string) error
+}
+
+// TemplateStore persists cluster templates as a series of immutable
+// revisions. The latest revision of each template doubles as its catalog
+// entry and is the only record that changes, when the template is deleted.
+type TemplateStore interface {
+	// Get returns the latest revision of the template with the given ID,
+	// including soft-deleted templates so that environments created from them
+	// can still be resolved
+	Get(ctx context.Context, templateID string) (models.ClusterTemplate, error)
+
+	// GetRevision returns one revision of a template
+	GetRevision(ctx context.Context, templateID string, revision int64) (models.ClusterTemplate, error)
+
+	// ListRevisions returns every revision of a template, oldest first
+	ListRevisions(ctx context.Context, templateID string) ([]models.ClusterTemplate, error)
+
+	// List returns the latest revision of every template that is not
+	// soft-deleted, sorted by name
+	List(ctx context.Context) ([]models.ClusterTemplate, error)
+
+	// Create stores a new template as revision 1
+	Create(ctx context.Context, template *models.ClusterTemplate) error
+
+	// CreateRevision stores template as the next revision if the latest
+	// stored revision still equals template.Revision, and bumps
+	// template.Revision on success. It returns ErrVersionConflict if another
+	// revision was created first.
+	CreateRevision(ctx context.Context, template *models.ClusterTemplate) error
+
+	// SoftDelete stamps DeletedAt and UpdatedAt on the template if its latest
+	// revision still equals template.Revision
+	SoftDelete(ctx context.Context, template *models.ClusterTemplate) error
+}
+
+// UserStore persists users
+type UserStore interface {
+	// Get returns the user with the given ID, including soft-deleted ones
+	Get(ctx context.Context, userID string) (models.User, error)
+
+	// List returns the users that are not soft-deleted, sorted by name
+	List(ctx context.Context) ([]models.User, error)
+
+	// Create stores a new user at version 1
+	Create(ctx context.Context, user *models.User) error
+
+	// Update replaces an existing user if its stored version still equals
+	// user.Version, and bumps user.Version on success
+	Update(ctx context.Context, user *models.User) error
+
+	// SoftDelete stamps DeletedAt and UpdatedAt on the user and persists it
+	// with the same version check as Update
+	SoftDelete(ctx context.Context, user *models.User) error
+}
+
+// TeamStore persists teams and their membership
+type TeamStore interface {
+	// Get returns the team with the given ID, including soft-deleted ones
+	Get(ctx context.Context, teamID string) (models.Team, error)
+
+	// List returns the teams that are not soft-deleted, sorted by name
+	List(ctx context.Context) ([]models.Team, error)
+
+	// ListForUser returns the non-deleted teams the user is a member of
+	ListForUser(ctx context.Context, userID string) ([]models.Team, error)
+
+	// Create stores a new team at version 1
+	Create(ctx context.Context, team *models.Team) error
+
+	// Update replaces an existing team, including its membership, if its
+	// stored version still equals team.Version, and bumps team.Version on
+	// success
+	Update(ctx context.Context, team *models.Team) error
+
+	// SoftDelete stamps DeletedAt and UpdatedAt on the team and persists it
+	// with the same version check as Update
+	SoftDelete(ctx context.Context, team *models.Team) error
+}
+
+// JobFilter narrows the jobs returned by JobStore.List. Statuses are OR'ed;
+// an empty filter matches every job.
+type JobFilter struct {
+	EnvironmentID string
+	Statuses      []string
+}
+
+// matches reports whether job passes the filter
+func (f JobFilter) matches(job models.Job) bool {
+	if f.EnvironmentID != "" && job.EnvironmentID != f.EnvironmentID {
+		return false
+	}
+	if len(f.Statuses) == 0 {
+		return true
+	}
+	for _, status := range f.Statuses {
+		if job.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+// JobStore persists background jobs. Claiming a job is an Update that only
+// succeeds for the worker whose copy is current, so concurrent workers never
+// run the same job twice.
+type JobStore interface {
+	// Get returns the job with the given ID
+	Get(ctx context.Context, jobID string) (models.Job, error)
+
+	// List returns the jobs that match the filter, oldest first
+	List(ctx context.Context, filter JobFilter) ([]models.Job, error)
+
+	// Create stores a new job at version 1
+	Create(ctx context.Context, job *models.Job) error
+
+	// Update replaces an existing job if its stored version still equals
+	// job.Version, and bumps job.Version on success. It returns
+	// ErrVersionConflict if another writer got there first.
+	Update(ctx context.Context, job *models.Job) error
+}
+
+// EventFilter narrows the events returned by EventStore.List. Types are
+// OR'ed; zero fields match everything.
+type EventFilter struct {
+	Types []string
+	JobID string
+	Actor string
+	Since time.Time
+	Until time.Time
+}
+
+// matches reports whether event passes the filter
+func (f EventFilter) matches(event models.EnvironmentEvent) bool {
+	if f.JobID != "" && event.JobID != f.JobID {
+		return false
+	}
+	if f.Actor != "" && event.Actor != f.Actor {
+		return false
+	}
+	if !f.Since.IsZero() && event.CreatedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !event.CreatedAt.Before(f.Until) {
+		return false
+	}
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, eventType := range f.Types {
+		if event.Type == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// EventStore persists the append-only event timeline of each environment
+type EventStore interface {
+	// Append stores a new event, assigning its Sequence
+	Append(ctx context.Context, event *models.EnvironmentEvent) error
+
+	// List returns the events of an environment that match the filter, in
+	// the order they were appended
+	List(ctx context.Context, envID string, filter EventFilter) ([]models.EnvironmentEvent, error)
+}
+
+// LogFilter narrows the log lines returned by LogStore.List. Zero fields
+// match everything.
+type LogFilter struct {
+	JobID string
+
+	// AfterSequence skips the lines up to and including this sequence, so
+	// that a follower can pick up where it left off
+	AfterSequence int64
+
+	// Limit caps the number of lines returned; 0 means no cap
+	Limit int
+}
+
+// matches reports whether line passes the filter, ignoring Limit
+func (f LogFilter) matches(line models.LogLine) bool {
+	if f.JobID != "" && line.JobID != f.JobID {
+		return false
+	}
+	return line.Sequence > f.AfterSequence
+}
+
+// LogStore persists the Terraform output of each environment
+type LogStore interface {
+	// Append stores a new log line, assigning its Sequence
+	Append(ctx context.Context, line *models.LogLine) error
+
+	// List returns the log lines of an environment that match the filter, in
+	// the order they were appended
+	List(ctx context.Context, envID string, filter LogFilter) ([]models.LogLine, error)
+}
+
+// LockStore persists the lease-based lock of each environment. Acquiring and
+// renewing are conditional writes, so workers in any number of processes
+// agree on who holds an environment.
+type LockStore interface {
+	// Get returns the lock of an environment, expired or not
+	Get(ctx context.Context, envID string) (models.EnvironmentLock, error)
+
+	// Acquire stores lock as its environment's lock unless another operation
+	// holds an unexpired one, in which case it returns ErrLocked. A lock
+	// held for the same job is taken over, so that a job resumed after its
+	// worker died gets its lock back.
+	Acquire(ctx context.Context, lock models.EnvironmentLock) error
+
+	// Renew replaces the environment's lock with lock, typically with a
+	// later expiry, if it still holds lock.Token. It returns ErrLockLost
+	// otherwise.
+	Renew(ctx context.Context, lock models.EnvironmentLock) error
+
+	// Release deletes the environment's lock if it still holds token. A lock
+	// that is already gone is not an error.
+	Release(ctx context.Context, envID, token string) error
+
+	// Break deletes the environment's lock whoever holds it, returning
+	// ErrNotFound if there is none
+	Break(ctx context.Context, envID string) error
+}
+
+// PlanStore persists saved Terraform plans, with their plan files, where every
+// replica can read them, so that a plan made by one replica can be applied by
+// the worker of another
+type PlanStore interface {
+	// Get returns a plan of an environment with its file, returning
+	// ErrNotFound if there is none or it has expired
+	Get(ctx context.Context, envID, planID string) (models.Plan, error)
+
+	// Create stores a new plan. Expired plans are removed some time after
+	// they expire.
+	Create(ctx context.Context, plan *models.Plan) error
+
+	// Delete removes a plan. A plan that is already gone is not an error.
+	Delete(ctx context.Context, envID, planID string) error
+}
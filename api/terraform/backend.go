@@ -0,0 +1,70 @@
+package terraform
+
+import (
+	"path"
+	"path/filepath"
+)
+
+// stateFile is the name of an environment's state within its backend
+const stateFile = "terraform.tfstate"
+
+// Backend says where Terraform keeps the state of each environment. State is
+// keyed by environment ID so that every environment, and every operation on
+// it, works on its own state.
+type Backend interface {
+	// Config returns the Terraform backend type and its settings for an
+	// environment's state
+	Config(envID string) (string, map[string]interface{})
+}
+
+// LocalBackend keeps each environment's state in a file under Dir. It needs
+// no cloud account, so it suits development and tests, but the state lives
+// only as long as Dir does.
+type LocalBackend struct {
+	Dir string
+}
+
+// Config returns the local backend settings for an environment's state
+func (b LocalBackend) Config(envID string) (string, map[string]interface{}) {
+	return "local", map[string]interface{}{
+		"path": filepath.Join(b.Dir, envID, stateFile),
+	}
+}
+
+// S3Backend keeps each environment's state in an S3 bucket, or in any
+// S3-compatible store such as MinIO when Endpoint is set
+type S3Backend struct {
+	Bucket string
+	Prefix string
+	Region string
+
+	// Endpoint overrides the S3 endpoint for S3-compatible stores, which
+	// are then addressed path-style. The settings used are the ones the
+	// Terraform version in the API image understands.
+	Endpoint string
+
+	// LockTable is an optional DynamoDB table used to lock the state while
+	// Terraform runs
+	LockTable string
+}
+
+// Config returns the S3 backend settings for an environment's state
+func (b S3Backend) Config(envID string) (string, map[string]interface{}) {
+	config := map[string]interface{}{
+		"bucket":  b.Bucket,
+		"key":     path.Join(b.Prefix, envID, stateFile),
+		"region":  b.Region,
+		"encrypt": true,
+	}
+	if b.Endpoint != "" {
+		config["endpoint"] = b.Endpoint
+		config["force_path_style"] = true
+		config["skip_credentials_validation"] = true
+		config["skip_region_validation"] = true
+		config["skip_metadata_api_check"] = true
+	}
+	if b.LockTable != "" {
+		config["dynamodb_table"] = b.LockTable
+	}
+	return "s3", config
+}
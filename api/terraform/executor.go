@@ -2,205 +2,391 @@ package terraform
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
-// Executor manages Terraform operations
+// maxOutputLine is the longest line of command output passed to hooks. Longer
+// lines are cut.
+const maxOutputLine = 64 * 1024
+
+// interruptGracePeriod is how long Terraform is given to stop cleanly after an
+// interrupt, saving its state and releasing its locks, before it is killed
+const interruptGracePeriod = 2 * time.Minute
+
+// DefaultTimeouts bounds the Terraform phases a caller sets no timeout for
+var DefaultTimeouts = Timeouts{
+	"init":    10 * time.Minute,
+	"plan":    30 * time.Minute,
+	"apply":   60 * time.Minute,
+	"destroy": 60 * time.Minute,
+}
+
+// errChangesPresent is returned by a plan run with -detailed-exitcode that
+// succeeded and found changes to make
+var errChangesPresent = errors.New("terraform plan found changes")
+
+// Timeouts bounds each Terraform phase, keyed by subcommand such as "init" or
+// "apply"
+type Timeouts map[string]time.Duration
+
+// Executor manages Terraform operations, and is the Provisioner of every
+// Terraform-compatible tool. Each environment has a stable working directory
+// under workspacePath, and its state is kept in backend.
 type Executor struct {
-	basePath    string
-	statePath   string
-	tfBinary    string
-	environment []string
+	basePath      string
+	workspacePath string
+	backend       Backend
+	tool          string
+	tfBinary      string
+	environment   []string
+	retry         RetryPolicy
+	cache         *ProviderCache
+	timings       *phaseTimings
 }
 
-// NewExecutor creates a new Terraform executor
-func NewExecutor(basePath string) *Executor {
+// Hooks receive progress callbacks while a Terraform command runs. Nil hooks
+// are skipped.
+type Hooks struct {
+	// PhaseStarted is called before a Terraform subcommand such as "init"
+	// or "apply" starts
+	PhaseStarted func(phase string)
+
+	// PhaseFinished is called after the subcommand exits, with how long it
+	// ran and its error, if any
+	PhaseFinished func(phase string, duration time.Duration, err error)
+
+	// Output is called with each line a subcommand writes, as it writes it.
+	// stream is "stdout" or "stderr". Calls are never concurrent.
+	Output func(phase, stream, line string)
+
+	// PhaseRetrying is called when a subcommand failed transiently and will
+	// be run again after delay. attempt counts the runs so far.
+	PhaseRetrying func(phase string, attempt int, delay time.Duration, err *FailureError)
+
+	// PhaseSkipped is called instead of PhaseStarted and PhaseFinished for
+	// a subcommand that did not need to run, with the reason
+	PhaseSkipped func(phase, reason string)
+}
+
+// NewExecutor creates a new Terraform executor running the modules under
+// basePath in per-environment workspaces under workspacePath
+func NewExecutor(basePath, workspacePath string, backend Backend) *Executor {
 	return &Executor{
-		basePath:    basePath,
-		statePath:   "/tmp/terraform-state",
-		tfBinary:    "terraform",
-		environment: os.Environ(),
+		basePath:      basePath,
+		workspacePath: workspacePath,
+		backend:       backend,
+		tool:          ToolTerraform,
+		tfBinary:      "terraform",
+		environment:   os.Environ(),
+		retry:         DefaultRetryPolicy.compile(),
+		timings:       &phaseTimings{},
 	}
 }
 
-// Apply applies Terraform configuration
-func (e *Executor) Apply(module string, vars map[string]interface{}) error {
-	// Create working directory
-	workDir := fmt.Sprintf("%s-%d", module, time.Now().Unix())
-	workPath := filepath.Join(e.statePath, workDir)
-	
-	err := os.MkdirAll(workPath, 0755)
-	if err != nil {
-		return fmt.Errorf("failed to create work directory: %w", err)
-	}
-	
-	// Write variables file
-	varsFile := filepath.Join(workPath, "terraform.tfvars.json")
-	varsJSON, err := json.MarshalIndent(vars, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal variables: %w", err)
-	}
-	
-	err = ioutil.WriteFile(varsFile, varsJSON, 0644)
+// SetRetryPolicy replaces the policy deciding which failed phases are run
+// again. Its rules' patterns must compile, as LoadFailureRules checks.
+func (e *Executor) SetRetryPolicy(policy RetryPolicy) {
+	e.retry = policy.compile()
+}
+
+// Apply applies a module's configuration to an environment. Phases missing
+// from timeouts are bounded by DefaultTimeouts; cancelling ctx interrupts
+// Terraform.
+func (e *Executor) Apply(ctx context.Context, envID, module string, vars map[string]interface{}, timeouts Timeouts, hooks Hooks) error {
+	workPath, err := e.prepareWorkspace(envID, module, vars)
 	if err != nil {
-		return fmt.Errorf("failed to write variables file: %w", err)
+		return err
 	}
-	
-	// Get module path
-	modulePath := filepath.Join(e.basePath, module)
-	
+
 	// Initialize Terraform
-	err = e.runCommand(workPath, "init", "-no-color", modulePath)
+	err = e.initWorkspace(ctx, timeouts, hooks, workPath)
 	if err != nil {
 		return fmt.Errorf("terraform init failed: %w", err)
 	}
-	
+
 	// Apply configuration
-	err = e.runCommand(workPath, "apply", "-no-color", "-auto-approve", "-var-file=terraform.tfvars.json")
+	err = e.runPhase(ctx, timeouts, hooks, workPath, "apply", "-no-color", "-input=false", "-auto-approve")
 	if err != nil {
 		return fmt.Errorf("terraform apply failed: %w", err)
 	}
-	
+
 	return nil
 }
 
-// Destroy destroys Terraform-managed infrastructure
-func (e *Executor) Destroy(module string, vars map[string]interface{}) error {
-	// Create working directory
-	workDir := fmt.Sprintf("%s-%d", module, time.Now().Unix())
-	workPath := filepath.Join(e.statePath, workDir)
-	
-	err := os.MkdirAll(workPath, 0755)
-	if err != nil {
-		return fmt.Errorf("failed to create work directory: %w", err)
-	}
-	
-	// Write variables file
-	varsFile := filepath.Join(workPath, "terraform.tfvars.json")
-	varsJSON, err := json.MarshalIndent(vars, "", "  ")
+// Destroy destroys an environment's Terraform-managed infrastructure, with the
+// same timeouts and cancellation as Apply
+func (e *Executor) Destroy(ctx context.Context, envID, module string, vars map[string]interface{}, timeouts Timeouts, hooks Hooks) error {
+	workPath, err := e.prepareWorkspace(envID, module, vars)
 	if err != nil {
-		return fmt.Errorf("failed to marshal variables: %w", err)
+		return err
 	}
-	
-	err = ioutil.WriteFile(varsFile, varsJSON, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write variables file: %w", err)
-	}
-	
-	// Get module path
-	modulePath := filepath.Join(e.basePath, module)
-	
+
 	// Initialize Terraform
-	err = e.runCommand(workPath, "init", "-no-color", modulePath)
+	err = e.initWorkspace(ctx, timeouts, hooks, workPath)
 	if err != nil {
 		return fmt.Errorf("terraform init failed: %w", err)
 	}
-	
+
 	// Destroy infrastructure
-	err = e.runCommand(workPath, "destroy", "-no-color", "-auto-approve", "-var-file=terraform.tfvars.json")
+	err = e.runPhase(ctx, timeouts, hooks, workPath, "destroy", "-no-color", "-input=false", "-auto-approve")
 	if err != nil {
 		return fmt.Errorf("terraform destroy failed: %w", err)
 	}
-	
+
 	return nil
 }
 
-// GetOutputs retrieves outputs from Terraform state
-func (e *Executor) GetOutputs(module string) (map[string]interface{}, error) {
-	// Find latest working directory for module
-	dirs, err := ioutil.ReadDir(e.statePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read state directory: %w", err)
-	}
-	
-	var latestDir string
-	var latestTime int64
-	
-	for _, dir := range dirs {
-		if dir.IsDir() && strings.HasPrefix(dir.Name(), module+"-") {
-			parts := strings.Split(dir.Name(), "-")
-			if len(parts) > 1 {
-				timestamp, err := StringToInt64(parts[1])
-				if err == nil && timestamp > latestTime {
-					latestTime = timestamp
-					latestDir = dir.Name()
-				}
-			}
-		}
+// Outputs retrieves the outputs of an environment's Terraform state
+func (e *Executor) Outputs(ctx context.Context, envID string) (map[string]interface{}, error) {
+	workPath := e.Workspace(envID)
+	if _, err := os.Stat(filepath.Join(workPath, backendFile)); err != nil {
+		return nil, fmt.Errorf("no workspace found for environment %s: %w", envID, err)
 	}
-	
-	if latestDir == "" {
-		return nil, fmt.Errorf("no state directory found for module %s", module)
-	}
-	
-	workPath := filepath.Join(e.statePath, latestDir)
-	
+
 	// Get outputs
 	var stdout, stderr bytes.Buffer
-	cmd := exec.Command(e.tfBinary, "output", "-no-color", "-json")
-	cmd.Dir = workPath
-	cmd.Env = e.environment
+	cmd := e.command(ctx, workPath, "output", "-no-color", "-json")
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
-	
-	err = cmd.Run()
+
+	err := cmd.Run()
 	if err != nil {
 		return nil, fmt.Errorf("terraform output failed: %w, stderr: %s", err, stderr.String())
 	}
-	
+
 	// Parse outputs
 	var outputs map[string]struct {
 		Value interface{} `json:"value"`
 	}
-	
+
 	err = json.Unmarshal(stdout.Bytes(), &outputs)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse outputs: %w", err)
 	}
-	
+
 	// Extract values
 	result := make(map[string]interface{})
 	for key, output := range outputs {
 		result[key] = output.Value
 	}
-	
+
 	return result, nil
 }
 
-// runCommand runs a Terraform command
-func (e *Executor) runCommand(workDir string, args ...string) error {
-	var stdout, stderr bytes.Buffer
-	cmd := exec.Command(e.tfBinary, args...)
+// runPhase runs a Terraform subcommand, reporting it to the hooks as a phase
+// named after the subcommand. A failure is returned as a *FailureError with
+// its category; transient failures are retried with exponential backoff as
+// the executor's retry policy allows. Every run is bounded by the phase's
+// timeout.
+func (e *Executor) runPhase(ctx context.Context, timeouts Timeouts, hooks Hooks, workDir string, args ...string) error {
+	phase := args[0]
+	timeout, ok := timeouts[phase]
+	if !ok {
+		timeout = DefaultTimeouts[phase]
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := e.runAttempt(ctx, timeout, hooks, workDir, args...)
+		if err == nil || errors.Is(err, errChangesPresent) || ctx.Err() != nil {
+			return err
+		}
+
+		failure := e.retry.classify(phase, err, attempt)
+		if !failure.Transient || attempt >= e.retry.MaxAttempts {
+			return failure
+		}
+
+		delay := e.retry.backoff(attempt)
+		log.Printf("Terraform %s failed with a %s failure; retrying in %s", phase, failure.Category, delay)
+		if hooks.PhaseRetrying != nil {
+			hooks.PhaseRetrying(phase, attempt, delay, failure)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return failure
+		case <-timer.C:
+		}
+	}
+}
+
+// runAttempt runs a Terraform subcommand once under timeout, reporting its
+// start and finish to the hooks and recording its duration
+func (e *Executor) runAttempt(ctx context.Context, timeout time.Duration, hooks Hooks, workDir string, args ...string) error {
+	phase := args[0]
+
+	// Inits take turns with the shared provider cache one attempt at a time,
+	// so that waiting out a retry's backoff holds up no other workspace
+	if phase == "init" && e.cache != nil {
+		if err := e.cache.acquire(ctx); err != nil {
+			return fmt.Errorf("terraform %s stopped waiting for the provider cache: %w", phase, err)
+		}
+		defer e.cache.release()
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if hooks.PhaseStarted != nil {
+		hooks.PhaseStarted(phase)
+	}
+
+	var output func(stream, line string)
+	if hooks.Output != nil {
+		output = func(stream, line string) {
+			hooks.Output(phase, stream, line)
+		}
+	}
+
+	start := time.Now()
+	err := e.runCommand(ctx, workDir, output, args...)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("terraform %s timed out after %s: %w", phase, timeout, err)
+	}
+
+	duration := time.Since(start)
+	phaseErr := err
+	if errors.Is(err, errChangesPresent) {
+		phaseErr = nil
+	}
+	e.timings.ran(e.tool, phase, duration, phaseErr != nil)
+	if hooks.PhaseFinished != nil {
+		hooks.PhaseFinished(phase, duration, phaseErr)
+	}
+	return err
+}
+
+// command prepares a Terraform command that is interrupted when ctx is done
+// and killed if it has not exited within interruptGracePeriod
+func (e *Executor) command(ctx context.Context, workDir string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, e.tfBinary, args...)
 	cmd.Dir = workDir
 	cmd.Env = e.environment
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	
+	cmd.Cancel = func() error {
+		log.Printf("Interrupting Terraform command: %s %s", e.tfBinary, strings.Join(args, " "))
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = interruptGracePeriod
+	return cmd
+}
+
+// runCommand runs a Terraform command, passing each line of its output to
+// output, if set, as it is written. If ctx is done before the command exits,
+// the returned error wraps ctx's error.
+func (e *Executor) runCommand(ctx context.Context, workDir string, output func(stream, line string), args ...string) error {
+	var stderr bytes.Buffer
+	var outputMu sync.Mutex
+	cmd := e.command(ctx, workDir, args...)
+	stdoutLines := &lineWriter{stream: "stdout", output: output, mu: &outputMu}
+	stderrLines := &lineWriter{stream: "stderr", output: output, mu: &outputMu, copy: &stderr}
+	cmd.Stdout = stdoutLines
+	cmd.Stderr = stderrLines
+
 	log.Printf("Running Terraform command: %s %s", e.tfBinary, strings.Join(args, " "))
-	
+
 	err := cmd.Run()
+	stdoutLines.flush()
+	stderrLines.flush()
+
+	// With -detailed-exitcode, plan exits 2 when it succeeds with changes
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 2 && ctx.Err() == nil && hasArg(args, "-detailed-exitcode") {
+		log.Printf("Terraform command succeeded with changes")
+		return errChangesPresent
+	}
+
 	if err != nil {
 		log.Printf("Terraform command failed: %v", err)
 		log.Printf("Stderr: %s", stderr.String())
+		if ctx.Err() != nil {
+			return fmt.Errorf("terraform command interrupted: %w, stderr: %s", ctx.Err(), stderr.String())
+		}
 		return fmt.Errorf("terraform command failed: %w, stderr: %s", err, stderr.String())
 	}
-	
+
 	log.Printf("Terraform command succeeded")
-	
+
 	return nil
 }
 
-// StringToInt64 converts a string to int64
-func StringToInt64(s string) (int64, error) {
-	var result int64
-	_, err := fmt.Sscanf(s, "%d", &result)
-	return result, err
-}
\ No newline at end of file
+// hasArg reports whether args contains arg
+func hasArg(args []string, arg string) bool {
+	for _, a := range args {
+		if a == arg {
+			return true
+		}
+	}
+	return false
+}
+
+// lineWriter splits command output into lines, passing each line to output
+// under mu and copying it to copy, if set. Lines longer than maxOutputLine
+// are cut.
+type lineWriter struct {
+	stream string
+	output func(stream, line string)
+	mu     *sync.Mutex
+	copy   *bytes.Buffer
+	buf    []byte
+}
+
+// Write buffers p and emits every line it completes
+func (w *lineWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			w.append(p)
+			return n, nil
+		}
+		w.append(p[:i])
+		w.emit()
+		p = p[i+1:]
+	}
+}
+
+// flush emits a last line that did not end in a newline
+func (w *lineWriter) flush() {
+	if len(w.buf) > 0 {
+		w.emit()
+	}
+}
+
+// append adds p to the current line, up to maxOutputLine
+func (w *lineWriter) append(p []byte) {
+	if room := maxOutputLine - len(w.buf); len(p) > room {
+		p = p[:room]
+	}
+	w.buf = append(w.buf, p...)
+}
+
+// emit passes on the current line and starts a new one
+func (w *lineWriter) emit() {
+	line := strings.TrimSuffix(string(w.buf), "\r")
+	w.buf = w.buf[:0]
+
+	if w.copy != nil {
+		w.copy.WriteString(line)
+		w.copy.WriteByte('\n')
+	}
+	if w.output != nil {
+		w.mu.Lock()
+		w.output(w.stream, line)
+		w.mu.Unlock()
+	}
+}
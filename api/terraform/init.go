@@ -0,0 +1,92 @@
+package terraform
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// initStampFile, in Terraform's data directory, holds the digest of the
+// workspace as the last successful init left it
+const initStampFile = "provisioner-init.sha256"
+
+// initWorkspace runs init in a workspace unless its last successful init was
+// of the same lock file, backend and configuration, in which case the
+// providers and modules it installed are still the right ones
+func (e *Executor) initWorkspace(ctx context.Context, timeouts Timeouts, hooks Hooks, workPath string) error {
+	stampPath := filepath.Join(workPath, dataDir, initStampFile)
+	digest, err := e.initDigest(workPath)
+	if err == nil {
+		if stamp, err := ioutil.ReadFile(stampPath); err == nil && string(stamp) == digest {
+			e.timings.skipped(e.tool, "init")
+			if hooks.PhaseSkipped != nil {
+				hooks.PhaseSkipped("init", "lock file, backend and configuration unchanged since the last init")
+			}
+			return nil
+		}
+	}
+
+	// A failed init leaves the data directory in no known state
+	os.Remove(stampPath)
+	if err := e.runPhase(ctx, timeouts, hooks, workPath, "init", "-no-color", "-input=false"); err != nil {
+		return err
+	}
+
+	// Init may have written the lock file, so the stamp is of what it left
+	if digest, err := e.initDigest(workPath); err == nil {
+		ioutil.WriteFile(stampPath, []byte(digest), 0644)
+	}
+	return nil
+}
+
+// initDigest hashes what init depends on in a workspace: the tool, where
+// providers are installed from, and every file but the run's variables,
+// Terraform's data directory and saved plans
+func (e *Executor) initDigest(workPath string) (string, error) {
+	var files []string
+	err := filepath.Walk(workPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(workPath, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && (rel == dataDir || rel == plansDir) {
+			return filepath.SkipDir
+		}
+		if info.Mode().IsRegular() && rel != varsFile {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+
+	hash := sha256.New()
+	io.WriteString(hash, e.tool+"\x00")
+	if e.cache != nil {
+		io.WriteString(hash, e.cache.mirrorDir+"\x00")
+	}
+	for _, rel := range files {
+		io.WriteString(hash, rel+"\x00")
+		f, err := os.Open(filepath.Join(workPath, rel))
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(hash, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		io.WriteString(hash, "\x00")
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,341 @@
+package terraform
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+)
+
+// plansDir holds the plan files of an environment's runs within its
+// workspace while Terraform writes or applies them. Saved plans themselves are
+// kept by the caller, where every replica can read them.
+const plansDir = ".plans"
+
+// PlanTTL is how long a saved plan can be applied
+const PlanTTL = 24 * time.Hour
+
+// ErrPlanStale is returned when the variables a plan was made with are no
+// longer the environment's
+var ErrPlanStale = errors.New("plan does not match the environment's current configuration")
+
+// showPlan is the part of `terraform show -json` output that is summarized:
+// the changes the plan makes, and the changes made outside Terraform that it
+// found while refreshing
+type showPlan struct {
+	ResourceChanges []showResourceChange `json:"resource_changes"`
+	ResourceDrift   []showResourceChange `json:"resource_drift"`
+}
+
+// showResourceChange is a change to one resource in `terraform show -json`
+// output
+type showResourceChange struct {
+	Address string `json:"address"`
+	Mode    string `json:"mode"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Change  struct {
+		Actions      []string        `json:"actions"`
+		ReplacePaths [][]interface{} `json:"replace_paths"`
+	} `json:"change"`
+	ActionReason string `json:"action_reason"`
+}
+
+// Plan plans a module's configuration against an environment and returns the
+// plan with its plan file, so that ApplyPlan can later apply exactly the
+// changes it previews. No copy is left in the workspace.
+func (e *Executor) Plan(ctx context.Context, envID, module string, vars map[string]interface{}, timeouts Timeouts, hooks Hooks) (models.Plan, error) {
+	workPath, err := e.prepareWorkspace(envID, module, vars)
+	if err != nil {
+		return models.Plan{}, err
+	}
+
+	planPath := filepath.Join(workPath, plansDir)
+	if err := os.MkdirAll(planPath, 0755); err != nil {
+		return models.Plan{}, fmt.Errorf("failed to create plan directory: %w", err)
+	}
+	e.removeExpiredPlans(planPath)
+
+	digest, err := varsDigest(vars)
+	if err != nil {
+		return models.Plan{}, err
+	}
+
+	// Initialize Terraform
+	err = e.initWorkspace(ctx, timeouts, hooks, workPath)
+	if err != nil {
+		return models.Plan{}, fmt.Errorf("terraform init failed: %w", err)
+	}
+
+	// Plan configuration into a plan file
+	planID := uuid.New().String()
+	planFile := filepath.Join(plansDir, planID+".tfplan")
+	defer os.Remove(filepath.Join(workPath, planFile))
+	err = e.runPhase(ctx, timeouts, hooks, workPath, "plan", "-no-color", "-input=false", "-out="+planFile)
+	if err != nil {
+		return models.Plan{}, fmt.Errorf("terraform plan failed: %w", err)
+	}
+
+	planJSON, err := e.showPlan(ctx, workPath, planFile)
+	if err != nil {
+		return models.Plan{}, err
+	}
+
+	now := time.Now().UTC()
+	plan := models.Plan{
+		ID:            planID,
+		EnvironmentID: envID,
+		Tool:          e.tool,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(PlanTTL),
+		VarsDigest:    digest,
+	}
+	plan.Summary, plan.Changes, err = summarizePlan(planJSON)
+	if err != nil {
+		return models.Plan{}, err
+	}
+
+	plan.File, err = ioutil.ReadFile(filepath.Join(workPath, planFile))
+	if err != nil {
+		return models.Plan{}, fmt.Errorf("failed to read plan file: %w", err)
+	}
+
+	return plan, nil
+}
+
+// CheckPlan returns ErrPlanStale if a plan was made with other variables than
+// vars, or by another tool
+func (e *Executor) CheckPlan(plan models.Plan, vars map[string]interface{}) error {
+	digest, err := varsDigest(vars)
+	if err != nil {
+		return err
+	}
+	if digest != plan.VarsDigest || plan.Tool != e.tool {
+		return ErrPlanStale
+	}
+	return nil
+}
+
+// ApplyPlan applies a saved plan to its environment. The environment's
+// variables must still be the ones the plan was made with, and Terraform
+// itself refuses a plan whose state has changed since. The plan may have been
+// made by another replica, so the workspace is prepared and initialized as
+// for the plan before its file is applied.
+func (e *Executor) ApplyPlan(ctx context.Context, envID, module string, plan models.Plan, vars map[string]interface{}, timeouts Timeouts, hooks Hooks) error {
+	if plan.EnvironmentID != envID {
+		return fmt.Errorf("plan %s is not a plan of environment %s", plan.ID, envID)
+	}
+	if err := e.CheckPlan(plan, vars); err != nil {
+		return err
+	}
+
+	workPath, err := e.prepareWorkspace(envID, module, vars)
+	if err != nil {
+		return err
+	}
+	planPath := filepath.Join(workPath, plansDir)
+	if err := os.MkdirAll(planPath, 0755); err != nil {
+		return fmt.Errorf("failed to create plan directory: %w", err)
+	}
+
+	// Initialize Terraform
+	err = e.initWorkspace(ctx, timeouts, hooks, workPath)
+	if err != nil {
+		return fmt.Errorf("terraform init failed: %w", err)
+	}
+
+	planFile := filepath.Join(plansDir, plan.ID+".tfplan")
+	if err := ioutil.WriteFile(filepath.Join(workPath, planFile), plan.File, 0600); err != nil {
+		return fmt.Errorf("failed to write plan file: %w", err)
+	}
+	defer os.Remove(filepath.Join(workPath, planFile))
+
+	err = e.runPhase(ctx, timeouts, hooks, workPath, "apply", "-no-color", "-input=false", planFile)
+	if err != nil {
+		return fmt.Errorf("terraform apply failed: %w", err)
+	}
+
+	return nil
+}
+
+// DetectDrift refreshes an environment's state against its infrastructure
+// with a refresh-only plan, which changes nothing. It returns the resources
+// changed or deleted outside Terraform since they were last applied, or none
+// if the infrastructure matches its state.
+func (e *Executor) DetectDrift(ctx context.Context, envID, module string, vars map[string]interface{}, timeouts Timeouts, hooks Hooks) ([]models.ResourceChange, error) {
+	workPath, err := e.prepareWorkspace(envID, module, vars)
+	if err != nil {
+		return nil, err
+	}
+	planPath := filepath.Join(workPath, plansDir)
+	if err := os.MkdirAll(planPath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create plan directory: %w", err)
+	}
+
+	// Initialize Terraform
+	err = e.initWorkspace(ctx, timeouts, hooks, workPath)
+	if err != nil {
+		return nil, fmt.Errorf("terraform init failed: %w", err)
+	}
+
+	// Plan into a throwaway plan file, read only to list the drift
+	planFile := filepath.Join(plansDir, "drift-"+uuid.New().String()+".tfplan")
+	defer os.Remove(filepath.Join(workPath, planFile))
+	err = e.runPhase(ctx, timeouts, hooks, workPath, "plan", "-no-color", "-input=false", "-refresh-only", "-detailed-exitcode", "-out="+planFile)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, errChangesPresent) {
+		return nil, fmt.Errorf("terraform plan failed: %w", err)
+	}
+
+	planJSON, err := e.showPlan(ctx, workPath, planFile)
+	if err != nil {
+		return nil, err
+	}
+	return summarizeDrift(planJSON)
+}
+
+// showPlan returns the JSON form of a saved plan. It holds variable and
+// attribute values, so it is read here rather than passed to the output hook.
+func (e *Executor) showPlan(ctx context.Context, workPath, planFile string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := e.command(ctx, workPath, "show", "-no-color", "-json", planFile)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("terraform show failed: %w, stderr: %s", err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+// removeExpiredPlans removes the plan files in planPath that interrupted runs
+// left behind longer than PlanTTL ago, returning the bytes freed
+func (e *Executor) removeExpiredPlans(planPath string) int64 {
+	entries, err := ioutil.ReadDir(planPath)
+	if err != nil {
+		return 0
+	}
+	var freed int64
+	for _, entry := range entries {
+		if time.Since(entry.ModTime()) > PlanTTL {
+			if os.Remove(filepath.Join(planPath, entry.Name())) == nil {
+				freed += entry.Size()
+			}
+		}
+	}
+	return freed
+}
+
+// summarizePlan counts and lists the changes to managed resources in the
+// output of `terraform show -json`. Resources left as they are and data
+// sources that are only read are left out.
+func summarizePlan(data []byte) (models.PlanSummary, []models.ResourceChange, error) {
+	var show showPlan
+	if err := json.Unmarshal(data, &show); err != nil {
+		return models.PlanSummary{}, nil, fmt.Errorf("failed to parse plan: %w", err)
+	}
+
+	var summary models.PlanSummary
+	changes := []models.ResourceChange{}
+	for _, rc := range show.ResourceChanges {
+		change, ok := resourceChange(rc)
+		if !ok {
+			continue
+		}
+		switch change.Action {
+		case "create":
+			summary.Add++
+		case "update":
+			summary.Change++
+		case "delete":
+			summary.Destroy++
+		case "replace":
+			summary.Add++
+			summary.Destroy++
+			summary.Replace++
+		}
+		changes = append(changes, change)
+	}
+	return summary, changes, nil
+}
+
+// summarizeDrift lists the managed resources that a plan found changed
+// outside Terraform in the output of `terraform show -json`: "update" for a
+// resource changed, "delete" for one deleted. These are the changes made to
+// the infrastructure, not the ones that would undo them.
+func summarizeDrift(data []byte) ([]models.ResourceChange, error) {
+	var show showPlan
+	if err := json.Unmarshal(data, &show); err != nil {
+		return nil, fmt.Errorf("failed to parse plan: %w", err)
+	}
+
+	var drift []models.ResourceChange
+	for _, rc := range show.ResourceDrift {
+		if change, ok := resourceChange(rc); ok {
+			drift = append(drift, change)
+		}
+	}
+	return drift, nil
+}
+
+// resourceChange converts a change to a managed resource, reporting false for
+// data sources and for resources left as they are
+func resourceChange(rc showResourceChange) (models.ResourceChange, bool) {
+	if rc.Mode != "managed" {
+		return models.ResourceChange{}, false
+	}
+
+	change := models.ResourceChange{
+		Address: rc.Address,
+		Type:    rc.Type,
+		Name:    rc.Name,
+		Reason:  rc.ActionReason,
+	}
+	switch actions := strings.Join(rc.Change.Actions, ","); actions {
+	case "create", "update", "delete":
+		change.Action = actions
+	case "delete,create", "create,delete":
+		change.Action = "replace"
+	default:
+		return models.ResourceChange{}, false
+	}
+
+	for _, path := range rc.Change.ReplacePaths {
+		steps := make([]string, len(path))
+		for i, step := range path {
+			switch step := step.(type) {
+			case float64:
+				steps[i] = strconv.FormatFloat(step, 'f', -1, 64)
+			default:
+				steps[i] = fmt.Sprint(step)
+			}
+		}
+		change.ReplacePaths = append(change.ReplacePaths, strings.Join(steps, "."))
+	}
+	return change, true
+}
+
+// varsDigest returns a digest identifying a set of variables
+func varsDigest(vars map[string]interface{}) (string, error) {
+	// Maps are marshalled with sorted keys, so equal variables give equal
+	// digests
+	data, err := json.Marshal(vars)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal variables: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,125 @@
+package terraform
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+)
+
+func TestSummarizeDrift(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "drift.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drift, err := summarizeDrift(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The node group resized and the bucket versioning deleted outside
+	// Terraform are reported as they happened; the data source read is not
+	want := []models.ResourceChange{
+		{
+			Address: `module.eks.module.eks_managed_node_group["application"].aws_eks_node_group.this[0]`,
+			Type:    "aws_eks_node_group",
+			Name:    "this",
+			Action:  "update",
+		},
+		{
+			Address: "aws_s3_bucket_versioning.gitops_state",
+			Type:    "aws_s3_bucket_versioning",
+			Name:    "gitops_state",
+			Action:  "delete",
+		},
+	}
+	if !reflect.DeepEqual(drift, want) {
+		t.Fatalf("got %+v, want %+v", drift, want)
+	}
+}
+
+func TestSummarizeDriftNone(t *testing.T) {
+	drift, err := summarizeDrift([]byte(`{"format_version": "1.1", "resource_changes": []}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drift) != 0 {
+		t.Fatalf("got %+v, want no drift", drift)
+	}
+}
+
+func TestSummarizePlan(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "plan.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, changes, err := summarizePlan(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replacements count as one to add and one to destroy whichever way
+	// round they run; the no-op, the data source read and the drift are
+	// left out
+	wantSummary := models.PlanSummary{Add: 3, Change: 1, Destroy: 3, Replace: 2}
+	if summary != wantSummary {
+		t.Errorf("got summary %+v, want %+v", summary, wantSummary)
+	}
+
+	want := []models.ResourceChange{
+		{Address: "aws_eks_addon.coredns", Type: "aws_eks_addon", Name: "coredns", Action: "create"},
+		{
+			Address: `module.eks.module.eks_managed_node_group["application"].aws_eks_node_group.this[0]`,
+			Type:    "aws_eks_node_group",
+			Name:    "this",
+			Action:  "update",
+		},
+		{
+			Address: "aws_security_group_rule.legacy_ssh",
+			Type:    "aws_security_group_rule",
+			Name:    "legacy_ssh",
+			Action:  "delete",
+			Reason:  "delete_because_no_resource_config",
+		},
+		{
+			Address:      "aws_kms_key.eks",
+			Type:         "aws_kms_key",
+			Name:         "eks",
+			Action:       "replace",
+			Reason:       "replace_because_cannot_update",
+			ReplacePaths: []string{"key_usage"},
+		},
+		{
+			Address:      `module.eks.aws_launch_template.nodes["application"]`,
+			Type:         "aws_launch_template",
+			Name:         "nodes",
+			Action:       "replace",
+			Reason:       "replace_because_cannot_update",
+			ReplacePaths: []string{"block_device_mappings.0.ebs.0.volume_size", "name"},
+		},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("got changes %+v, want %+v", changes, want)
+	}
+}
+
+func TestSummarizePlanNoChanges(t *testing.T) {
+	summary, changes, err := summarizePlan([]byte(`{"format_version": "1.1", "resource_changes": []}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary != (models.PlanSummary{}) {
+		t.Errorf("got summary %+v, want none", summary)
+	}
+	if changes == nil || len(changes) != 0 {
+		t.Errorf("got changes %#v, want an empty list", changes)
+	}
+
+	if _, _, err := summarizePlan([]byte("not json")); err == nil {
+		t.Error("summarized a plan that is not JSON")
+	}
+}
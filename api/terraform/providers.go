@@ -0,0 +1,107 @@
+package terraform
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ProviderCache is a provider plugin cache shared by executors, so that each
+// provider version is downloaded once instead of into every workspace. With a
+// mirror, providers are installed from a local filesystem mirror only, for
+// installs that cannot reach the registry.
+type ProviderCache struct {
+	dir       string
+	mirrorDir string
+	cliConfig string
+
+	// installing admits one terraform init at a time, since Terraform does
+	// not lock the cache against concurrent installs of the same provider
+	installing chan struct{}
+}
+
+// NewProviderCache creates the cache directory if needed. If mirrorDir is
+// set, it writes a CLI configuration next to the cache that installs
+// providers from the mirror, laid out as `terraform providers mirror` writes
+// it, and from nowhere else.
+func NewProviderCache(dir, mirrorDir string) (*ProviderCache, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid plugin cache directory: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create plugin cache directory: %w", err)
+	}
+	cache := &ProviderCache{dir: dir, installing: make(chan struct{}, 1)}
+	if mirrorDir == "" {
+		return cache, nil
+	}
+
+	cache.mirrorDir, err = filepath.Abs(mirrorDir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid provider mirror directory: %w", err)
+	}
+	if info, err := os.Stat(cache.mirrorDir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("provider mirror %s is not a directory", cache.mirrorDir)
+	}
+
+	cache.cliConfig = strings.TrimSuffix(dir, string(filepath.Separator)) + ".tfrc"
+	config := fmt.Sprintf("# Written by the provisioner: providers come from the mirror only\nprovider_installation {\n  filesystem_mirror {\n    path = %s\n  }\n}\n", strconv.Quote(cache.mirrorDir))
+	if err := ioutil.WriteFile(cache.cliConfig, []byte(config), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write provider installation config: %w", err)
+	}
+	return cache, nil
+}
+
+// Dir returns the cache directory
+func (c *ProviderCache) Dir() string {
+	return c.dir
+}
+
+// MirrorDir returns the filesystem mirror providers are installed from, or ""
+// if they are downloaded
+func (c *ProviderCache) MirrorDir() string {
+	return c.mirrorDir
+}
+
+// acquire waits for the other executors' inits to finish with the cache, or
+// for ctx to be done
+func (c *ProviderCache) acquire(ctx context.Context) error {
+	select {
+	case c.installing <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release lets the next init use the cache
+func (c *ProviderCache) release() {
+	<-c.installing
+}
+
+// environment returns the variables that point a tool at the cache and the
+// mirror. Providers are taken from the cache even for a workspace whose lock
+// file does not list their checksums yet, which then records only the
+// checksum of this platform's package.
+func (c *ProviderCache) environment() []string {
+	env := []string{
+		"TF_PLUGIN_CACHE_DIR=" + c.dir,
+		"TF_PLUGIN_CACHE_MAY_BREAK_DEPENDENCY_LOCK_FILE=true",
+	}
+	if c.cliConfig != "" {
+		env = append(env, "TF_CLI_CONFIG_FILE="+c.cliConfig)
+	}
+	return env
+}
+
+// SetProviderCache makes the executor install providers through cache. Every
+// executor sharing the workspaces should share the cache.
+func (e *Executor) SetProviderCache(cache *ProviderCache) {
+	e.cache = cache
+	e.environment = append(e.environment, cache.environment()...)
+}
@@ -0,0 +1,96 @@
+package terraform
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/jbrcoleman/k8s-env-provisioner/api/models"
+)
+
+// Tools a cluster template can provision its environments with
+const (
+	ToolTerraform  = "terraform"
+	ToolOpenTofu   = "opentofu"
+	ToolTerragrunt = "terragrunt"
+)
+
+// terragruntFile is the configuration file Terragrunt requires in the
+// directory it runs in, and terragruntConfig the one written for modules that
+// have none: it adds nothing, so Terragrunt runs the module in place
+const terragruntFile = "terragrunt.hcl"
+
+var terragruntConfig = []byte("# Written by the provisioner: the module is run in place\n")
+
+// ErrUnknownTool is returned for a tool no provisioner is configured for
+var ErrUnknownTool = errors.New("unknown provisioning tool")
+
+// Provisioner runs an infrastructure-as-code tool against the workspace and
+// state of each environment. Phases missing from timeouts are bounded by
+// DefaultTimeouts; cancelling ctx interrupts the tool.
+type Provisioner interface {
+	// Apply applies a module's configuration to an environment
+	Apply(ctx context.Context, envID, module string, vars map[string]interface{}, timeouts Timeouts, hooks Hooks) error
+
+	// Destroy destroys an environment's managed infrastructure
+	Destroy(ctx context.Context, envID, module string, vars map[string]interface{}, timeouts Timeouts, hooks Hooks) error
+
+	// Outputs returns the outputs of an environment's state
+	Outputs(ctx context.Context, envID string) (map[string]interface{}, error)
+
+	// Plan plans a module's configuration against an environment and
+	// returns the plan, with its plan file, and a summary of its changes
+	Plan(ctx context.Context, envID, module string, vars map[string]interface{}, timeouts Timeouts, hooks Hooks) (models.Plan, error)
+
+	// CheckPlan returns ErrPlanStale if a plan cannot be applied with vars
+	CheckPlan(plan models.Plan, vars map[string]interface{}) error
+
+	// ApplyPlan applies exactly the changes of a saved plan, in any
+	// replica's workspace
+	ApplyPlan(ctx context.Context, envID, module string, plan models.Plan, vars map[string]interface{}, timeouts Timeouts, hooks Hooks) error
+
+	// DetectDrift returns the resources of an environment's infrastructure
+	// changed or deleted outside the tool
+	DetectDrift(ctx context.Context, envID, module string, vars map[string]interface{}, timeouts Timeouts, hooks Hooks) ([]models.ResourceChange, error)
+
+	// PhaseTimings returns how long each phase has taken in this process
+	PhaseTimings() []PhaseTiming
+}
+
+var _ Provisioner = (*Executor)(nil)
+
+// NewOpenTofuExecutor creates an executor like NewExecutor that runs
+// OpenTofu, the open-source fork of Terraform, instead
+func NewOpenTofuExecutor(basePath, workspacePath string, backend Backend) *Executor {
+	e := NewExecutor(basePath, workspacePath, backend)
+	e.tool = ToolOpenTofu
+	e.tfBinary = "tofu"
+	return e
+}
+
+// NewTerragruntExecutor creates an executor like NewExecutor that runs
+// Terragrunt, which wraps the Terraform or OpenTofu binary named by
+// TERRAGRUNT_TFPATH (default terraform)
+func NewTerragruntExecutor(basePath, workspacePath string, backend Backend) *Executor {
+	e := NewExecutor(basePath, workspacePath, backend)
+	e.tool = ToolTerragrunt
+	e.tfBinary = "terragrunt"
+	e.environment = append(os.Environ(), "TERRAGRUNT_NON_INTERACTIVE=true")
+	return e
+}
+
+// Provisioners holds the provisioner of each tool, by tool name
+type Provisioners map[string]Provisioner
+
+// Get returns the provisioner of a tool. The empty name selects Terraform.
+func (p Provisioners) Get(tool string) (Provisioner, error) {
+	if tool == "" {
+		tool = ToolTerraform
+	}
+	provisioner, ok := p[tool]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownTool, tool)
+	}
+	return provisioner, nil
+}
@@ -0,0 +1,183 @@
+package terraform
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+)
+
+// Failure categories. Rules may name others; these are the built-in ones.
+const (
+	// Transient: the same command is likely to succeed if run again later
+	FailureThrottling          = "THROTTLING"
+	FailureEventualConsistency = "EVENTUAL_CONSISTENCY"
+	FailureProviderDownload    = "PROVIDER_DOWNLOAD"
+	FailureNetwork             = "NETWORK"
+	FailureServiceUnavailable  = "SERVICE_UNAVAILABLE"
+	FailureStateLocked         = "STATE_LOCKED"
+
+	// Permanent: running the command again will fail the same way
+	FailurePermission    = "PERMISSION"
+	FailureQuota         = "QUOTA"
+	FailureConfiguration = "CONFIGURATION"
+	FailureTimeout       = "TIMEOUT"
+	FailureUnknown       = "UNKNOWN"
+)
+
+// FailureRule assigns a category to failed commands whose error output
+// matches Pattern, a regular expression
+type FailureRule struct {
+	Category  string `json:"category"`
+	Pattern   string `json:"pattern"`
+	Transient bool   `json:"transient"`
+
+	re *regexp.Regexp
+}
+
+// DefaultFailureRules classify the AWS and Terraform errors seen most often.
+// The first matching rule wins, so the narrower patterns come first.
+var DefaultFailureRules = []FailureRule{
+	{Category: FailureThrottling, Transient: true, Pattern: `(?i)throttl|rate exceeded|RequestLimitExceeded|TooManyRequests|SlowDown|status code: 429`},
+	{Category: FailureEventualConsistency, Transient: true, Pattern: `(?i)could not be assumed|cannot be assumed|Invalid IamInstanceProfile|instance profile .* (does not exist|not found)|role .* is not ready|InvalidParameterValue.*(role|IAM)|has not propagated`},
+	{Category: FailureStateLocked, Transient: true, Pattern: `(?i)error acquiring the state lock`},
+	{Category: FailureProviderDownload, Transient: true, Pattern: `(?i)failed to install provider|failed to query available provider packages|could not (connect to|retrieve the list of available versions).*registry|error while installing|checksum.*(mismatch|did not match)`},
+	{Category: FailureNetwork, Transient: true, Pattern: `(?i)connection reset by peer|i/o timeout|TLS handshake timeout|no such host|send request failed|connection refused|unexpected EOF`},
+	{Category: FailureServiceUnavailable, Transient: true, Pattern: `(?i)ServiceUnavailable|InternalError|InternalFailure|status code: 5\d\d`},
+	{Category: FailurePermission, Pattern: `(?i)AccessDenied|UnauthorizedOperation|is not authorized to perform|InvalidClientTokenId|ExpiredToken|SignatureDoesNotMatch`},
+	{Category: FailureQuota, Pattern: `(?i)LimitExceeded|QuotaExceeded|InsufficientInstanceCapacity`},
+	{Category: FailureConfiguration, Pattern: `(?i)Error: (Invalid|Unsupported|Missing required|Reference to undeclared|Incorrect attribute)`},
+}
+
+// RetryPolicy decides which failed Terraform commands are run again and how
+// long to wait in between
+type RetryPolicy struct {
+	// Rules classify failures; failures no rule matches are UNKNOWN and not
+	// retried
+	Rules []FailureRule
+
+	// MaxAttempts is how many times a phase runs before a transient failure
+	// is given up on. 1 disables retries.
+	MaxAttempts int
+
+	// InitialBackoff is the wait before the first retry. Each later wait
+	// doubles, up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryPolicy retries transient failures up to three times over about
+// a minute and a half
+var DefaultRetryPolicy = RetryPolicy{
+	Rules:          DefaultFailureRules,
+	MaxAttempts:    4,
+	InitialBackoff: 15 * time.Second,
+	MaxBackoff:     5 * time.Minute,
+}
+
+// LoadFailureRules reads failure rules from a JSON file holding an array of
+// {"category", "pattern", "transient"} objects, checking that every pattern
+// compiles
+func LoadFailureRules(path string) ([]FailureRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read failure rules file: %w", err)
+	}
+
+	var rules []FailureRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse failure rules file %s: %w", path, err)
+	}
+	for i, rule := range rules {
+		if rule.Category == "" {
+			return nil, fmt.Errorf("failure rule %d in %s has no category", i, path)
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return nil, fmt.Errorf("failure rule %d in %s has an invalid pattern: %w", i, path, err)
+		}
+	}
+	return rules, nil
+}
+
+// FailureError is the error of a Terraform phase that failed, with the
+// category of the failure and how many times the phase was run
+type FailureError struct {
+	Phase     string
+	Category  string
+	Transient bool
+	Attempts  int
+	Err       error
+}
+
+// Error describes the failure, noting retries that were made
+func (e *FailureError) Error() string {
+	if e.Attempts > 1 {
+		return fmt.Sprintf("%s failure after %d attempts: %v", e.Category, e.Attempts, e.Err)
+	}
+	return fmt.Sprintf("%s failure: %v", e.Category, e.Err)
+}
+
+// Unwrap returns the command's error
+func (e *FailureError) Unwrap() error {
+	return e.Err
+}
+
+// FailureCategory returns the category of a failed Terraform phase anywhere in
+// err's chain, or "" if err is not one
+func FailureCategory(err error) string {
+	var failure *FailureError
+	if errors.As(err, &failure) {
+		return failure.Category
+	}
+	return ""
+}
+
+// compile prepares the policy's rules for matching. Patterns are checked when
+// rules are loaded, so built-in or loaded rules always compile.
+func (p RetryPolicy) compile() RetryPolicy {
+	rules := make([]FailureRule, len(p.Rules))
+	for i, rule := range p.Rules {
+		rule.re = regexp.MustCompile(rule.Pattern)
+		rules[i] = rule
+	}
+	p.Rules = rules
+	if p.MaxAttempts < 1 {
+		p.MaxAttempts = 1
+	}
+	return p
+}
+
+// classify wraps the error of a failed phase with its category. Timeouts are
+// permanent, as a retry would hit the same timeout.
+func (p RetryPolicy) classify(phase string, err error, attempts int) *FailureError {
+	failure := &FailureError{Phase: phase, Category: FailureUnknown, Attempts: attempts, Err: err}
+	if errors.Is(err, context.DeadlineExceeded) {
+		failure.Category = FailureTimeout
+		return failure
+	}
+
+	message := err.Error()
+	for _, rule := range p.Rules {
+		if rule.re.MatchString(message) {
+			failure.Category = rule.Category
+			failure.Transient = rule.Transient
+			break
+		}
+	}
+	return failure
+}
+
+// backoff returns the wait before retry number n, counting from 1
+func (p RetryPolicy) backoff(n int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < n && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,107 @@
+package terraform
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	policy := DefaultRetryPolicy.compile()
+
+	tests := []struct {
+		name      string
+		err       error
+		category  string
+		transient bool
+	}{
+		{"throttling", errors.New("Error: creating EKS Node Group: ThrottlingException: Rate exceeded"), FailureThrottling, true},
+		{"request limit", errors.New("api error RequestLimitExceeded: Request limit exceeded."), FailureThrottling, true},
+		{"role not assumable", errors.New("InvalidParameterException: Role with arn: arn:aws:iam::123456789012:role/eks could not be assumed"), FailureEventualConsistency, true},
+		{"instance profile", errors.New("InvalidParameterValue: Value (eks-node) for parameter iamInstanceProfile.name is invalid. Invalid IamInstanceProfile name"), FailureEventualConsistency, true},
+		{"state lock", errors.New("Error: Error acquiring the state lock\n\nConditionalCheckFailedException: The conditional request failed"), FailureStateLocked, true},
+		{"provider install", errors.New("Error: Failed to install provider\n\nError while installing hashicorp/aws v5.31.0"), FailureProviderDownload, true},
+		{"provider checksum", errors.New("the local package for registry.terraform.io/hashicorp/aws doesn't match any of the checksums: checksum mismatch"), FailureProviderDownload, true},
+		{"no such host", errors.New("dial tcp: lookup sts.us-west-2.amazonaws.com: no such host"), FailureNetwork, true},
+		{"connection reset", errors.New("read tcp 10.0.0.1:443: read: connection reset by peer"), FailureNetwork, true},
+		{"service unavailable", errors.New("api error ServiceUnavailableException: Service is temporarily unavailable"), FailureServiceUnavailable, true},
+		{"server error", errors.New("InternalError: We encountered an internal error, status code: 500"), FailureServiceUnavailable, true},
+		{"access denied", errors.New("AccessDeniedException: User: arn:aws:iam::123456789012:user/ci is not authorized to perform: eks:CreateCluster"), FailurePermission, false},
+		{"expired token", errors.New("ExpiredToken: The security token included in the request is expired"), FailurePermission, false},
+		{"vcpu limit", errors.New("VcpuLimitExceeded: You have requested more vCPU capacity than your current vCPU limit"), FailureQuota, false},
+		{"instance capacity", errors.New("InsufficientInstanceCapacity: We currently do not have sufficient m5.large capacity"), FailureQuota, false},
+		{"invalid configuration", errors.New("Error: Unsupported argument\n\nAn argument named \"cluster_nam\" is not expected here."), FailureConfiguration, false},
+		{"undeclared reference", errors.New("Error: Reference to undeclared input variable"), FailureConfiguration, false},
+		{"timeout", fmt.Errorf("terraform apply: %w", context.DeadlineExceeded), FailureTimeout, false},
+		{"timeout mentioning throttling", fmt.Errorf("throttled until: %w", context.DeadlineExceeded), FailureTimeout, false},
+		{"unmatched", errors.New("Error: something nobody has seen before"), FailureUnknown, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failure := policy.classify("apply", tt.err, 2)
+			if failure.Category != tt.category || failure.Transient != tt.transient {
+				t.Fatalf("got %s (transient %v), want %s (transient %v)", failure.Category, failure.Transient, tt.category, tt.transient)
+			}
+			if failure.Phase != "apply" || failure.Attempts != 2 || !errors.Is(failure, tt.err) {
+				t.Fatalf("got %+v, want phase apply after 2 attempts wrapping %v", failure, tt.err)
+			}
+			if FailureCategory(fmt.Errorf("job failed: %w", failure)) != tt.category {
+				t.Fatalf("FailureCategory does not find %s in the error chain", tt.category)
+			}
+		})
+	}
+}
+
+func TestClassifyExtraRulesFirst(t *testing.T) {
+	policy := RetryPolicy{
+		Rules: append([]FailureRule{
+			{Category: FailureThrottling, Pattern: `(?i)please slow down`, Transient: true},
+			{Category: "BUDGET", Pattern: `InsufficientInstanceCapacity`},
+		}, DefaultFailureRules...),
+	}.compile()
+
+	if failure := policy.classify("apply", errors.New("Please slow down"), 1); failure.Category != FailureThrottling || !failure.Transient {
+		t.Errorf("extra rule: got %s (transient %v), want transient THROTTLING", failure.Category, failure.Transient)
+	}
+	if failure := policy.classify("apply", errors.New("InsufficientInstanceCapacity"), 1); failure.Category != "BUDGET" {
+		t.Errorf("extra rule before a built-in one: got %s, want BUDGET", failure.Category)
+	}
+	if failure := policy.classify("apply", errors.New("Rate exceeded"), 1); failure.Category != FailureThrottling {
+		t.Errorf("built-in rule: got %s, want THROTTLING", failure.Category)
+	}
+	if policy.MaxAttempts != 1 {
+		t.Errorf("unset MaxAttempts compiled to %d, want 1", policy.MaxAttempts)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 15 * time.Second, MaxBackoff: 5 * time.Minute}
+
+	want := []time.Duration{
+		15 * time.Second,
+		30 * time.Second,
+		time.Minute,
+		2 * time.Minute,
+		4 * time.Minute,
+		5 * time.Minute,
+		5 * time.Minute,
+	}
+	for i, delay := range want {
+		if got := policy.backoff(i + 1); got != delay {
+			t.Errorf("retry %d: got %v, want %v", i+1, got, delay)
+		}
+	}
+
+	// The cap holds however many retries are allowed
+	if got := policy.backoff(1000); got != 5*time.Minute {
+		t.Errorf("retry 1000: got %v, want the 5m cap", got)
+	}
+
+	// A first wait above the cap is cut down to it
+	policy.InitialBackoff = 10 * time.Minute
+	if got := policy.backoff(1); got != 5*time.Minute {
+		t.Errorf("initial wait above the cap: got %v, want 5m", got)
+	}
+}
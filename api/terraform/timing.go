@@ -0,0 +1,103 @@
+package terraform
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PhaseTiming summarizes how long one phase of one tool has taken in this
+// process, so that runs can be compared, for example init with and without
+// the provider cache. Durations are in seconds.
+type PhaseTiming struct {
+	Tool  string `json:"tool"`
+	Phase string `json:"phase"`
+
+	// Count is how many times the phase ran, and Failures how many of
+	// those runs failed. Skipped counts the times it was not needed, such
+	// as inits of a workspace that was already initialized.
+	Count    int `json:"count"`
+	Failures int `json:"failures"`
+	Skipped  int `json:"skipped"`
+
+	TotalSeconds float64 `json:"totalSeconds"`
+	MeanSeconds  float64 `json:"meanSeconds"`
+	MinSeconds   float64 `json:"minSeconds"`
+	MaxSeconds   float64 `json:"maxSeconds"`
+	LastSeconds  float64 `json:"lastSeconds"`
+}
+
+// phaseTimings accumulates the timing of each phase an executor runs
+type phaseTimings struct {
+	mu     sync.Mutex
+	phases map[string]*PhaseTiming
+}
+
+// entry returns the timing of phase, creating it. mu must be held.
+func (t *phaseTimings) entry(tool, phase string) *PhaseTiming {
+	if t.phases == nil {
+		t.phases = map[string]*PhaseTiming{}
+	}
+	timing, ok := t.phases[phase]
+	if !ok {
+		timing = &PhaseTiming{Tool: tool, Phase: phase}
+		t.phases[phase] = timing
+	}
+	return timing
+}
+
+// ran records one run of a phase
+func (t *phaseTimings) ran(tool, phase string, duration time.Duration, failed bool) {
+	seconds := duration.Seconds()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	timing := t.entry(tool, phase)
+	if timing.Count == 0 || seconds < timing.MinSeconds {
+		timing.MinSeconds = seconds
+	}
+	if seconds > timing.MaxSeconds {
+		timing.MaxSeconds = seconds
+	}
+	timing.Count++
+	if failed {
+		timing.Failures++
+	}
+	timing.TotalSeconds += seconds
+	timing.LastSeconds = seconds
+}
+
+// skipped records a phase that did not need to run
+func (t *phaseTimings) skipped(tool, phase string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entry(tool, phase).Skipped++
+}
+
+// snapshot returns the timings, sorted by phase, rounded to milliseconds
+func (t *phaseTimings) snapshot() []PhaseTiming {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	timings := make([]PhaseTiming, 0, len(t.phases))
+	for _, timing := range t.phases {
+		copied := *timing
+		if copied.Count > 0 {
+			copied.MeanSeconds = copied.TotalSeconds / float64(copied.Count)
+		}
+		for _, seconds := range []*float64{&copied.TotalSeconds, &copied.MeanSeconds, &copied.MinSeconds, &copied.MaxSeconds, &copied.LastSeconds} {
+			*seconds = math.Round(*seconds*1000) / 1000
+		}
+		timings = append(timings, copied)
+	}
+	sort.Slice(timings, func(i, j int) bool {
+		return timings[i].Phase < timings[j].Phase
+	})
+	return timings
+}
+
+// PhaseTimings returns how long each phase has taken in this process
+func (e *Executor) PhaseTimings() []PhaseTiming {
+	return e.timings.snapshot()
+}
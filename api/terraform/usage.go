@@ -0,0 +1,198 @@
+package terraform
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// lostAndFound is created by the file system at the root of a volume mounted
+// as the workspace or state directory. It is not an environment's.
+const lostAndFound = "lost+found"
+
+// WorkspaceUsage is the disk space taken on this host by an environment's
+// files: its workspace and, with the local backend, its state
+type WorkspaceUsage struct {
+	EnvironmentID string
+
+	// WorkspaceBytes counts the whole workspace, including the providers
+	// and modules in Terraform's data directory and the saved plans
+	WorkspaceBytes int64
+	ProviderBytes  int64
+	PlanBytes      int64
+
+	// StateBytes counts the state and its backups under the local
+	// backend's directory
+	StateBytes int64
+
+	// ModifiedAt is when a file of the environment last changed, which is
+	// about when it was last run
+	ModifiedAt time.Time
+}
+
+// Usage measures the files of every environment that has a workspace or local
+// state on this host, sorted by environment ID
+func (e *Executor) Usage() ([]WorkspaceUsage, error) {
+	usage := map[string]*WorkspaceUsage{}
+	entry := func(envID string) *WorkspaceUsage {
+		if usage[envID] == nil {
+			usage[envID] = &WorkspaceUsage{EnvironmentID: envID}
+		}
+		return usage[envID]
+	}
+
+	workspaces, err := environmentDirs(e.workspacePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workspace directory: %w", err)
+	}
+	for _, envID := range workspaces {
+		sizes, modified, err := diskUsage(e.Workspace(envID))
+		if err != nil {
+			return nil, fmt.Errorf("failed to measure workspace of environment %s: %w", envID, err)
+		}
+		u := entry(envID)
+		for name, size := range sizes {
+			u.WorkspaceBytes += size
+			switch name {
+			case dataDir:
+				u.ProviderBytes += size
+			case plansDir:
+				u.PlanBytes += size
+			}
+		}
+		u.ModifiedAt = modified
+	}
+
+	if stateDir := e.localStateDir(); stateDir != "" {
+		states, err := environmentDirs(stateDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read state directory: %w", err)
+		}
+		for _, envID := range states {
+			sizes, modified, err := diskUsage(filepath.Join(stateDir, envID))
+			if err != nil {
+				return nil, fmt.Errorf("failed to measure state of environment %s: %w", envID, err)
+			}
+			u := entry(envID)
+			for _, size := range sizes {
+				u.StateBytes += size
+			}
+			if modified.After(u.ModifiedAt) {
+				u.ModifiedAt = modified
+			}
+		}
+	}
+
+	result := make([]WorkspaceUsage, 0, len(usage))
+	for _, u := range usage {
+		result = append(result, *u)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].EnvironmentID < result[j].EnvironmentID
+	})
+	return result, nil
+}
+
+// PruneWorkspace removes the plan files that interrupted runs left in an
+// environment's workspace, returning the bytes freed. Terraform's data
+// directory and the lock file are kept.
+func (e *Executor) PruneWorkspace(envID string) (int64, error) {
+	if !validEnvironmentID(envID) {
+		return 0, fmt.Errorf("invalid environment ID %q", envID)
+	}
+	return e.removeExpiredPlans(filepath.Join(e.Workspace(envID), plansDir)), nil
+}
+
+// RemoveWorkspace removes an environment's workspace and, if withState is set
+// and the backend is local, its state, returning the bytes freed. State in a
+// remote backend is never touched.
+func (e *Executor) RemoveWorkspace(envID string, withState bool) (int64, error) {
+	if !validEnvironmentID(envID) {
+		return 0, fmt.Errorf("invalid environment ID %q", envID)
+	}
+
+	paths := []string{e.Workspace(envID)}
+	if stateDir := e.localStateDir(); withState && stateDir != "" {
+		paths = append(paths, filepath.Join(stateDir, envID))
+	}
+
+	var freed int64
+	for _, path := range paths {
+		sizes, _, err := diskUsage(path)
+		if err != nil {
+			return freed, err
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return freed, fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		for _, size := range sizes {
+			freed += size
+		}
+	}
+	return freed, nil
+}
+
+// localStateDir returns the directory of the local backend's state, or "" if
+// state is kept elsewhere
+func (e *Executor) localStateDir() string {
+	if local, ok := e.backend.(LocalBackend); ok {
+		return local.Dir
+	}
+	return ""
+}
+
+// environmentDirs lists the names of the environment directories in dir,
+// leaving out hidden directories shared by all environments. A missing dir
+// has none.
+func environmentDirs(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && validEnvironmentID(entry.Name()) && entry.Name() != lostAndFound {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// diskUsage returns the bytes taken by the files under root, by top-level
+// entry, and when any of them last changed. Directories are not counted, as
+// removing expired plans changes them. A missing root takes none.
+func diskUsage(root string) (map[string]int64, time.Time, error) {
+	sizes := map[string]int64{}
+	var modified time.Time
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || path == root {
+			return nil
+		}
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		top := strings.SplitN(rel, string(filepath.Separator), 2)[0]
+		sizes[top] += info.Size()
+		return nil
+	})
+	return sizes, modified, err
+}
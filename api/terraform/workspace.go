@@ -0,0 +1,164 @@
+package terraform
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// backendFile holds the generated backend block of a workspace
+	backendFile = "backend.tf.json"
+
+	// varsFile holds the variables of a workspace's last run
+	varsFile = "terraform.tfvars.json"
+
+	// dataDir is Terraform's per-directory cache of providers and modules,
+	// and lockFile pins the provider versions it holds. Both are kept
+	// between runs.
+	dataDir  = ".terraform"
+	lockFile = ".terraform.lock.hcl"
+)
+
+// Workspace returns the working directory of an environment
+func (e *Executor) Workspace(envID string) string {
+	return filepath.Join(e.workspacePath, envID)
+}
+
+// prepareWorkspace brings an environment's working directory up to date for a
+// run: a fresh copy of the module, the backend block pointing at the
+// environment's state, and the run's variables. Terraform's cached providers
+// and modules are kept.
+func (e *Executor) prepareWorkspace(envID, module string, vars map[string]interface{}) (string, error) {
+	if !validEnvironmentID(envID) {
+		return "", fmt.Errorf("invalid environment ID %q", envID)
+	}
+
+	workPath := e.Workspace(envID)
+	if err := os.MkdirAll(workPath, 0755); err != nil {
+		return "", fmt.Errorf("failed to create work directory: %w", err)
+	}
+
+	// Replace the configuration left by the previous run, so that files
+	// removed from the module do not linger. Saved plans are kept.
+	entries, err := ioutil.ReadDir(workPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read work directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.Name() == dataDir || entry.Name() == lockFile || entry.Name() == plansDir {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(workPath, entry.Name())); err != nil {
+			return "", fmt.Errorf("failed to clean work directory: %w", err)
+		}
+	}
+	if err := copyModule(filepath.Join(e.basePath, module), workPath); err != nil {
+		return "", fmt.Errorf("failed to copy module %s: %w", module, err)
+	}
+
+	// Write the backend block
+	backendType, backendConfig := e.backend.Config(envID)
+	backendJSON, err := json.MarshalIndent(map[string]interface{}{
+		"terraform": map[string]interface{}{
+			"backend": map[string]interface{}{backendType: backendConfig},
+		},
+	}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal backend: %w", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(workPath, backendFile), backendJSON, 0644); err != nil {
+		return "", fmt.Errorf("failed to write backend file: %w", err)
+	}
+
+	// Terragrunt only runs in a directory with a terragrunt.hcl. Modules
+	// written for it bring their own; plain modules run in place.
+	if e.tool == ToolTerragrunt {
+		if err := writeIfMissing(filepath.Join(workPath, terragruntFile), terragruntConfig); err != nil {
+			return "", fmt.Errorf("failed to write Terragrunt configuration: %w", err)
+		}
+	}
+
+	// Write variables file
+	varsJSON, err := json.MarshalIndent(vars, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal variables: %w", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(workPath, varsFile), varsJSON, 0644); err != nil {
+		return "", fmt.Errorf("failed to write variables file: %w", err)
+	}
+
+	return workPath, nil
+}
+
+// writeIfMissing writes data to a new file at path, leaving an existing file
+// alone
+func writeIfMissing(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// validEnvironmentID reports whether envID can name a directory of its own
+func validEnvironmentID(envID string) bool {
+	return envID != "" && envID == filepath.Base(envID) && !strings.HasPrefix(envID, ".")
+}
+
+// copyModule copies a module's files into dst, skipping Terraform's own
+// working files should the module directory contain any
+func copyModule(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == dataDir {
+			return filepath.SkipDir
+		}
+		if strings.HasSuffix(info.Name(), ".tfstate") {
+			return nil
+		}
+
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		return copyFile(path, target, info.Mode())
+	})
+}
+
+// copyFile copies a regular file
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -36,15 +36,24 @@ api.interceptors.response.use(
 );
 
 /**
- * Fetch all environments
- * @param {Object} params - Query parameters
- * @returns {Promise<Array>} Array of environments
+ * Fetch a page of environments
+ * @param {Object} params - Query parameters (userId, teamId, status, limit, cursor, sort, order)
+ * @returns {Promise<Object>} Page of environments ({ items, nextCursor })
  */
 export const fetchEnvironments = async (params = {}) => {
   const response = await api.get('/environments', { params });
   return response.data;
 };
 
+/**
+ * Fetch the environment lifecycle
+ * @returns {Promise<Object>} States, transition table, and the states each operation is allowed in ({ states, transitions, operations })
+ */
+export const fetchLifecycle = async () => {
+  const response = await api.get('/environments/lifecycle');
+  return response.data;
+};
+
 /**
  * Fetch a single environment
  * @param {string} id - Environment ID
@@ -85,6 +94,78 @@ export const deleteEnvironment = async (id) => {
   await api.delete(`/environments/${id}`);
 };
 
+/**
+ * Cancel the operation in progress on an environment
+ * @param {string} id - Environment ID
+ * @returns {Promise<Array>} Cancelled jobs
+ */
+export const cancelEnvironmentOperation = async (id) => {
+  const response = await api.post(`/environments/${id}/cancel`);
+  return response.data;
+};
+
+/**
+ * Re-apply an environment's configuration to undo drift
+ * @param {string} id - Environment ID
+ * @returns {Promise<Object>} Updated environment
+ */
+export const reconcileEnvironment = async (id) => {
+  const response = await api.post(`/environments/${id}/reconcile`);
+  return response.data;
+};
+
+/**
+ * Preview the changes applying an environment's configuration would make
+ * @param {string} id - Environment ID
+ * @returns {Promise<Object>} Saved plan with its change summary
+ */
+export const planEnvironment = async (id) => {
+  const response = await api.post(`/environments/${id}/plan`);
+  return response.data;
+};
+
+/**
+ * Get a saved plan of an environment
+ * @param {string} id - Environment ID
+ * @param {string} planId - Plan ID
+ * @returns {Promise<Object>} Saved plan
+ */
+export const fetchEnvironmentPlan = async (id, planId) => {
+  const response = await api.get(`/environments/${id}/plans/${planId}`);
+  return response.data;
+};
+
+/**
+ * Apply a saved plan of an environment
+ * @param {string} id - Environment ID
+ * @param {string} planId - Plan ID
+ * @returns {Promise<Object>} Updated environment
+ */
+export const applyEnvironmentPlan = async (id, planId) => {
+  const response = await api.post(`/environments/${id}/plans/${planId}/apply`);
+  return response.data;
+};
+
+/**
+ * Get the lock held on an environment by the operation working on it
+ * @param {string} id - Environment ID
+ * @returns {Promise<Object>} Environment lock
+ */
+export const fetchEnvironmentLock = async (id) => {
+  const response = await api.get(`/environments/${id}/lock`);
+  return response.data;
+};
+
+/**
+ * Break a stale lock on an environment (platform admins only)
+ * @param {string} id - Environment ID
+ * @returns {Promise<Object>} The broken lock
+ */
+export const breakEnvironmentLock = async (id) => {
+  const response = await api.delete(`/environments/${id}/lock`);
+  return response.data;
+};
+
 /**
  * Get environment status
  * @param {string} id - Environment ID
@@ -111,14 +192,64 @@ export const fetchEnvironmentMetrics = async (id, timeRange = '24h') => {
 /**
  * Get environment logs
  * @param {string} id - Environment ID
- * @param {Object} params - Query parameters (container, namespace, tail, etc.)
- * @returns {Promise<Array>} Environment logs
+ * @param {Object} params - Query parameters (jobId, after)
+ * @returns {Promise<Array>} Terraform log lines ({ sequence, jobId, phase, stream, text, createdAt })
  */
 export const fetchEnvironmentLogs = async (id, params = {}) => {
   const response = await api.get(`/environments/${id}/logs`, { params });
   return response.data;
 };
 
+/**
+ * Tail environment logs as they are written. EventSource cannot send the
+ * Authorization header, so the Server-Sent Events stream is read with fetch.
+ * @param {string} id - Environment ID
+ * @param {Function} onLine - Called with each log line
+ * @param {Object} options - Query parameters (jobId, after) and an AbortSignal (signal)
+ * @returns {Promise<void>} Resolves when the stream ends
+ */
+export const followEnvironmentLogs = async (id, onLine, { signal, ...params } = {}) => {
+  const query = new URLSearchParams({ ...params, follow: 'true' });
+  const headers = { Accept: 'text/event-stream' };
+  const token = localStorage.getItem('token');
+  if (token) {
+    headers.Authorization = `Bearer ${token}`;
+  }
+
+  const response = await fetch(`${api.defaults.baseURL}/environments/${id}/logs?${query}`, { headers, signal });
+  if (!response.ok) {
+    throw new Error(`Failed to follow logs: ${response.status}`);
+  }
+
+  const reader = response.body.pipeThrough(new TextDecoderStream()).getReader();
+  let buffer = '';
+  for (;;) {
+    const { value, done } = await reader.read();
+    if (done) {
+      return;
+    }
+
+    buffer += value;
+    const messages = buffer.split('\n\n');
+    buffer = messages.pop();
+    for (const message of messages) {
+      const fields = Object.fromEntries(
+        message
+          .split('\n')
+          .filter((field) => field && !field.startsWith(':'))
+          .map((field) => [field.slice(0, field.indexOf(':')), field.slice(field.indexOf(':') + 1).trim()])
+      );
+      if (fields.event === 'end') {
+        reader.cancel();
+        return;
+      }
+      if (fields.event === 'log') {
+        onLine(JSON.parse(fields.data));
+      }
+    }
+  }
+};
+
 /**
  * Get environment events
  * @param {string} id - Environment ID
@@ -187,9 +318,17 @@ export default {
   createEnvironment,
   updateEnvironment,
   deleteEnvironment,
+  cancelEnvironmentOperation,
+  reconcileEnvironment,
+  planEnvironment,
+  fetchEnvironmentPlan,
+  applyEnvironmentPlan,
+  fetchEnvironmentLock,
+  breakEnvironmentLock,
   fetchEnvironmentStatus,
   fetchEnvironmentMetrics,
   fetchEnvironmentLogs,
+  followEnvironmentLogs,
   fetchEnvironmentEvents,
   restartEnvironment,
   upgradeEnvironment,
@@ -67,7 +67,6 @@ const CreateEnvironment = () => {
     name: '',
     description: '',
     templateId: '',
-    userId: user?.id || '',
     resourceLimits: {
       cpu: '2',
       memory: '4Gi',
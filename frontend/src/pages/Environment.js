@@ -1,5 +1,5 @@
 import React, { useState } from 'react';
-import { useQuery } from 'react-query';
+import { useQuery, useInfiniteQuery } from 'react-query';
 import { Link } from 'react-router-dom';
 import {
   Box,
@@ -34,7 +34,7 @@ import {
 } from '@chakra-ui/react';
 import { AddIcon, ChevronDownIcon, SearchIcon } from '@chakra-ui/icons';
 import { FaEllipsisV } from 'react-icons/fa';
-import { fetchEnvironments, deleteEnvironment } from '../api/environments';
+import { fetchEnvironments, fetchLifecycle, deleteEnvironment } from '../api/environments';
 import { useAuth } from '../contexts/AuthContext';
 import StatusBadge from '../components/StatusBadge';
 import DateFormat from '../components/DateFormat';
@@ -50,16 +50,37 @@ const Environments = () => {
   const [environmentToDelete, setEnvironmentToDelete] = useState(null);
   const cancelRef = React.useRef();
 
-  // Fetch environments
-  const { data: environments, isLoading, isError, error, refetch } = useQuery(
-    ['environments', user?.id],
-    () => fetchEnvironments({ userId: user?.id }),
+  // Fetch environments a page at a time, filtered by status on the server
+  const {
+    data,
+    isLoading,
+    isError,
+    error,
+    refetch,
+    fetchNextPage,
+    hasNextPage,
+    isFetchingNextPage,
+  } = useInfiniteQuery(
+    ['environments', user?.id, statusFilter],
+    ({ pageParam }) =>
+      fetchEnvironments({ status: statusFilter || undefined, cursor: pageParam }),
     {
       enabled: !!user,
+      keepPreviousData: true,
+      getNextPageParam: (lastPage) => lastPage.nextCursor || undefined,
     }
   );
+  const environments = data?.pages.flatMap((page) => page.items) ?? [];
 
   // Handle environment deletion
+  // Fetch the lifecycle to know which actions each status allows
+  const { data: lifecycle } = useQuery('lifecycle', fetchLifecycle, {
+    enabled: !!user,
+    staleTime: Infinity,
+  });
+  const canDelete = (environment) =>
+    lifecycle?.operations.delete.includes(environment.status) ?? false;
+
   const handleDeleteClick = (environment) => {
     setEnvironmentToDelete(environment);
     onOpen();
@@ -90,13 +111,11 @@ const Environments = () => {
     }
   };
 
-  // Filter environments
-  const filteredEnvironments = environments?.filter((env) => {
-    const matchesSearch = env.name.toLowerCase().includes(searchTerm.toLowerCase()) ||
-      env.description.toLowerCase().includes(searchTerm.toLowerCase());
-    const matchesStatus = statusFilter === '' || env.status === statusFilter;
-    return matchesSearch && matchesStatus;
-  }) || [];
+  // Search the loaded environments
+  const filteredEnvironments = environments.filter((env) =>
+    env.name.toLowerCase().includes(searchTerm.toLowerCase()) ||
+    env.description.toLowerCase().includes(searchTerm.toLowerCase())
+  );
 
   // Render loading state
   if (isLoading) {
@@ -113,7 +132,7 @@ const Environments = () => {
   }
 
   // Render empty state
-  if (environments?.length === 0) {
+  if (environments.length === 0 && statusFilter === '') {
     return (
       <EmptyState
         title="No environments found"
@@ -155,6 +174,7 @@ const Environments = () => {
         >
           <option value="">All Statuses</option>
           <option value="CREATING">Creating</option>
+          <option value="PROVISIONING">Provisioning</option>
           <option value="ACTIVE">Active</option>
           <option value="UPDATING">Updating</option>
           <option value="ERROR">Error</option>
@@ -220,7 +240,7 @@ const Environments = () => {
                         View Details
                       </MenuItem>
                       <MenuItem
-                        isDisabled={environment.status === 'DELETING'}
+                        isDisabled={!canDelete(environment)}
                         onClick={() => handleDeleteClick(environment)}
                         color="red.500"
                       >
@@ -235,6 +255,18 @@ const Environments = () => {
         </Table>
       </Box>
 
+      {hasNextPage && (
+        <Flex justify="center" mt={4}>
+          <Button
+            onClick={() => fetchNextPage()}
+            isLoading={isFetchingNextPage}
+            variant="outline"
+          >
+            Load more
+          </Button>
+        </Flex>
+      )}
+
       {/* Delete Confirmation Dialog */}
       <AlertDialog
         isOpen={isOpen}
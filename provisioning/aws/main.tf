@@ -22,11 +22,11 @@ data "aws_eks_cluster_auth" "cluster" {
 
 locals {
   cluster_name = var.cluster_name
-  tags = {
+  tags = merge(var.tags, {
     Environment = var.environment
     Project     = "k8s-provisioner"
     ManagedBy   = "terraform"
-  }
+  })
 }
 
 module "vpc" {
@@ -0,0 +1,50 @@
+# Outputs the API reads after an apply
+
+output "cluster_name" {
+  description = "Name of the EKS cluster"
+  value       = module.eks.cluster_name
+}
+
+output "cluster_endpoint" {
+  description = "Endpoint of the cluster's Kubernetes API"
+  value       = module.eks.cluster_endpoint
+}
+
+output "kubeconfig" {
+  description = "Kubeconfig reaching the cluster with the AWS credentials of its user"
+  sensitive   = true
+  value = yamlencode({
+    apiVersion      = "v1"
+    kind            = "Config"
+    current-context = module.eks.cluster_name
+    clusters = [{
+      name = module.eks.cluster_name
+      cluster = {
+        server                     = module.eks.cluster_endpoint
+        certificate-authority-data = module.eks.cluster_certificate_authority_data
+      }
+    }]
+    contexts = [{
+      name = module.eks.cluster_name
+      context = {
+        cluster = module.eks.cluster_name
+        user    = module.eks.cluster_name
+      }
+    }]
+    users = [{
+      name = module.eks.cluster_name
+      user = {
+        exec = {
+          apiVersion = "client.authentication.k8s.io/v1beta1"
+          command    = "aws"
+          args       = ["eks", "get-token", "--cluster-name", module.eks.cluster_name, "--region", var.aws_region]
+        }
+      }
+    }]
+  })
+}
+
+output "console_url" {
+  description = "AWS console page of the cluster"
+  value       = "https://${var.aws_region}.console.aws.amazon.com/eks/home?region=${var.aws_region}#/clusters/${module.eks.cluster_name}"
+}
@@ -0,0 +1,105 @@
+# Variables of an environment's cluster. The API writes them to
+# terraform.tfvars.json from the environment and its cluster template.
+
+variable "cluster_name" {
+  description = "Name of the EKS cluster and prefix of its resources"
+  type        = string
+}
+
+variable "aws_region" {
+  description = "AWS region the cluster runs in"
+  type        = string
+}
+
+variable "environment" {
+  description = "Environment tier; anything but production shares one NAT gateway"
+  type        = string
+  default     = "dev"
+}
+
+variable "instance_types" {
+  description = "EC2 instance types of the node groups"
+  type        = list(string)
+  default     = ["m5.large"]
+}
+
+variable "min_nodes" {
+  description = "Minimum size of the application node group"
+  type        = number
+  default     = 1
+}
+
+variable "max_nodes" {
+  description = "Maximum size of the application node group"
+  type        = number
+  default     = 3
+}
+
+variable "desired_nodes" {
+  description = "Desired size of the application node group"
+  type        = number
+  default     = 2
+}
+
+variable "kubernetes_version" {
+  description = "Kubernetes version of the EKS control plane"
+  type        = string
+  default     = "1.27"
+}
+
+variable "vpc_cidr" {
+  description = "CIDR block of the cluster's VPC"
+  type        = string
+  default     = "10.0.0.0/16"
+}
+
+variable "resource_limits" {
+  description = "Resource limits of the environment; quotas inside the cluster are not managed yet"
+  type = object({
+    cpu              = string
+    memory           = string
+    storage          = string
+    maxNodeCount     = number
+    maxNamespaces    = number
+    maxLoadBalancers = number
+  })
+  default = null
+}
+
+variable "network_policy" {
+  description = "Network policy of the environment; not applied inside the cluster yet"
+  type        = any
+  default     = null
+}
+
+variable "service_mesh" {
+  description = "Service mesh configuration of the environment; not applied inside the cluster yet"
+  type        = any
+  default     = null
+}
+
+variable "monitoring" {
+  description = "Monitoring configuration of the environment; not applied inside the cluster yet"
+  type        = any
+  default     = null
+}
+
+variable "gitops" {
+  description = "GitOps configuration of the environment; not applied inside the cluster yet"
+  type        = any
+  default     = null
+}
+
+variable "addons" {
+  description = "Addons enabled for the environment"
+  type        = list(string)
+  default     = []
+  nullable    = false
+}
+
+variable "tags" {
+  description = "Extra AWS tags of the environment's resources"
+  type        = map(string)
+  default     = {}
+  nullable    = false
+}